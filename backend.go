@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"slices"
+
+	"github.com/go-rod/rod/lib/input"
+)
+
+// Backends of VHS, set with Set Backend.
+const (
+	// BackendBrowser renders the terminal with xterm.js in a headless
+	// browser, connected to the shell through ttyd.
+	BackendBrowser = "browser"
+	// BackendNative runs the shell in a pseudo-terminal, emulates the
+	// terminal and rasterizes it in Go.
+	BackendNative = "native"
+)
+
+// Backend is a terminal running a shell, which VHS drives and records.
+type Backend interface {
+	// Start starts the shell in the terminal.
+	Start(shell Shell) error
+	// Configure applies the options to the terminal, and fits the terminal
+	// into a viewport of the given size in pixels.
+	Configure(opts TerminalOptions, viewport image.Point) error
+	// Type types the text.
+	Type(text string) error
+	// Key presses the key while holding down the modifiers.
+	Key(key input.Key, modifiers ...input.Key) error
+	// Scroll scrolls the viewport of the terminal by the number of lines.
+	Scroll(lines int) error
+	// Buffer returns the lines of the terminal.
+	Buffer() ([]string, error)
+	// CurrentLine returns the line of the cursor.
+	CurrentLine() (string, error)
+	// CaptureFrame captures the cursor and text layers of the terminal as
+	// PNG images of the same size.
+	CaptureFrame() (cursor []byte, text []byte, err error)
+	// Screen returns the cells of the terminal with their attributes, and
+	// its cursor.
+	Screen() (screen, error)
+	// Output returns the output of the shell which the terminal received
+	// since the last call, if it is kept with the Output terminal option.
+	Output() ([]byte, error)
+	// Flush waits for the terminal to draw the output it has received.
+	Flush() error
+	// Close terminates the shell and the terminal. It may be called more
+	// than once.
+	Close() error
+}
+
+// TerminalOptions are the options of the terminal of a Backend.
+type TerminalOptions struct {
+	FontFamily    string
+	FontSize      int
+	LetterSpacing float64
+	LineHeight    float64
+	Theme         Theme
+	CursorBlink   bool
+	// Output keeps the output of the shell for Output, e.g. for the
+	// asciicast. Otherwise it isn't kept.
+	Output bool
+}
+
+// screen is a snapshot of the cells of a terminal, with their colors resolved
+// with its theme.
+type screen struct {
+	cells         [][]cell
+	cursor        image.Point
+	cursorVisible bool
+	// size is the size of a cell in pixels.
+	size image.Point
+}
+
+// equal reports whether the screens show the same cells and cursor.
+func (s screen) equal(o screen) bool {
+	if s.cursor != o.cursor || s.cursorVisible != o.cursorVisible || s.size != o.size || len(s.cells) != len(o.cells) {
+		return false
+	}
+	for y, row := range s.cells {
+		if !slices.Equal(row, o.cells[y]) {
+			return false
+		}
+	}
+	return true
+}
+
+// newBackend returns the backend with the given name.
+func newBackend(name string) (Backend, error) {
+	switch name {
+	case BackendBrowser:
+		return &browserBackend{}, nil
+	case BackendNative:
+		return newNativeBackend()
+	default:
+		return nil, fmt.Errorf("invalid backend %s", name)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/input"
+	"github.com/go-rod/rod/lib/launcher"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// browserBackend is a Backend which renders the terminal with xterm.js in a
+// headless browser, connected to the shell through ttyd.
+type browserBackend struct {
+	page         *rod.Page
+	browser      *rod.Browser
+	textCanvas   *rod.Element
+	cursorCanvas *rod.Element
+	tty          *exec.Cmd
+	once         sync.Once
+	// theme is the theme of xterm.js, which resolves the colors of Screen.
+	theme Theme
+}
+
+// Start starts ttyd and the browser, and waits for xterm.js to be ready.
+func (b *browserBackend) Start(shell Shell) error {
+	if err := ensureTtyd(); err != nil {
+		return err
+	}
+
+	port := randomPort()
+	b.tty = buildTtyCmd(port, shell)
+	if err := b.tty.Start(); err != nil {
+		return fmt.Errorf("could not start tty: %w", err)
+	}
+
+	path, _ := launcher.LookPath()
+	enableNoSandbox := os.Getenv("VHS_NO_SANDBOX") != ""
+	u, err := launcher.New().Leakless(false).Bin(path).NoSandbox(enableNoSandbox).Launch()
+	if err != nil {
+		return fmt.Errorf("could not launch browser: %w", err)
+	}
+	b.browser = rod.New().ControlURL(u).MustConnect()
+	b.page, err = b.browser.Page(proto.TargetCreateTarget{URL: fmt.Sprintf("http://localhost:%d", port)})
+	if err != nil {
+		return fmt.Errorf("could not open ttyd: %w", err)
+	}
+
+	// Let's wait until we can access the window.term variable.
+	if err := b.page.Wait(rod.Eval("() => window.term != undefined")); err != nil {
+		return fmt.Errorf("could not load terminal: %w", err)
+	}
+
+	// Keep the output written to xterm.js for Output.
+	if _, err := b.page.Eval(outputScript); err != nil {
+		return fmt.Errorf("could not record terminal output: %w", err)
+	}
+	return nil
+}
+
+// Configure sets the viewport of the page and the options of xterm.js, and
+// fits the terminal into the page.
+func (b *browserBackend) Configure(opts TerminalOptions, viewport image.Point) error {
+	err := b.page.SetViewport(&proto.EmulationSetDeviceMetricsOverride{
+		Width:  viewport.X,
+		Height: viewport.Y,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to resize page: %w", err)
+	}
+
+	// Find xterm.js canvases for the text and cursor layer for recording.
+	if b.textCanvas == nil {
+		b.textCanvas, _ = b.page.Element("canvas.xterm-text-layer")
+		b.cursorCanvas, _ = b.page.Element("canvas.xterm-cursor-layer")
+	}
+
+	_, err = b.page.Eval(fmt.Sprintf("() => { term.options = { fontSize: %d, fontFamily: '%s', letterSpacing: %f, lineHeight: %f, theme: %s, cursorBlink: %t }; window.vhsOutputKept = %t; }",
+		opts.FontSize, opts.FontFamily, opts.LetterSpacing,
+		opts.LineHeight, opts.Theme.String(), opts.CursorBlink, opts.Output))
+	if err != nil {
+		return fmt.Errorf("failed to set terminal options: %w", err)
+	}
+	b.theme = opts.Theme
+
+	// Fit the terminal into the window
+	if _, err := b.page.Eval("term.fit"); err != nil {
+		return fmt.Errorf("failed to fit terminal: %w", err)
+	}
+	return nil
+}
+
+// Type types the text, with the keys of the keymap or as input of the
+// terminal for other characters.
+func (b *browserBackend) Type(text string) error {
+	for _, r := range text {
+		if k, ok := keymap[r]; ok {
+			if err := b.page.Keyboard.Type(k); err != nil {
+				return fmt.Errorf("failed to type key %c: %w", r, err)
+			}
+			continue
+		}
+		if err := b.page.MustElement("textarea").Input(string(r)); err != nil {
+			return fmt.Errorf("failed to input text: %w", err)
+		}
+		b.page.MustWaitIdle()
+	}
+	return nil
+}
+
+// Key presses the key while holding down the modifiers.
+func (b *browserBackend) Key(key input.Key, modifiers ...input.Key) error {
+	if len(modifiers) == 0 {
+		if err := b.page.Keyboard.Type(key); err != nil {
+			return fmt.Errorf("failed to type key %c: %w", key, err)
+		}
+		return nil
+	}
+	if err := b.page.KeyActions().Press(modifiers...).Type(key).Do(); err != nil {
+		return fmt.Errorf("failed to type key %c: %w", key, err)
+	}
+	return nil
+}
+
+// Scroll scrolls the viewport of xterm.js.
+func (b *browserBackend) Scroll(lines int) error {
+	if _, err := b.page.Eval(fmt.Sprintf("() => term.scrollLines(%d)", lines)); err != nil {
+		return fmt.Errorf("failed to scroll viewport: %w", err)
+	}
+	return nil
+}
+
+// Buffer returns the lines of the active buffer of xterm.js.
+func (b *browserBackend) Buffer() ([]string, error) {
+	buf, err := b.page.Eval("() => Array(term.rows).fill(0).map((e, i) => term.buffer.active.getLine(i).translateToString().trimEnd())")
+	if err != nil {
+		return nil, fmt.Errorf("read buffer: %w", err)
+	}
+
+	var lines []string
+	for _, line := range buf.Value.Arr() {
+		lines = append(lines, line.Str())
+	}
+	return lines, nil
+}
+
+// CurrentLine returns the line of the cursor in the active buffer of xterm.js.
+func (b *browserBackend) CurrentLine() (string, error) {
+	line, err := b.page.Eval("() => term.buffer.active.getLine(term.buffer.active.cursorY+term.buffer.active.viewportY).translateToString().trimEnd()")
+	if err != nil || line == nil {
+		return "", fmt.Errorf("read current line from buffer: %w", err)
+	}
+	return line.Value.Str(), nil
+}
+
+// CaptureFrame captures the cursor and text canvases of xterm.js.
+func (b *browserBackend) CaptureFrame() ([]byte, []byte, error) {
+	cursor, cursorErr := b.cursorCanvas.CanvasToImage("image/png", quality)
+	text, textErr := b.textCanvas.CanvasToImage("image/png", quality)
+	if textErr != nil || cursorErr != nil {
+		return nil, nil, fmt.Errorf("error: %v, %v", textErr, cursorErr)
+	}
+	return cursor, text, nil
+}
+
+// screenScript returns the visible cells of xterm.js as JSON, with the colors
+// encoded as -1 for the default color, the index of a palette color, or an RGB
+// color flagged with rgbColor. The size of the cells is read from the text
+// canvas.
+const screenScript = `() => {
+	const buffer = term.buffer.active;
+	const canvas = document.querySelector('canvas.xterm-text-layer');
+	const color = (isDefault, isRGB, value) => isDefault ? -1 : isRGB ? 0x1000000 | value : value;
+	const rows = [];
+	for (let y = 0; y < term.rows; y++) {
+		const line = buffer.getLine(buffer.viewportY + y);
+		const cells = [];
+		for (let x = 0; x < term.cols; x++) {
+			const c = line && line.getCell(x);
+			if (!c) {
+				cells.push(['', -1, -1, 0]);
+				continue;
+			}
+			cells.push([
+				c.getChars(),
+				color(c.isFgDefault(), c.isFgRGB(), c.getFgColor()),
+				color(c.isBgDefault(), c.isBgRGB(), c.getBgColor()),
+				(c.isBold() ? 1 : 0) | (c.isUnderline() ? 2 : 0) | (c.isInverse() ? 4 : 0),
+			]);
+		}
+		rows.push(cells);
+	}
+	return JSON.stringify({
+		rows,
+		x: buffer.cursorX,
+		y: buffer.baseY + buffer.cursorY - buffer.viewportY,
+		hidden: !!(term._core && term._core.coreService && term._core.coreService.isCursorHidden),
+		width: canvas ? canvas.width / term.cols : 0,
+		height: canvas ? canvas.height / term.rows : 0,
+	});
+}`
+
+// Attributes of the cells of screenScript.
+const (
+	browserBold = 1 << iota
+	browserUnderline
+	browserInverse
+)
+
+// rgbColor flags the RGB colors of screenScript.
+const rgbColor = 0x1000000
+
+// Screen returns the visible cells of xterm.js.
+func (b *browserBackend) Screen() (screen, error) {
+	res, err := b.page.Eval(screenScript)
+	if err != nil {
+		return screen{}, fmt.Errorf("read screen: %w", err)
+	}
+
+	var snapshot struct {
+		Rows          [][][]json.RawMessage
+		X, Y          int
+		Hidden        bool
+		Width, Height float64
+	}
+	if err := json.Unmarshal([]byte(res.Value.Str()), &snapshot); err != nil {
+		return screen{}, fmt.Errorf("read screen: %w", err)
+	}
+
+	s := screen{
+		cells:         make([][]cell, len(snapshot.Rows)),
+		cursor:        image.Pt(snapshot.X, snapshot.Y),
+		cursorVisible: !snapshot.Hidden,
+		size:          image.Pt(int(math.Round(snapshot.Width)), int(math.Round(snapshot.Height))),
+	}
+	for y, row := range snapshot.Rows {
+		s.cells[y] = make([]cell, len(row))
+		for x, attrs := range row {
+			var (
+				chars         string
+				fg, bg, flags int
+			)
+			if len(attrs) == 4 { //nolint:mnd
+				_ = json.Unmarshal(attrs[0], &chars)
+				_ = json.Unmarshal(attrs[1], &fg)
+				_ = json.Unmarshal(attrs[2], &bg)
+				_ = json.Unmarshal(attrs[3], &flags)
+			}
+			s.cells[y][x] = b.cell(chars, fg, bg, flags)
+		}
+	}
+	return s, nil
+}
+
+// cell returns the cell of the attributes of a cell of screenScript.
+//
+//nolint:mnd
+func (b *browserBackend) cell(chars string, fg, bg, flags int) cell {
+	c := cell{
+		Bold:      flags&browserBold != 0,
+		Underline: flags&browserUnderline != 0,
+	}
+	if r := []rune(chars); len(r) > 0 {
+		c.Char = r[0]
+	}
+
+	// xterm.js draws bold text in the bright variant of its color.
+	if c.Bold && fg >= 0 && fg < 8 {
+		fg += 8
+	}
+	c.FG = b.color(fg, b.theme.Foreground)
+	c.BG = b.color(bg, b.theme.Background)
+	if flags&browserInverse != 0 {
+		c.FG, c.BG = c.BG, c.FG
+	}
+	return c
+}
+
+// color returns the color of screenScript, or the given color of the theme
+// for the default color.
+func (b *browserBackend) color(c int, fallback string) color.Color {
+	switch {
+	case c < 0:
+		return themeColor(fallback)
+	case c&rgbColor != 0:
+		return color.RGBA{uint8(c >> 16), uint8(c >> 8), uint8(c), 0xFF} //nolint:gosec,mnd
+	default:
+		return themePalette(b.theme, c&0xFF) //nolint:mnd
+	}
+}
+
+// outputScript wraps the writes of ttyd to xterm.js, to keep their output
+// until Output reads it when the output is kept.
+const outputScript = `() => {
+	const decoder = new TextDecoder();
+	const write = term.write.bind(term);
+	window.vhsOutput = [];
+	window.vhsOutputKept = false;
+	term.write = (data, callback) => {
+		if (window.vhsOutputKept) {
+			window.vhsOutput.push(typeof data === 'string' ? data : decoder.decode(data, { stream: true }));
+		}
+		return write(data, callback);
+	};
+}`
+
+// Output returns the output written to xterm.js since the last call.
+func (b *browserBackend) Output() ([]byte, error) {
+	out, err := b.page.Eval("() => { const out = window.vhsOutput.join(''); window.vhsOutput = []; return out; }")
+	if err != nil {
+		return nil, fmt.Errorf("read output: %w", err)
+	}
+	return []byte(out.Value.Str()), nil
+}
+
+// Flush waits for xterm.js to paint the last changes on its canvases.
+func (b *browserBackend) Flush() error {
+	_, err := b.page.Eval("() => new Promise(r => requestAnimationFrame(() => requestAnimationFrame(r)))")
+	if err != nil {
+		return fmt.Errorf("failed to wait for paint: %w", err)
+	}
+	return nil
+}
+
+// Close closes the browser and kills ttyd.
+//
+//nolint:wrapcheck
+func (b *browserBackend) Close() error {
+	var err error
+	b.once.Do(func() {
+		if b.browser != nil {
+			_ = b.browser.Close()
+		}
+		if b.tty != nil && b.tty.Process != nil {
+			err = b.tty.Process.Kill()
+		}
+	})
+	return err
+}
@@ -0,0 +1,335 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/creack/pty"
+	"github.com/go-rod/rod/lib/input"
+	"github.com/hinshun/vt10x"
+)
+
+// Attributes of the glyphs of vt10x, which it doesn't export.
+const (
+	glyphReverse = 1 << iota
+	glyphUnderline
+	glyphBold
+)
+
+const (
+	defaultCols = 80
+	defaultRows = 24
+)
+
+// nativeBackend is a Backend which runs the shell in a pseudo-terminal,
+// emulates the terminal with vt10x and rasterizes its cells in Go, without
+// ttyd or a browser.
+type nativeBackend struct {
+	cmd  *exec.Cmd
+	pty  *os.File
+	term vt10x.Terminal
+	once sync.Once
+	// output is the output of the shell since the last call to Output.
+	output outputBuffer
+	// scrolled is set once Scroll has warned that it is ignored.
+	scrolled sync.Once
+
+	// mutex guards the rasterizer, which changes with the options.
+	mutex  sync.Mutex
+	raster *rasterizer
+}
+
+// newNativeBackend returns a native backend.
+func newNativeBackend() (Backend, error) {
+	return &nativeBackend{}, nil
+}
+
+// Start starts the shell in a pseudo-terminal, and the emulation of its
+// output.
+func (b *nativeBackend) Start(shell Shell) error {
+	if len(shell.Command) == 0 {
+		return errors.New("shell has no command")
+	}
+	b.cmd = exec.Command(shell.Command[0], shell.Command[1:]...) //nolint:gosec
+	b.cmd.Env = slices.Concat(shell.Env, os.Environ(), []string{"TERM=xterm-256color"})
+
+	var err error
+	b.pty, err = pty.StartWithSize(b.cmd, &pty.Winsize{Cols: defaultCols, Rows: defaultRows})
+	if err != nil {
+		return fmt.Errorf("could not start shell: %w", err)
+	}
+
+	// The terminal answers the queries of the shell, e.g. for the position
+	// of the cursor, on the pseudo-terminal.
+	b.term = vt10x.New(vt10x.WithWriter(b.pty), vt10x.WithSize(defaultCols, defaultRows))
+	go func() {
+		r := bufio.NewReader(io.TeeReader(b.pty, &b.output))
+		for b.term.Parse(r) == nil {
+		}
+	}()
+	return nil
+}
+
+// Configure rasterizes the terminal with the options, and resizes it to the
+// number of cells which fit in the viewport.
+func (b *nativeBackend) Configure(opts TerminalOptions, viewport image.Point) error {
+	raster, err := newRasterizer(opts)
+	if err != nil {
+		return err
+	}
+	cols, rows := raster.grid(viewport)
+
+	b.mutex.Lock()
+	b.raster = raster
+	b.mutex.Unlock()
+	b.output.keep(opts.Output)
+
+	b.term.Resize(cols, rows)
+	err = pty.Setsize(b.pty, &pty.Winsize{
+		Cols: uint16(cols), //nolint:gosec
+		Rows: uint16(rows), //nolint:gosec
+		X:    uint16(viewport.X),
+		Y:    uint16(viewport.Y),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to resize terminal: %w", err)
+	}
+	return nil
+}
+
+// Type writes the text to the shell, with new lines sent as Enter.
+func (b *nativeBackend) Type(text string) error {
+	return b.write(strings.ReplaceAll(text, "\n", "\r"))
+}
+
+// Key writes the sequence of the key to the shell.
+func (b *nativeBackend) Key(key input.Key, modifiers ...input.Key) error {
+	seq, err := keySequence(key, modifiers...)
+	if err != nil {
+		return err
+	}
+	return b.write(seq)
+}
+
+// write writes to the shell.
+func (b *nativeBackend) write(s string) error {
+	if _, err := b.pty.WriteString(s); err != nil {
+		return fmt.Errorf("failed to write to terminal: %w", err)
+	}
+	return nil
+}
+
+// Scroll isn't supported, since the terminal has no scrollback. It is
+// ignored, with a warning, so that the rest of the tape still runs.
+func (b *nativeBackend) Scroll(int) error {
+	b.scrolled.Do(func() {
+		fmt.Println(ErrorStyle.Render("WARN: Scroll has been ignored, it isn't supported by the native backend.\nLearn more: https://github.com/charmbracelet/vhs#set-backend"))
+	})
+	return nil
+}
+
+// Buffer returns the lines of the screen.
+func (b *nativeBackend) Buffer() ([]string, error) {
+	b.term.Lock()
+	defer b.term.Unlock()
+
+	cols, rows := b.term.Size()
+	lines := make([]string, 0, rows)
+	for y := range rows {
+		lines = append(lines, b.line(y, cols))
+	}
+	return lines, nil
+}
+
+// CurrentLine returns the line of the cursor.
+func (b *nativeBackend) CurrentLine() (string, error) {
+	b.term.Lock()
+	defer b.term.Unlock()
+
+	cols, _ := b.term.Size()
+	return b.line(b.term.Cursor().Y, cols), nil
+}
+
+// line returns the characters of a row of the screen. The terminal must be
+// locked.
+func (b *nativeBackend) line(y, cols int) string {
+	var sb strings.Builder
+	for x := range cols {
+		r := b.term.Cell(x, y).Char
+		if r == 0 {
+			r = ' '
+		}
+		sb.WriteRune(r)
+	}
+	return strings.TrimRight(sb.String(), " ")
+}
+
+// CaptureFrame rasterizes the cursor and text layers of the screen.
+func (b *nativeBackend) CaptureFrame() ([]byte, []byte, error) {
+	raster, err := b.rasterizer()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s := b.screen(raster)
+	cursor, err := encodeFrame(raster.cursor(s.cells, s.cursor, s.cursorVisible))
+	if err != nil {
+		return nil, nil, err
+	}
+	text, err := encodeFrame(raster.text(s.cells))
+	if err != nil {
+		return nil, nil, err
+	}
+	return cursor, text, nil
+}
+
+// Screen returns the cells of the screen.
+func (b *nativeBackend) Screen() (screen, error) {
+	raster, err := b.rasterizer()
+	if err != nil {
+		return screen{}, err
+	}
+	return b.screen(raster), nil
+}
+
+// rasterizer returns the rasterizer of the options of the terminal.
+func (b *nativeBackend) rasterizer() (*rasterizer, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.raster == nil {
+		return nil, errors.New("terminal is not configured")
+	}
+	return b.raster, nil
+}
+
+// screen returns the cells of the screen to rasterize.
+func (b *nativeBackend) screen(raster *rasterizer) screen {
+	b.term.Lock()
+	defer b.term.Unlock()
+
+	cols, rows := b.term.Size()
+	cells := make([][]cell, rows)
+	for y := range rows {
+		cells[y] = make([]cell, cols)
+		for x := range cols {
+			cells[y][x] = b.cell(raster, b.term.Cell(x, y))
+		}
+	}
+	pos := b.term.Cursor()
+	return screen{
+		cells:         cells,
+		cursor:        image.Pt(pos.X, pos.Y),
+		cursorVisible: b.term.CursorVisible(),
+		size:          raster.size,
+	}
+}
+
+// cell returns the cell to rasterize for a glyph of the terminal.
+//
+//nolint:mnd
+func (b *nativeBackend) cell(raster *rasterizer, g vt10x.Glyph) cell {
+	c := cell{
+		Char:      g.Char,
+		Bold:      g.Mode&glyphBold != 0,
+		Underline: g.Mode&glyphUnderline != 0,
+	}
+
+	// Like xterm.js, bold text is drawn in the bright variant of its color.
+	fg := g.FG
+	if c.Bold && fg < 8 {
+		fg += 8
+	}
+	c.FG = b.color(raster, fg, raster.theme.Foreground)
+	c.BG = b.color(raster, g.BG, raster.theme.Background)
+	if g.Mode&glyphReverse != 0 {
+		c.FG, c.BG = c.BG, c.FG
+	}
+	return c
+}
+
+// color returns the color of the terminal, or the given color of the theme
+// for the default colors.
+func (b *nativeBackend) color(raster *rasterizer, c vt10x.Color, fallback string) color.Color {
+	if c == vt10x.DefaultFG || c == vt10x.DefaultBG || c > 255 { //nolint:mnd
+		return raster.color(fallback)
+	}
+	return raster.palette(int(c))
+}
+
+// Output returns the output of the shell since the last call.
+func (b *nativeBackend) Output() ([]byte, error) {
+	return b.output.drain(), nil
+}
+
+// outputBuffer is a buffer of the output of the shell, written by the
+// emulation of the terminal and drained while recording. The output is only
+// kept when it is read, otherwise it is dropped.
+type outputBuffer struct {
+	mutex   sync.Mutex
+	buf     []byte
+	enabled bool
+}
+
+// Write appends the output to the buffer, if it is kept.
+func (o *outputBuffer) Write(p []byte) (int, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.enabled {
+		o.buf = append(o.buf, p...)
+	}
+	return len(p), nil
+}
+
+// keep sets whether the output is kept, dropping the buffered output when it
+// isn't.
+func (o *outputBuffer) keep(enabled bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.enabled = enabled
+	if !enabled {
+		o.buf = nil
+	}
+}
+
+// drain returns the output of the buffer and empties it.
+func (o *outputBuffer) drain() []byte {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	out := o.buf
+	o.buf = nil
+	return out
+}
+
+// Flush does nothing: the terminal is drawn when a frame is captured.
+func (b *nativeBackend) Flush() error {
+	return nil
+}
+
+// Close kills the shell and closes the pseudo-terminal.
+//
+//nolint:wrapcheck
+func (b *nativeBackend) Close() error {
+	var err error
+	b.once.Do(func() {
+		if b.cmd != nil && b.cmd.Process != nil {
+			_ = b.cmd.Process.Kill()
+			_ = b.cmd.Wait()
+		}
+		if b.pty != nil {
+			err = b.pty.Close()
+		}
+	})
+	return err
+}
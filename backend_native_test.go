@@ -0,0 +1,39 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import "testing"
+
+func TestOutputBuffer(t *testing.T) {
+	var o outputBuffer
+	_, _ = o.Write([]byte("dropped"))
+	if out := o.drain(); len(out) != 0 {
+		t.Errorf("expected the output not to be kept, got %q", out)
+	}
+
+	o.keep(true)
+	_, _ = o.Write([]byte("a"))
+	_, _ = o.Write([]byte("b"))
+	if out := o.drain(); string(out) != "ab" {
+		t.Errorf("expected the kept output, got %q", out)
+	}
+	if out := o.drain(); len(out) != 0 {
+		t.Errorf("expected the output to be drained, got %q", out)
+	}
+
+	_, _ = o.Write([]byte("c"))
+	o.keep(false)
+	if out := o.drain(); len(out) != 0 {
+		t.Errorf("expected the output to be dropped, got %q", out)
+	}
+}
+
+func TestNativeScroll(t *testing.T) {
+	var b nativeBackend
+	for range 2 {
+		if err := b.Scroll(3); err != nil {
+			t.Errorf("expected Scroll to be ignored, got %v", err)
+		}
+	}
+}
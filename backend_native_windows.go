@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "errors"
+
+// newNativeBackend returns an error: the native backend relies on a
+// pseudo-terminal, which isn't supported on Windows.
+func newNativeBackend() (Backend, error) {
+	return nil, errors.New("the native backend is not supported on Windows")
+}
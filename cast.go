@@ -0,0 +1,323 @@
+// Package vhs cast.go writes the output of the terminal as an asciicast v2
+// file for asciinema players, and converts asciicasts into tapes.
+//
+// Output demo.cast
+//
+//	vhs convert demo.cast -o demo.tape
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/vhs/token"
+)
+
+// castVersion is the version of the asciicast format.
+const castVersion = 2
+
+// Types of the events of an asciicast.
+const (
+	castOutput = "o"
+	castInput  = "i"
+)
+
+// castHeader is the header of an asciicast, on its first line.
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	Theme     *castTheme        `json:"theme,omitempty"`
+}
+
+// castTheme is the theme of an asciicast, with the 16 colors of its palette
+// separated by colons.
+type castTheme struct {
+	FG      string `json:"fg"`
+	BG      string `json:"bg"`
+	Palette string `json:"palette"`
+}
+
+// castEvent is an event of an asciicast: data written to or read from the
+// terminal at a time in seconds from the start of the recording.
+type castEvent struct {
+	Time float64
+	Type string
+	Data string
+}
+
+// MarshalJSON encodes the event as an array of its time, type and data.
+func (e castEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any{json.Number(strconv.FormatFloat(e.Time, 'f', 6, 64)), e.Type, e.Data}) //nolint:wrapcheck
+}
+
+// UnmarshalJSON decodes the event from an array of its time, type and data.
+func (e *castEvent) UnmarshalJSON(b []byte) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err //nolint:wrapcheck
+	}
+	if len(fields) < 3 { //nolint:mnd
+		return errors.New("event must have a time, a type and data")
+	}
+	if err := json.Unmarshal(fields[0], &e.Time); err != nil {
+		return err //nolint:wrapcheck
+	}
+	if err := json.Unmarshal(fields[1], &e.Type); err != nil {
+		return err //nolint:wrapcheck
+	}
+	return json.Unmarshal(fields[2], &e.Data) //nolint:wrapcheck
+}
+
+// castRecorder records the output of the terminal for the asciicast output,
+// with the number of the frame in which it was first shown.
+type castRecorder struct {
+	width, height int
+	start         time.Time
+	outputs       []frameOutput
+	// pending is the start of a character split across outputs.
+	pending []byte
+}
+
+// frameOutput is the output of the terminal shown in a frame.
+type frameOutput struct {
+	frame int
+	data  string
+}
+
+// newCastRecorder returns a recorder of a terminal of the given size in cells.
+func newCastRecorder(width, height int) *castRecorder {
+	return &castRecorder{width: width, height: height, start: time.Now()}
+}
+
+// add records the output of the terminal shown in the frame with the given
+// number, keeping a character split at its end for the next output.
+func (r *castRecorder) add(frame int, output []byte) {
+	data := append(r.pending, output...) //nolint:gocritic
+	n := len(data)
+	for i := 1; i <= utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				n = len(data) - i
+			}
+			break
+		}
+	}
+	r.pending = append([]byte(nil), data[n:]...)
+	if n > 0 {
+		r.outputs = append(r.outputs, frameOutput{frame: frame, data: string(data[:n])})
+	}
+}
+
+// castRepaintOutput returns the output which clears the terminal and draws
+// the screen, with its cursor.
+func castRepaintOutput(s screen, theme Theme) []byte {
+	var sb strings.Builder
+	sb.WriteString("\x1b[0m\x1b[H\x1b[2J")
+	sb.WriteString(strings.Join(styledLines(s, theme), "\r\n"))
+	_, _ = fmt.Fprintf(&sb, "\x1b[%d;%dH", s.cursor.Y+1, s.cursor.X+1)
+	if s.cursorVisible {
+		sb.WriteString("\x1b[?25h")
+	} else {
+		sb.WriteString("\x1b[?25l")
+	}
+	return []byte(sb.String())
+}
+
+// encodeCast writes the recorded output into an asciicast, timed like the
+// frames of the videos.
+func encodeCast(opts Options, r *castRecorder, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create %s: %w", path, err)
+	}
+	if err := writeCast(f, opts, r); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("could not write %s: %w", path, err)
+	}
+	return f.Close() //nolint:wrapcheck
+}
+
+// writeCast writes the asciicast of the recorded output.
+func writeCast(w io.Writer, opts Options, r *castRecorder) error {
+	header := castHeader{
+		Version:   castVersion,
+		Width:     r.width,
+		Height:    r.height,
+		Timestamp: r.start.Unix(),
+		Env:       map[string]string{"TERM": "xterm-256color"},
+		Theme:     newCastTheme(opts.Theme),
+	}
+	if len(opts.Shell.Command) > 0 {
+		header.Env["SHELL"] = opts.Shell.Command[0]
+	}
+
+	speed := opts.Video.PlaybackSpeed
+	if speed <= 0 {
+		speed = defaultPlaybackSpeed
+	}
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(header); err != nil {
+		return err //nolint:wrapcheck
+	}
+	for _, out := range r.outputs {
+		event := castEvent{
+			Time: float64(out.frame-1) / float64(opts.Video.Framerate) / speed,
+			Type: castOutput,
+			Data: out.data,
+		}
+		if err := enc.Encode(event); err != nil {
+			return err //nolint:wrapcheck
+		}
+	}
+	return nil
+}
+
+// newCastTheme returns the asciicast theme of a theme.
+func newCastTheme(t Theme) *castTheme {
+	palette := make([]string, 16) //nolint:mnd
+	for i := range palette {
+		palette[i] = hexColor(ansiColor(t, i))
+	}
+	return &castTheme{
+		FG:      hexColor(themeColor(t.Foreground)),
+		BG:      hexColor(themeColor(t.Background)),
+		Palette: strings.Join(palette, ":"),
+	}
+}
+
+// readCast reads the header and the events of an asciicast.
+func readCast(r io.Reader) (castHeader, []castEvent, error) {
+	var header castHeader
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<24) //nolint:mnd
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return header, nil, fmt.Errorf("could not read asciicast: %w", err)
+		}
+		return header, nil, errors.New("asciicast is empty")
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return header, nil, fmt.Errorf("invalid asciicast header: %w", err)
+	}
+	if header.Version != castVersion {
+		return header, nil, fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+
+	var events []castEvent
+	for line := 2; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var event castEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return header, nil, fmt.Errorf("invalid asciicast event on line %d: %w", line, err)
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return header, nil, fmt.Errorf("could not read asciicast: %w", err)
+	}
+	return header, events, nil
+}
+
+// castToTape converts an asciicast into a tape, from its input events if it
+// has any, or else from the typing inferred from its output.
+func castToTape(header castHeader, events []castEvent) string {
+	var input strings.Builder
+	if header.Theme != nil {
+		_, _ = fmt.Fprintf(&input, "%s Theme %s\n", token.SET, castTapeTheme(*header.Theme))
+	}
+
+	inputs := castInputs(events)
+	if len(inputs) == 0 {
+		inputs = inferCastInputs(events)
+	}
+
+	// Like vhs record, pauses in the input become sleeps.
+	for i, event := range inputs {
+		if i > 0 {
+			gap := time.Duration((event.Time - inputs[i-1].Time) * float64(time.Second))
+			for range int(gap / sleepThreshold) {
+				_, _ = fmt.Fprintf(&input, "\n%s\n", token.SLEEP)
+			}
+		}
+		input.WriteString(event.Data)
+	}
+
+	// Like the input of vhs record, the input ends with the exit of the shell,
+	// which isn't part of the tape.
+	input.WriteString("\nexit")
+	return inputToTape(input.String())
+}
+
+// castTapeTheme returns the JSON of the theme of a tape for an asciicast
+// theme.
+func castTapeTheme(t castTheme) string {
+	theme := map[string]string{"foreground": t.FG, "background": t.BG}
+	names := []string{
+		"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white",
+		"brightBlack", "brightRed", "brightGreen", "brightYellow",
+		"brightBlue", "brightMagenta", "brightCyan", "brightWhite",
+	}
+	for i, c := range strings.Split(t.Palette, ":") {
+		if i < len(names) {
+			theme[names[i]] = c
+		}
+	}
+	b, _ := json.Marshal(theme)
+	return string(b)
+}
+
+// castInputs returns the input events of an asciicast.
+func castInputs(events []castEvent) []castEvent {
+	var inputs []castEvent
+	for _, event := range events {
+		if event.Type == castInput {
+			inputs = append(inputs, event)
+		}
+	}
+	return inputs
+}
+
+// inferCastInputs returns the input typed in an asciicast without input
+// events, inferred from its output: single characters echoed by the shell are
+// typed, new lines right after them are Enter, and erased characters are
+// Backspace.
+func inferCastInputs(events []castEvent) []castEvent {
+	var inputs []castEvent
+	typing := false
+	for _, event := range events {
+		if event.Type != castOutput {
+			continue
+		}
+
+		var data string
+		switch r, size := utf8.DecodeRuneInString(event.Data); {
+		case size > 0 && size == len(event.Data) && unicode.IsPrint(r):
+			data = event.Data
+		case strings.HasPrefix(event.Data, "\r\n") && typing:
+			data = "\r"
+		case event.Data == "\b\x1b[K" || event.Data == "\b \b":
+			data = "\x7f"
+		}
+
+		typing = data != "" && data != "\r"
+		if data != "" {
+			inputs = append(inputs, castEvent{Time: event.Time, Type: castInput, Data: data})
+		}
+	}
+	return inputs
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestCastRecorder(t *testing.T) {
+	r := newCastRecorder(80, 24)
+	r.add(1, []byte("a\xe2\x94"))
+	r.add(3, []byte("\x80b"))
+	if len(r.outputs) != 2 || r.outputs[0].data != "a" || r.outputs[1].data != "─b" || r.outputs[1].frame != 3 {
+		t.Fatalf("expected the split character in the second output, got %+v", r.outputs)
+	}
+
+	opts := DefaultVHSOptions()
+	opts.Video.Framerate = 10
+	opts.Video.PlaybackSpeed = 2
+
+	var sb strings.Builder
+	if err := writeCast(&sb, opts, r); err != nil {
+		t.Fatal(err)
+	}
+	header, events, err := readCast(strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Width != 80 || header.Height != 24 || header.Theme == nil || header.Theme.BG != DefaultTheme.Background {
+		t.Errorf("unexpected header %+v", header)
+	}
+	if len(events) != 2 || events[1].Time != 0.1 || events[1].Type != castOutput || events[1].Data != "─b" {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+// castBackend is a terminal whose frame, screen and output are set by the
+// test.
+type castBackend struct {
+	Backend
+	frame  []byte
+	screen screen
+	output []byte
+}
+
+func (b *castBackend) CaptureFrame() ([]byte, []byte, error) { return b.frame, b.frame, nil }
+func (b *castBackend) Screen() (screen, error)               { return b.screen, nil }
+
+func (b *castBackend) Output() ([]byte, error) {
+	out := b.output
+	b.output = nil
+	return out, nil
+}
+
+func TestCaptureFrameHiddenOutput(t *testing.T) {
+	frame, err := encodeFrame(image.NewRGBA(image.Rect(0, 0, 8, 8)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &castBackend{frame: frame, screen: textScreen("$ ls", "a b")}
+	v := New()
+	v.backend = b
+	v.viewport = image.Pt(8, 8)
+	v.Options.Video.Output.Cast = "demo.cast"
+
+	capture := func() string {
+		t.Helper()
+		f, err := v.captureFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(f.output)
+	}
+
+	b.output = []byte("setup")
+	if out := capture(); strings.Contains(out, "setup") || !strings.Contains(out, "$ ls\r\na b") {
+		t.Errorf("expected the first frame to draw the screen, got %q", out)
+	}
+	b.output = []byte("shown")
+	if out := capture(); out != "shown" {
+		t.Errorf("expected the output of the shell, got %q", out)
+	}
+
+	// Hide ... Show
+	v.PauseRecording()
+	b.output = []byte("secret")
+	v.discardOutput()
+	if len(b.output) != 0 {
+		t.Errorf("expected the hidden output to be drained, got %q", b.output)
+	}
+	b.output = []byte("hidden")
+	v.ResumeRecording()
+	if out := capture(); strings.Contains(out, "hidden") || !strings.Contains(out, "$ ls") {
+		t.Errorf("expected the screen to be drawn after Show, got %q", out)
+	}
+	b.output = []byte("shown again")
+	if out := capture(); out != "shown again" {
+		t.Errorf("expected the output of the shell, got %q", out)
+	}
+}
+
+func TestCastToTape(t *testing.T) {
+	tests := []struct {
+		name string
+		cast string
+		want string
+	}{
+		{
+			name: "input events",
+			cast: `{"version": 2, "width": 80, "height": 24}
+[0.1, "o", "$ "]
+[0.2, "i", "l"]
+[0.3, "i", "s"]
+[0.4, "i", "\r"]
+[0.5, "o", "file\r\n"]
+[1.6, "i", "\u0003"]
+`,
+			want: "Type \"ls\"\nEnter\nSleep 1s\nCtrl+C\n",
+		},
+		{
+			name: "inferred typing",
+			cast: `{"version": 2, "width": 80, "height": 24}
+[0.1, "o", "$ "]
+[0.2, "o", "l"]
+[0.3, "o", "x"]
+[0.4, "o", "\b\u001b[K"]
+[0.5, "o", "s"]
+[0.6, "o", "\r\n"]
+[0.7, "o", "file\r\n$ "]
+`,
+			want: "Type \"lx\"\nBackspace\nType \"s\"\nEnter\n",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			header, events, err := readCast(strings.NewReader(tc.cast))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := castToTape(header, events); got != tc.want {
+				t.Errorf("expected tape:\n%q\ngot:\n%q", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Clocks of a recording, set with Set Clock.
+const (
+	// ClockReal captures frames on a wall-clock ticker while the commands
+	// are executed, so the timing of the video depends on the host.
+	ClockReal = "real"
+	// ClockVirtual captures frames after each step of the commands, and
+	// synthesizes the timing of the video from the durations they declare,
+	// so the video is the same on any host.
+	ClockVirtual = "virtual"
+)
+
+const (
+	// settleTick is the interval at which the terminal is checked for
+	// changes before a frame is captured with a virtual clock.
+	settleTick = 10 * time.Millisecond
+	// settleQuiet is how long the terminal must not change to be settled.
+	settleQuiet = 50 * time.Millisecond
+	// settleTimeout is the longest time to wait for the terminal to settle,
+	// e.g. for a command which keeps printing.
+	settleTimeout = 2 * time.Second
+)
+
+// virtualClock is the clock of a recording with Set Clock virtual, in which
+// time only passes when commands declare it, e.g. with Sleep.
+type virtualClock struct {
+	// elapsed is the time elapsed in the video.
+	elapsed time.Duration
+	// frames is the number of frames captured.
+	frames int
+}
+
+// advance advances the clock by the given duration, and returns the number of
+// frames which must be captured to cover the elapsed time at the given
+// framerate.
+func (c *virtualClock) advance(d time.Duration, framerate int) int {
+	c.elapsed += d
+	frames := int(c.elapsed * time.Duration(framerate) / time.Second)
+	n := max(frames-c.frames, 0)
+	c.frames += n
+	return n
+}
+
+// Sleep lets the given duration pass in the recording. With a real clock, or
+// while the recording is hidden, it sleeps. With a virtual clock it captures
+// the frames of the current state of the terminal covering the duration.
+func (vhs *VHS) Sleep(d time.Duration) error {
+	if vhs.clock == nil || !vhs.recording {
+		time.Sleep(d)
+		if !vhs.recording {
+			vhs.discardOutput()
+		}
+		return nil
+	}
+	return vhs.advance(d)
+}
+
+// advance advances the virtual clock by the given duration and captures the
+// frames covering it.
+func (vhs *VHS) advance(d time.Duration) error {
+	n := vhs.clock.advance(d, vhs.Options.Video.Framerate)
+	if n == 0 {
+		return nil
+	}
+
+	vhs.settle()
+	return vhs.capture(n)
+}
+
+// elapse lets the time a command was blocked for, e.g. by Wait, pass in the
+// recording with a virtual clock, and captures the frames covering it as the
+// terminal is, since it changed while the command was blocked.
+func (vhs *VHS) elapse(d time.Duration) error {
+	if vhs.clock == nil || !vhs.recording {
+		return nil
+	}
+	return vhs.capture(vhs.clock.advance(d, vhs.Options.Video.Framerate))
+}
+
+// capture captures the current state of the terminal as the last n frames of
+// the virtual clock.
+func (vhs *VHS) capture(n int) error {
+	if n == 0 {
+		return nil
+	}
+
+	first := vhs.clock.frames - n + 1
+	frame, err := vhs.captureFrame()
+	if err != nil {
+		return err
+	}
+	for counter := first; counter < first+n; counter++ {
+		if err := vhs.writeFrame(counter, frame); err != nil {
+			return err
+		}
+		// The output of the shell is shown from the first of the frames.
+		frame.output = nil
+	}
+	return nil
+}
+
+// settle waits for the terminal to stop changing and to be painted, so that
+// the frames captured with a virtual clock don't depend on how fast the host
+// runs the commands.
+func (vhs *VHS) settle() {
+	var last string
+	quiet := time.Duration(0)
+	for deadline := time.Now().Add(settleTimeout); time.Now().Before(deadline) && quiet < settleQuiet; {
+		time.Sleep(settleTick)
+		lines, err := vhs.Buffer()
+		if err != nil {
+			break
+		}
+		if buf := strings.Join(lines, "\n"); buf != last {
+			last, quiet = buf, 0
+			continue
+		}
+		quiet += settleTick
+	}
+
+	// Wait for the terminal to draw the last changes.
+	_ = vhs.backend.Flush()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/vhs/parser"
+	"github.com/charmbracelet/vhs/token"
+)
+
+func TestVirtualClock(t *testing.T) {
+	var c virtualClock
+	steps := []struct {
+		d      time.Duration
+		frames int
+	}{
+		{50 * time.Millisecond, 1},
+		{50 * time.Millisecond, 2},
+		{10 * time.Millisecond, 0},
+		{30 * time.Millisecond, 1},
+		{time.Second, 30},
+	}
+	for i, step := range steps {
+		if got := c.advance(step.d, 30); got != step.frames {
+			t.Errorf("step %d: expected %d frames, got %d", i, step.frames, got)
+		}
+	}
+	if c.frames != 34 || c.elapsed != 1140*time.Millisecond {
+		t.Errorf("expected 34 frames in 1.14s, got %d in %s", c.frames, c.elapsed)
+	}
+}
+
+// waitBackend is a terminal whose current line becomes ready at a given time.
+type waitBackend struct {
+	castBackend
+	ready time.Time
+}
+
+func (b *waitBackend) CurrentLine() (string, error) {
+	if time.Now().Before(b.ready) {
+		return "> loading", nil
+	}
+	return "> ready", nil
+}
+
+func TestWaitVirtualClock(t *testing.T) {
+	frame, err := encodeFrame(image.NewRGBA(image.Rect(0, 0, 8, 8)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const wait = 200 * time.Millisecond
+	v := New()
+	v.backend = &waitBackend{castBackend: castBackend{frame: frame}, ready: time.Now().Add(wait)}
+	v.viewport = image.Pt(8, 8)
+	v.Options.Video.Framerate = 50
+	v.clock = &virtualClock{}
+	v.recording = true
+
+	if err := ExecuteWait(parser.Command{Type: token.WAIT, Args: "Line ready"}, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.clock.elapsed < wait || v.clock.frames < 10 {
+		t.Errorf("expected the wait of %s in the video, got %d frames in %s", wait, v.clock.frames, v.clock.elapsed)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -19,6 +20,11 @@ import (
 // Execute executes a command on a running instance of vhs.
 func Execute(c parser.Command, v *VHS) error {
 	err := CommandFuncs[c.Type](c, v)
+	if c.Type == token.IF {
+		// The commands of the branch are executed with Execute, which wraps
+		// their errors and saves their output.
+		return err
+	}
 	if err != nil {
 		return fmt.Errorf("failed to execute command: %w", err)
 	}
@@ -70,6 +76,14 @@ var CommandFuncs = map[parser.CommandType]CommandFunc{
 	token.PASTE:       ExecutePaste,
 	token.ENV:         ExecuteEnv,
 	token.WAIT:        ExecuteWait,
+	token.EXPECT:      ExecuteExpect,
+	token.EXPECT_NOT:  ExecuteExpect,
+}
+
+func init() {
+	// If executes other commands, which would otherwise make the
+	// initialization of CommandFuncs refer to itself.
+	CommandFuncs[token.IF] = ExecuteIf
 }
 
 // ExecuteNoop is a no-op command that does nothing.
@@ -94,11 +108,12 @@ func ExecuteKey(k input.Key) CommandFunc {
 			repeat = 1
 		}
 		for i := 0; i < repeat; i++ {
-			err = v.Page.Keyboard.Type(k)
-			if err != nil {
-				return fmt.Errorf("failed to type key %c: %w", k, err)
+			if err := v.backend.Key(k); err != nil {
+				return err //nolint:wrapcheck
+			}
+			if err := v.Sleep(typingSpeed); err != nil {
+				return err
 			}
-			time.Sleep(typingSpeed)
 		}
 
 		return nil
@@ -130,13 +145,14 @@ func ExecuteScroll(direction int) CommandFunc {
 		}
 
 		for i := 0; i < repeat; i++ {
-			// ScrollUp/ScrollDown are viewport operations implemented directly via
-			// xterm's scroll API.
-			_, err = v.Page.Eval(fmt.Sprintf("() => term.scrollLines(%d)", direction))
-			if err != nil {
-				return fmt.Errorf("failed to scroll viewport: %w", err)
+			// ScrollUp/ScrollDown are viewport operations implemented directly by
+			// the terminal, e.g. with xterm's scroll API.
+			if err := v.backend.Scroll(direction); err != nil {
+				return err //nolint:wrapcheck
+			}
+			if err := v.Sleep(typingSpeed); err != nil {
+				return err
 			}
-			time.Sleep(typingSpeed)
 		}
 
 		return nil
@@ -165,54 +181,104 @@ func ExecuteWait(c parser.Command, v *VHS) error {
 		timeout = t
 	}
 
+	start := time.Now()
+	checked := start
 	checkT := time.NewTicker(WaitTick)
 	defer checkT.Stop()
 	timeoutT := time.NewTimer(timeout)
 	defer timeoutT.Stop()
 
 	for {
-		var last string
-		switch scope {
-		case "Line":
-			line, err := v.CurrentLine()
-			if err != nil {
-				return fmt.Errorf("failed to get current line: %w", err)
-			}
-			last = line
-
-			if rx.MatchString(line) {
-				return nil
-			}
-		case "Screen":
-			lines, err := v.Buffer()
-			if err != nil {
-				return fmt.Errorf("failed to get buffer: %w", err)
-			}
-			last = strings.Join(lines, "\n")
-
-			if rx.MatchString(last) {
-				return nil
-			}
-		default:
-			// Should be impossible due to parse validation, but we don't want to
-			// hang if it does happen due to a bug.
-			return fmt.Errorf("invalid scope %q", scope)
+		match, last, err := v.MatchScope(scope, rx)
+		if err != nil {
+			return err
+		}
+		// With a virtual clock, the time spent waiting passes in the video.
+		now := time.Now()
+		if err := v.elapse(now.Sub(checked)); err != nil {
+			return err
+		}
+		checked = now
+		if match {
+			v.recordStep(c, start, "", "")
+			return nil
 		}
 
 		select {
 		case <-checkT.C:
 			continue
 		case <-timeoutT.C:
-			return fmt.Errorf("timeout waiting for %q to match %s; last value was: %s", c.Args, rx.String(), last)
+			err := fmt.Errorf("timeout waiting for %q to match %s; last value was: %s", c.Args, rx.String(), last)
+			v.recordStep(c, start, fmt.Sprintf("timeout waiting for %q to match %s", c.Args, rx), last)
+			return err
 		}
 	}
 }
 
+// ExecuteExpect is a CommandFunc that checks whether a regular expression
+// matches (Expect) or doesn't match (ExpectNot) the current line or the screen
+// right away. A failed expectation is recorded rather than returned, so that
+// the tape goes on.
+func ExecuteExpect(c parser.Command, v *VHS) error {
+	scope, rxStr, _ := strings.Cut(c.Args, " ")
+
+	// This is validated on parse so using MustCompile reduces noise.
+	rx := regexp.MustCompile(rxStr)
+
+	start := time.Now()
+	match, last, err := v.MatchScope(scope, rx)
+	if err != nil {
+		return err
+	}
+	expected := c.Type == token.EXPECT
+	if match == expected {
+		v.recordStep(c, start, "", "")
+		return nil
+	}
+
+	var msg string
+	switch {
+	case !expected:
+		msg = fmt.Sprintf("%s matches /%s/ with %q", scope, rx, rx.FindString(last))
+	case scope == "Line":
+		msg = fmt.Sprintf("Line doesn't match /%s/, it was %q", rx, last)
+	default:
+		msg = fmt.Sprintf("%s doesn't match /%s/", scope, rx)
+	}
+	v.recordStep(c, start, msg, last)
+	v.failures = append(v.failures, parser.Error{
+		Token: c.Start,
+		Msg:   msg,
+		File:  c.Source,
+		Start: c.Start,
+		End:   c.End,
+	})
+	return nil
+}
+
+// ExecuteIf is a CommandFunc that executes the commands of the branch of an
+// If block which matches the current state of the terminal.
+func ExecuteIf(c parser.Command, v *VHS) error {
+	branch, err := v.Branch(c)
+	if err != nil {
+		return err
+	}
+	for _, cmd := range branch {
+		if ignoredSetting(cmd) || cmd.Type == token.REQUIRE {
+			continue
+		}
+		if err := Execute(cmd, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ExecuteCtrl is a CommandFunc that presses the argument keys and/or modifiers
 // with the ctrl key held down on the running instance of vhs.
 func ExecuteCtrl(c parser.Command, v *VHS) error {
 	// Create key combination by holding ControlLeft
-	action := v.Page.KeyActions().Press(input.ControlLeft)
+	modifiers := []input.Key{input.ControlLeft}
 	keys := strings.Split(c.Args, " ")
 
 	for i, key := range keys {
@@ -245,19 +311,17 @@ func ExecuteCtrl(c parser.Command, v *VHS) error {
 		}
 
 		// Press or hold key in case it's valid
-		if inputKey != nil {
-			if i != len(keys)-1 {
-				action.Press(*inputKey)
-			} else {
-				// Other keys will remain pressed until the combination reaches the end
-				action.Type(*inputKey)
-			}
+		if inputKey == nil {
+			continue
+		}
+		if i != len(keys)-1 {
+			modifiers = append(modifiers, *inputKey)
+			continue
+		}
+		// Other keys will remain pressed until the combination reaches the end
+		if err := v.backend.Key(*inputKey, modifiers...); err != nil {
+			return fmt.Errorf("failed to type key %s: %w", c.Args, err)
 		}
-	}
-
-	err := action.Do()
-	if err != nil {
-		return fmt.Errorf("failed to type key %s: %w", c.Args, err)
 	}
 
 	return nil
@@ -266,79 +330,37 @@ func ExecuteCtrl(c parser.Command, v *VHS) error {
 // ExecuteAlt is a CommandFunc that presses the argument key with the alt key
 // held down on the running instance of vhs.
 func ExecuteAlt(c parser.Command, v *VHS) error {
-	err := v.Page.Keyboard.Press(input.AltLeft)
-	if err != nil {
-		return fmt.Errorf("failed to press Alt key: %w", err)
-	}
-	if k, ok := token.Keywords[c.Args]; ok { //nolint:nestif
-		switch k {
-		case token.ENTER:
-			err = v.Page.Keyboard.Type(input.Enter)
-			if err != nil {
-				return fmt.Errorf("failed to type Enter key: %w", err)
-			}
-		case token.TAB:
-			err := v.Page.Keyboard.Type(input.Tab)
-			if err != nil {
-				return fmt.Errorf("failed to type Tab key: %w", err)
-			}
-		}
-	} else {
-		for _, r := range c.Args {
-			if k, ok := keymap[r]; ok {
-				err = v.Page.Keyboard.Type(k)
-				if err != nil {
-					return fmt.Errorf("failed to type key %c: %w", r, err)
-				}
-			}
-		}
-	}
-
-	err = v.Page.Keyboard.Release(input.AltLeft)
-	if err != nil {
-		return fmt.Errorf("failed to release Alt key: %w", err)
-	}
-
-	return nil
+	return executeModified(c, v, input.AltLeft)
 }
 
 // ExecuteShift is a CommandFunc that presses the argument key with the shift
 // key held down on the running instance of vhs.
 func ExecuteShift(c parser.Command, v *VHS) error {
-	err := v.Page.Keyboard.Press(input.ShiftLeft)
-	if err != nil {
-		return fmt.Errorf("failed to press Shift key: %w", err)
-	}
-
-	if k, ok := token.Keywords[c.Args]; ok { //nolint:nestif
-		switch k {
-		case token.ENTER:
-			err = v.Page.Keyboard.Type(input.Enter)
-			if err != nil {
-				return fmt.Errorf("failed to type Enter key: %w", err)
-			}
-		case token.TAB:
-			err = v.Page.Keyboard.Type(input.Tab)
-			if err != nil {
-				return fmt.Errorf("failed to type Tab key: %w", err)
-			}
-		}
-	} else {
+	return executeModified(c, v, input.ShiftLeft)
+}
+
+// executeModified presses the argument keys of the command with the modifier
+// held down: Enter, Tab or the keys of each of its characters.
+func executeModified(c parser.Command, v *VHS, modifier input.Key) error {
+	var keys []input.Key
+	switch token.Keywords[c.Args] {
+	case token.ENTER:
+		keys = append(keys, input.Enter)
+	case token.TAB:
+		keys = append(keys, input.Tab)
+	default:
 		for _, r := range c.Args {
 			if k, ok := keymap[r]; ok {
-				err = v.Page.Keyboard.Type(k)
-				if err != nil {
-					return fmt.Errorf("failed to type key %c: %w", r, err)
-				}
+				keys = append(keys, k)
 			}
 		}
 	}
 
-	err = v.Page.Keyboard.Release(input.ShiftLeft)
-	if err != nil {
-		return fmt.Errorf("failed to release Shift key: %w", err)
+	for _, k := range keys {
+		if err := v.backend.Key(k, modifier); err != nil {
+			return err //nolint:wrapcheck
+		}
 	}
-
 	return nil
 }
 
@@ -363,13 +385,12 @@ func ExecuteShow(_ parser.Command, v *VHS) error {
 
 // ExecuteSleep sleeps for the desired time specified through the argument of
 // the Sleep command.
-func ExecuteSleep(c parser.Command, _ *VHS) error {
+func ExecuteSleep(c parser.Command, v *VHS) error {
 	dur, err := time.ParseDuration(c.Args)
 	if err != nil {
 		return fmt.Errorf("failed to parse duration: %w", err)
 	}
-	time.Sleep(dur)
-	return nil
+	return v.Sleep(dur)
 }
 
 // ExecuteType types the argument string on the running instance of vhs.
@@ -382,22 +403,14 @@ func ExecuteType(c parser.Command, v *VHS) error {
 			return fmt.Errorf("failed to parse typing speed: %w", err)
 		}
 	}
+	v.markChapter(c.Args)
 	for _, r := range c.Args {
-		k, ok := keymap[r]
-		if ok {
-			err := v.Page.Keyboard.Type(k)
-			if err != nil {
-				return fmt.Errorf("failed to type key %c: %w", r, err)
-			}
-		} else {
-			err := v.Page.MustElement("textarea").Input(string(r))
-			if err != nil {
-				return fmt.Errorf("failed to input text: %w", err)
-			}
-
-			v.Page.MustWaitIdle()
+		if err := v.backend.Type(string(r)); err != nil {
+			return err //nolint:wrapcheck
+		}
+		if err := v.Sleep(typingSpeed); err != nil {
+			return err
 		}
-		time.Sleep(typingSpeed)
 	}
 
 	return nil
@@ -408,12 +421,22 @@ func ExecuteOutput(c parser.Command, v *VHS) error {
 	switch c.Options {
 	case ".mp4":
 		v.Options.Video.Output.MP4 = c.Args
-	case ".test", ".ascii", ".txt":
+	case ".test", ".ascii", ".txt", ansiExtension:
 		v.Options.Test.Output = c.Args
 	case ".png":
 		v.Options.Video.Output.Frames = c.Args
 	case ".webm":
 		v.Options.Video.Output.WebM = c.Args
+	case ".webp":
+		v.Options.Video.Output.WebP = c.Args
+	case ".apng":
+		v.Options.Video.Output.APNG = c.Args
+	case ".svg":
+		v.Options.Video.Output.SVG = c.Args
+	case ".cast":
+		v.Options.Video.Output.Cast = c.Args
+	case ".html":
+		v.Options.Video.Output.HTML = c.Args
 	default:
 		v.Options.Video.Output.GIF = c.Args
 	}
@@ -437,23 +460,7 @@ func ExecutePaste(_ parser.Command, v *VHS) error {
 	if err != nil {
 		return fmt.Errorf("failed to read clipboard: %w", err)
 	}
-	for _, r := range clip {
-		k, ok := keymap[r]
-		if ok {
-			err = v.Page.Keyboard.Type(k)
-			if err != nil {
-				return fmt.Errorf("failed to type key %c: %w", r, err)
-			}
-		} else {
-			err = v.Page.MustElement("textarea").Input(string(r))
-			if err != nil {
-				return fmt.Errorf("failed to input text: %w", err)
-			}
-			v.Page.MustWaitIdle()
-		}
-	}
-
-	return nil
+	return v.backend.Type(clip) //nolint:wrapcheck
 }
 
 // Settings maps the Set commands to their respective functions.
@@ -479,6 +486,13 @@ var Settings = map[string]CommandFunc{
 	"WaitPattern":   ExecuteSetWaitPattern,
 	"WaitTimeout":   ExecuteSetWaitTimeout,
 	"CursorBlink":   ExecuteSetCursorBlink,
+	"Clock":         ExecuteSetClock,
+	"Backend":       ExecuteSetBackend,
+	"GIFEncoder":    ExecuteSetGIFEncoder,
+	"GIFDither":     ExecuteSetGIFDither,
+	"MaxColors":     ExecuteSetMaxColors,
+	"WebPQuality":   ExecuteSetWebPQuality,
+	"WebPLossless":  ExecuteSetWebPLossless,
 }
 
 // ExecuteSet applies the settings on the running vhs specified by the
@@ -494,32 +508,19 @@ func ExecuteSetFontSize(c parser.Command, v *VHS) error {
 		return fmt.Errorf("failed to parse font size: %w", err)
 	}
 	v.Options.FontSize = fontSize
-	_, err = v.Page.Eval(fmt.Sprintf("() => term.options.fontSize = %d", fontSize))
-	if err != nil {
-		return fmt.Errorf("failed to set font size: %w", err)
-	}
 
 	// When changing the font size only the canvas dimensions change which are
-	// scaled back during the render to fit the aspect ration and dimensions.
+	// scaled back when the frames are captured to fit the aspect ratio and
+	// dimensions.
 	//
-	// We need to call term.fit to ensure that everything is resized properly.
-	_, err = v.Page.Eval("term.fit")
-	if err != nil {
-		return fmt.Errorf("failed to fit terminal: %w", err)
-	}
-
-	return nil
+	// The terminal is fit again to ensure that everything is resized properly.
+	return v.Configure()
 }
 
 // ExecuteSetFontFamily applies the font family on the vhs.
 func ExecuteSetFontFamily(c parser.Command, v *VHS) error {
-	v.Options.FontFamily = c.Args
-	_, err := v.Page.Eval(fmt.Sprintf("() => term.options.fontFamily = '%s'", withSymbolsFallback(c.Args)))
-	if err != nil {
-		return fmt.Errorf("failed to set font family: %w", err)
-	}
-
-	return nil
+	v.Options.FontFamily = withSymbolsFallback(c.Args)
+	return v.Configure()
 }
 
 // ExecuteSetHeight applies the height on the vhs.
@@ -569,12 +570,7 @@ func ExecuteSetLetterSpacing(c parser.Command, v *VHS) error {
 	}
 
 	v.Options.LetterSpacing = letterSpacing
-	_, err = v.Page.Eval(fmt.Sprintf("() => term.options.letterSpacing = %f", letterSpacing))
-	if err != nil {
-		return fmt.Errorf("failed to set letter spacing: %w", err)
-	}
-
-	return nil
+	return v.Configure()
 }
 
 // ExecuteSetLineHeight applies the line height on the vhs.
@@ -585,32 +581,28 @@ func ExecuteSetLineHeight(c parser.Command, v *VHS) error {
 	}
 
 	v.Options.LineHeight = lineHeight
-	_, err = v.Page.Eval(fmt.Sprintf("() => term.options.lineHeight = %f", lineHeight))
-	if err != nil {
-		return fmt.Errorf("failed to set line height: %w", err)
-	}
-
-	return nil
+	return v.Configure()
 }
 
 // ExecuteSetTheme applies the theme on the vhs.
 func ExecuteSetTheme(c parser.Command, v *VHS) error {
-	var err error
-	v.Options.Theme, err = getTheme(c.Args)
+	theme, err := getTheme(c.Args)
 	if err != nil {
 		return err
 	}
-
-	bts, err := json.Marshal(v.Options.Theme)
-	if err != nil {
-		return fmt.Errorf("failed to marshal theme: %w", err)
+	v.mutex.Lock()
+	v.Options.Theme = theme
+	v.mutex.Unlock()
+	if err := v.Configure(); err != nil {
+		return err
 	}
 
-	_, err = v.Page.Eval(fmt.Sprintf("() => term.options.theme = %s", string(bts)))
-	if err != nil {
-		return fmt.Errorf("failed to set theme: %w", err)
+	// While recording, the padding, margins and window bar keep the colors
+	// of the theme the recording started with: they are drawn at render time
+	// for the whole video.
+	if v.configured {
+		return nil
 	}
-
 	v.Options.Video.Style.BackgroundColor = v.Options.Theme.Background
 	v.Options.Video.Style.WindowBarColor = v.Options.Theme.Background
 
@@ -655,6 +647,11 @@ func ExecuteSetPadding(c parser.Command, v *VHS) error {
 		return fmt.Errorf("failed to parse padding: %w", err)
 	}
 
+	// While recording, the padding is applied by resizing the terminal
+	// rather than changing the padding of the whole video.
+	if v.configured {
+		return v.Resize(padding)
+	}
 	v.Options.Video.Style.Padding = padding
 	return nil
 }
@@ -748,6 +745,86 @@ func ExecuteSetCursorBlink(c parser.Command, v *VHS) error {
 	return nil
 }
 
+// ExecuteSetClock sets the clock of the recording.
+func ExecuteSetClock(c parser.Command, v *VHS) error {
+	if c.Args != ClockReal && c.Args != ClockVirtual {
+		return fmt.Errorf("invalid clock %s", c.Args)
+	}
+
+	v.Options.Clock = c.Args
+	return nil
+}
+
+// ExecuteSetBackend sets the backend of the terminal. It must be set before
+// the terminal starts.
+func ExecuteSetBackend(c parser.Command, v *VHS) error {
+	if c.Args != BackendBrowser && c.Args != BackendNative {
+		return fmt.Errorf("invalid backend %s", c.Args)
+	}
+
+	v.Options.Backend = c.Args
+	return nil
+}
+
+// ExecuteSetGIFEncoder sets the encoder of the GIF output.
+func ExecuteSetGIFEncoder(c parser.Command, v *VHS) error {
+	if c.Args != GIFEncoderFFmpeg && c.Args != GIFEncoderNative {
+		return fmt.Errorf("invalid GIF encoder %s", c.Args)
+	}
+
+	v.Options.Video.GIFEncoder = c.Args
+	return nil
+}
+
+// ExecuteSetGIFDither sets the dithering of the GIF output.
+func ExecuteSetGIFDither(c parser.Command, v *VHS) error {
+	if c.Args != GIFDitherNone && c.Args != GIFDitherFloydSteinberg {
+		return fmt.Errorf("invalid GIF dithering %s", c.Args)
+	}
+
+	v.Options.Video.GIFDither = c.Args
+	return nil
+}
+
+// ExecuteSetMaxColors sets the maximum number of colors of the GIF output.
+func ExecuteSetMaxColors(c parser.Command, v *VHS) error {
+	maxColors, err := strconv.Atoi(c.Args)
+	if err != nil {
+		return fmt.Errorf("failed to parse max colors: %w", err)
+	}
+	if maxColors < 2 || maxColors > maxGIFColors {
+		return fmt.Errorf("max colors must be between 2 and %d", maxGIFColors)
+	}
+
+	v.Options.Video.MaxColors = maxColors
+	return nil
+}
+
+// ExecuteSetWebPQuality sets the quality of the lossy WebP output.
+func ExecuteSetWebPQuality(c parser.Command, v *VHS) error {
+	quality, err := strconv.Atoi(c.Args)
+	if err != nil {
+		return fmt.Errorf("failed to parse webp quality: %w", err)
+	}
+	if quality < 0 || quality > 100 {
+		return errors.New("webp quality must be between 0 and 100")
+	}
+
+	v.Options.Video.WebPQuality = quality
+	return nil
+}
+
+// ExecuteSetWebPLossless sets whether the WebP output is lossless.
+func ExecuteSetWebPLossless(c parser.Command, v *VHS) error {
+	var err error
+	v.Options.Video.WebPLossless, err = strconv.ParseBool(c.Args)
+	if err != nil {
+		return fmt.Errorf("failed to parse webp lossless: %w", err)
+	}
+
+	return nil
+}
+
 // ExecuteScreenshot is a CommandFunc that indicates a new screenshot must be taken.
 func ExecuteScreenshot(c parser.Command, v *VHS) error {
 	v.ScreenshotNextFrame(c.Args)
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around the changes of a
+// diff.
+const diffContext = 3
+
+// maxDiffCells bounds the table of the diff of the lines which differ, past
+// which they are shown as all removed and then all added.
+const maxDiffCells = 1 << 24
+
+// diffEdit is a line of a diff, unchanged (' '), removed ('-') or added ('+').
+type diffEdit struct {
+	op   byte
+	line string
+}
+
+// unifiedDiff returns the unified diff turning the text a into the text b,
+// labeled with the given names, or an empty string if they are equal.
+func unifiedDiff(nameA, nameB, a, b string) string {
+	if a == b {
+		return ""
+	}
+	edits := diffLines(strings.Split(a, "\n"), strings.Split(b, "\n"))
+
+	// lineA and lineB are the lines of a and b before each edit.
+	lineA := make([]int, len(edits)+1)
+	lineB := make([]int, len(edits)+1)
+	for i, e := range edits {
+		lineA[i+1], lineB[i+1] = lineA[i], lineB[i]
+		if e.op != '+' {
+			lineA[i+1]++
+		}
+		if e.op != '-' {
+			lineB[i+1]++
+		}
+	}
+
+	var sb strings.Builder
+	_, _ = fmt.Fprintf(&sb, "--- %s\n+++ %s\n", nameA, nameB)
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			i++
+			continue
+		}
+
+		// A hunk goes on while the changes are at most twice the context apart.
+		end := i + 1
+		for j := end; j < len(edits) && j-end <= 2*diffContext; j++ {
+			if edits[j].op != ' ' {
+				end = j + 1
+			}
+		}
+		start, stop := max(i-diffContext, 0), min(end+diffContext, len(edits))
+
+		_, _ = fmt.Fprintf(&sb, "@@ -%s +%s @@\n",
+			hunkRange(lineA[start], lineA[stop]-lineA[start]),
+			hunkRange(lineB[start], lineB[stop]-lineB[start]))
+		for _, e := range edits[start:stop] {
+			sb.WriteByte(e.op)
+			sb.WriteString(e.line)
+			sb.WriteByte('\n')
+		}
+		i = stop
+	}
+	return sb.String()
+}
+
+// hunkRange returns the range of the lines of a hunk starting after the given
+// line, in the format of unified diffs.
+func hunkRange(line, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", line)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", line+1)
+	}
+	return fmt.Sprintf("%d,%d", line+1, count)
+}
+
+// diffLines returns the edits turning the lines a into the lines b, keeping
+// their longest common subsequence unchanged.
+func diffLines(a, b []string) []diffEdit {
+	// The lines around the changes are usually the same, and need no table.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := make([]diffEdit, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		edits = append(edits, diffEdit{' ', line})
+	}
+
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(ma)*len(mb) > maxDiffCells {
+		for _, line := range ma {
+			edits = append(edits, diffEdit{'-', line})
+		}
+		for _, line := range mb {
+			edits = append(edits, diffEdit{'+', line})
+		}
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of
+		// ma[i:] and mb[j:].
+		lcs := make([][]int32, len(ma)+1)
+		for i := range lcs {
+			lcs[i] = make([]int32, len(mb)+1)
+		}
+		for i := len(ma) - 1; i >= 0; i-- {
+			for j := len(mb) - 1; j >= 0; j-- {
+				if ma[i] == mb[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(ma) || j < len(mb) {
+			switch {
+			case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+				edits = append(edits, diffEdit{' ', ma[i]})
+				i++
+				j++
+			case j == len(mb) || i < len(ma) && lcs[i+1][j] >= lcs[i][j+1]:
+				edits = append(edits, diffEdit{'-', ma[i]})
+				i++
+			default:
+				edits = append(edits, diffEdit{'+', mb[j]})
+				j++
+			}
+		}
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		edits = append(edits, diffEdit{' ', line})
+	}
+	return edits
+}
+
+// colorDiff colors the removed, added and hunk lines of a unified diff.
+func colorDiff(diff string) string {
+	lines := strings.Split(strings.TrimSuffix(diff, "\n"), "\n")
+	for i, line := range lines {
+		switch {
+		case i < 2: //nolint:mnd
+			// The names of the files.
+			lines[i] = KeywordStyle.Bold(true).Render(line)
+		case strings.HasPrefix(line, "@@"):
+			lines[i] = CommandStyle.Render(line)
+		case strings.HasPrefix(line, "-"):
+			lines[i] = ErrorStyle.Render(line)
+		case strings.HasPrefix(line, "+"):
+			lines[i] = StringStyle.Render(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
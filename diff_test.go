@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	lines := func(n int, change map[int]string) string {
+		var sb strings.Builder
+		for i := 1; i <= n; i++ {
+			if s, ok := change[i]; ok {
+				sb.WriteString(s + "\n")
+				continue
+			}
+			sb.WriteString("line\n")
+		}
+		return sb.String()
+	}
+
+	if diff := unifiedDiff("a", "b", "x\n", "x\n"); diff != "" {
+		t.Errorf("expected no diff for equal texts, got:\n%s", diff)
+	}
+
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{
+			name: "changed line",
+			a:    lines(10, map[int]string{1: "one", 5: "old"}),
+			b:    lines(10, map[int]string{1: "one", 5: "new"}),
+			want: "--- a\n+++ b\n@@ -2,7 +2,7 @@\n line\n line\n line\n-old\n+new\n line\n line\n line\n",
+		},
+		{
+			name: "distant changes",
+			a:    lines(20, map[int]string{2: "a", 18: "b"}),
+			b:    lines(20, map[int]string{2: "A", 18: "c"}),
+			want: "--- a\n+++ b\n@@ -1,5 +1,5 @@\n line\n-a\n+A\n line\n line\n line\n" +
+				"@@ -15,7 +15,7 @@\n line\n line\n line\n-b\n+c\n line\n line\n \n",
+		},
+		{
+			name: "added lines",
+			a:    "x\n",
+			b:    "x\ny\nz\n",
+			want: "--- a\n+++ b\n@@ -1,2 +1,4 @@\n x\n+y\n+z\n \n",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := unifiedDiff("a", "b", tc.a, tc.b); got != tc.want {
+				t.Errorf("expected diff:\n%s\ngot:\n%s", tc.want, got)
+			}
+		})
+	}
+}
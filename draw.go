@@ -5,9 +5,12 @@ import (
 	"image"
 	"image/color"
 	"image/draw"
+	_ "image/jpeg" // margin fill images
 	"image/png"
 	"math"
 	"os"
+
+	xdraw "golang.org/x/image/draw"
 )
 
 type circle struct {
@@ -176,6 +179,22 @@ func (r *roundedrect) At(x, y int) color.Color {
 
 // MakeBorderRadiusMask a mask to round a terminal's corners.
 func MakeBorderRadiusMask(width, height, radius int, targetpng string) {
+	img := borderRadiusMask(width, height, radius)
+
+	f, err := os.Create(targetpng)
+	if err != nil {
+		fmt.Println(ErrorStyle.Render("Could not draw Border Mask: unable to save file."))
+	} else {
+		err = png.Encode(f, img)
+	}
+
+	if err != nil {
+		fmt.Println(ErrorStyle.Render("Could not draw Border Mask: encoding failed."))
+	}
+}
+
+// borderRadiusMask draws a mask to round a terminal's corners.
+func borderRadiusMask(width, height, radius int) *image.Gray {
 	img := image.NewGray(
 		image.Rectangle{
 			image.Point{0, 0},
@@ -196,36 +215,42 @@ func MakeBorderRadiusMask(width, height, radius int, targetpng string) {
 		&roundedrect{image.Point{0, 0}, image.Point{width, height}, radius},
 		image.Point{0, 0}, draw.Over,
 	)
+	return img
+}
 
-	f, err := os.Create(targetpng)
+// MakeWindowBar a window bar and save it to a file.
+func MakeWindowBar(termWidth, termHeight int, opts StyleOptions, file string) {
+	img := windowBar(termWidth, termHeight, opts)
+	if img == nil {
+		return
+	}
+
+	f, err := os.Create(file)
 	if err != nil {
-		fmt.Println(ErrorStyle.Render("Could not draw Border Mask: unable to save file."))
+		fmt.Println(ErrorStyle.Render("Couldn't draw Bar: unable to save file."))
 	} else {
 		err = png.Encode(f, img)
 	}
 
 	if err != nil {
-		fmt.Println(ErrorStyle.Render("Could not draw Border Mask: encoding failed."))
+		fmt.Println(ErrorStyle.Render("Couldn't draw Bar: encoding failed"))
 	}
 }
 
-// MakeWindowBar a window bar and save it to a file.
-func MakeWindowBar(termWidth, termHeight int, opts StyleOptions, file string) {
-	var err error
+// windowBar draws the window bar of the style, on an image of the terminal
+// and the bar, or returns nil if the style has no valid window bar.
+func windowBar(termWidth, termHeight int, opts StyleOptions) *image.RGBA {
 	switch opts.WindowBar {
 	case "Colorful":
-		err = makeColorfulBar(termWidth, termHeight, false, opts, file)
+		return makeColorfulBar(termWidth, termHeight, false, opts)
 	case "ColorfulRight":
-		err = makeColorfulBar(termWidth, termHeight, true, opts, file)
+		return makeColorfulBar(termWidth, termHeight, true, opts)
 	case "Rings":
-		err = makeRingBar(termWidth, termHeight, false, opts, file)
+		return makeRingBar(termWidth, termHeight, false, opts)
 	case "RingsRight":
-		err = makeRingBar(termWidth, termHeight, true, opts, file)
-	}
-
-	if err != nil {
-		fmt.Println(ErrorStyle.Render("Couldn't draw Bar: encoding failed"))
+		return makeRingBar(termWidth, termHeight, true, opts)
 	}
+	return nil
 }
 
 const (
@@ -233,7 +258,7 @@ const (
 	barToDotBorderRatio = 5
 )
 
-func makeColorfulBar(termWidth int, termHeight int, isRight bool, opts StyleOptions, targetpng string) error {
+func makeColorfulBar(termWidth int, termHeight int, isRight bool, opts StyleOptions) *image.RGBA {
 	// Radius of dots
 	dotRad := opts.WindowBarSize / barToDotRatio
 	dotDia := double(dotRad)
@@ -305,16 +330,10 @@ func makeColorfulBar(termWidth int, termHeight int, isRight bool, opts StyleOpti
 		draw.Over,
 	)
 
-	f, err := os.Create(targetpng)
-	if err != nil {
-		fmt.Println(ErrorStyle.Render("Couldn't draw colorful bar: unable to save file."))
-	} else {
-		err = png.Encode(f, img)
-	}
-	return err //nolint:wrapcheck
+	return img
 }
 
-func makeRingBar(termWidth int, termHeight int, isRight bool, opts StyleOptions, targetpng string) error {
+func makeRingBar(termWidth int, termHeight int, isRight bool, opts StyleOptions) *image.RGBA {
 	// Radius of dots
 	outerRad := opts.WindowBarSize / barToDotBorderRatio
 	outerDia := double(outerRad)
@@ -379,13 +398,7 @@ func makeRingBar(termWidth int, termHeight int, isRight bool, opts StyleOptions,
 		)
 	}
 
-	f, err := os.Create(targetpng)
-	if err != nil {
-		fmt.Println(ErrorStyle.Render("Couldn't draw ring bar: unable to save file."))
-	} else {
-		err = png.Encode(f, img)
-	}
-	return err //nolint:wrapcheck
+	return img
 }
 
 //nolint:mnd
@@ -413,3 +426,108 @@ func parseHexColor(s string) (c color.RGBA, err error) {
 	}
 	return
 }
+
+// hexColor returns the hex string of the color, ignoring its alpha.
+func hexColor(c color.Color) string {
+	rgba := color.RGBAModel.Convert(c).(color.RGBA) //nolint:forcetypeassert
+	return fmt.Sprintf("#%02x%02x%02x", rgba.R, rgba.G, rgba.B)
+}
+
+// frameStyler draws the style of the video around the frames of the terminal,
+// the way the filters of FilterComplexBuilder do with ffmpeg.
+type frameStyler struct {
+	style                 StyleOptions
+	termWidth, termHeight int
+	background            color.Color
+	// bar is the window bar, mask the mask of the rounded corners, and margin
+	// the margin fill scaled to the dimensions of the video, if any.
+	bar    *image.RGBA
+	mask   *image.Alpha
+	margin image.Image
+}
+
+// newFrameStyler returns a styler of frames for the style.
+func newFrameStyler(style StyleOptions) (*frameStyler, error) {
+	s := &frameStyler{style: style}
+	s.termWidth, s.termHeight = calcTermDimensions(style)
+	s.background, _ = parseHexColor(style.BackgroundColor)
+
+	window := image.Pt(s.termWidth, s.termHeight)
+	if style.WindowBar != "" {
+		s.bar = windowBar(s.termWidth, s.termHeight, style)
+		window.Y += style.WindowBarSize
+	}
+	if style.BorderRadius != 0 {
+		mask := borderRadiusMask(window.X, window.Y, style.BorderRadius)
+		s.mask = &image.Alpha{Pix: mask.Pix, Stride: mask.Stride, Rect: mask.Rect}
+	}
+
+	switch {
+	case style.MarginFill == "":
+	case marginFillIsColor(style.MarginFill):
+		c, _ := parseHexColor(style.MarginFill)
+		s.margin = image.NewUniform(c)
+	default:
+		f, err := os.Open(style.MarginFill)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read margin file: %w", err)
+		}
+		defer f.Close() //nolint:errcheck
+		img, _, err := image.Decode(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode margin file: %w", err)
+		}
+		margin := image.NewRGBA(image.Rect(0, 0, style.Width, style.Height))
+		xdraw.ApproxBiLinear.Scale(margin, margin.Bounds(), img, img.Bounds(), draw.Src, nil)
+		s.margin = margin
+	}
+	return s, nil
+}
+
+// Bounds returns the bounds of the styled frames.
+func (s *frameStyler) Bounds() image.Rectangle {
+	if s.margin != nil {
+		return image.Rect(0, 0, s.style.Width, s.style.Height)
+	}
+	if s.bar != nil {
+		return s.bar.Bounds()
+	}
+	return image.Rect(0, 0, s.termWidth, s.termHeight)
+}
+
+// Draw draws the styled frame.
+func (s *frameStyler) Draw(frame image.Image) *image.RGBA {
+	// Scale the terminal into the padding, keeping its aspect ratio.
+	term := image.NewRGBA(image.Rect(0, 0, s.termWidth, s.termHeight))
+	draw.Draw(term, term.Bounds(), image.NewUniform(s.background), image.Point{}, draw.Src)
+	inner := term.Bounds().Inset(s.style.Padding)
+	size := frame.Bounds().Size()
+	if size != inner.Size() {
+		scale := min(float64(inner.Dx())/float64(size.X), float64(inner.Dy())/float64(size.Y))
+		size = image.Pt(int(float64(size.X)*scale), int(float64(size.Y)*scale))
+	}
+	offset := term.Bounds().Size().Sub(size).Div(doublingFactor)
+	xdraw.ApproxBiLinear.Scale(term, image.Rectangle{Min: offset, Max: offset.Add(size)}, frame, frame.Bounds(), draw.Src, nil)
+
+	window := term
+	if s.bar != nil {
+		window = image.NewRGBA(s.bar.Bounds())
+		draw.Draw(window, window.Bounds(), s.bar, image.Point{}, draw.Src)
+		draw.Draw(window, term.Bounds().Add(image.Pt(0, s.style.WindowBarSize)), term, image.Point{}, draw.Src)
+	}
+
+	dst := image.NewRGBA(s.Bounds())
+	op := draw.Src
+	if s.margin != nil {
+		draw.Draw(dst, dst.Bounds(), s.margin, image.Point{}, draw.Src)
+		op = draw.Over
+	}
+	origin := dst.Bounds().Size().Sub(window.Bounds().Size()).Div(doublingFactor)
+	rect := window.Bounds().Add(origin)
+	if s.mask != nil {
+		draw.DrawMask(dst, rect, window, image.Point{}, s.mask, image.Point{}, op)
+	} else {
+		draw.Draw(dst, rect, window, image.Point{}, op)
+	}
+	return dst
+}
@@ -3,9 +3,11 @@ package main
 import (
 	"fmt"
 	"io"
+	"os"
 	"strings"
 
 	"github.com/charmbracelet/vhs/parser"
+	"github.com/charmbracelet/vhs/token"
 )
 
 // InvalidSyntaxError is returned when the parser encounters one or more errors.
@@ -17,6 +19,45 @@ func (e InvalidSyntaxError) Error() string {
 	return fmt.Sprintf("parser: %d error(s)", len(e.Errors))
 }
 
+// ExpectationError is returned when one or more Expect or ExpectNot commands
+// failed. Failed expectations don't stop the tape, so that they are all
+// reported at once.
+type ExpectationError struct {
+	Errors []parser.Error
+}
+
+func (e ExpectationError) Error() string {
+	return fmt.Sprintf("%d expectation(s) failed", len(e.Errors))
+}
+
+// ScreenshotMismatch is a screenshot which differs from its reference image,
+// with the paths of the actual screenshot and of the image of the differences
+// when they were written.
+type ScreenshotMismatch struct {
+	Path   string
+	Actual string
+	Diff   string
+	Ratio  float64
+	Msg    string
+}
+
+// VisualError is returned when screenshots differ from their reference images.
+type VisualError struct {
+	Mismatches []ScreenshotMismatch
+}
+
+func (e VisualError) Error() string {
+	var sb strings.Builder
+	_, _ = fmt.Fprintf(&sb, "%d screenshot(s) differ from their reference", len(e.Mismatches))
+	for _, m := range e.Mismatches {
+		_, _ = fmt.Fprintf(&sb, "\n  %s: %s", m.Path, m.Msg)
+		if m.Diff != "" {
+			_, _ = fmt.Fprintf(&sb, " (see %s)", m.Diff)
+		}
+	}
+	return sb.String()
+}
+
 // ErrorColumnOffset is the number of columns that an error should be printed
 // to the left to account for the line number.
 const ErrorColumnOffset = 5
@@ -27,21 +68,80 @@ func Underline(n int) string {
 	return ErrorStyle.Render(strings.Repeat("^", n))
 }
 
+// CommandUnderline returns a string of ~ characters which helps underline the
+// rest of the command of a parser.Error.
+func CommandUnderline(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return GrayStyle.Render(strings.Repeat("~", n))
+}
+
 // LineNumber returns a formatted version of the given line number.
 func LineNumber(line int) string {
 	return LineNumberStyle.Render(fmt.Sprintf(" %2d │ ", line))
 }
 
 func printError(out io.Writer, tape string, err parser.Error) {
+	// Errors inside a sourced tape point to a line of that tape.
+	if err.File != "" {
+		_, _ = fmt.Fprintln(out, ErrorStyle.Render(err.File+":"))
+		if b, rerr := os.ReadFile(err.File); rerr == nil { //nolint:gosec
+			tape = string(b)
+		}
+	}
+
 	lines := strings.Split(tape, "\n")
+	if err.Token.Line < 1 || err.Token.Line > len(lines) {
+		_, _ = fmt.Fprintln(out, ErrorStyle.Render(err.String()))
+		return
+	}
+	line := lines[err.Token.Line-1]
+
+	// Underline the whole command when it is on the line of the error, with
+	// the problematic token highlighted.
+	start, width := err.Token.Column, tokenWidth(line, err.Token)
+	end := start + width
+	if err.Start.Line == err.Token.Line && err.End.Line == err.Token.Line {
+		start = min(start, err.Start.Column)
+		end = max(end, err.End.Column+tokenWidth(line, err.End))
+	}
 
 	_, _ = fmt.Fprint(out, LineNumber(err.Token.Line))
-	_, _ = fmt.Fprintln(out, lines[err.Token.Line-1])
-	_, _ = fmt.Fprint(out, strings.Repeat(" ", err.Token.Column+ErrorColumnOffset))
-	_, _ = fmt.Fprintln(out, Underline(len(err.Token.Literal)), err.Msg)
+	_, _ = fmt.Fprintln(out, line)
+	_, _ = fmt.Fprint(out, strings.Repeat(" ", start+ErrorColumnOffset))
+	_, _ = fmt.Fprintln(out,
+		CommandUnderline(err.Token.Column-start)+Underline(width)+CommandUnderline(end-err.Token.Column-width),
+		err.Msg)
+
+	// Show how to fix the error, with the fixed line when the fix is known.
+	if err.Hint != "" {
+		_, _ = fmt.Fprint(out, strings.Repeat(" ", err.Token.Column+ErrorColumnOffset))
+		_, _ = fmt.Fprintln(out, GrayStyle.Render("hint: "+err.Hint))
+	}
+	if err.Fix != "" {
+		_, _ = fmt.Fprint(out, LineNumber(err.Token.Line))
+		_, _ = fmt.Fprintln(out, line[:err.Token.Column-1]+StringStyle.Render(err.Fix)+line[err.Token.Column-1+width:])
+	}
 	_, _ = fmt.Fprintln(out)
 }
 
+// tokenWidth returns the width of a token as written on the given line,
+// including the delimiters of strings and regular expressions.
+func tokenWidth(line string, t token.Token) int {
+	width := len(t.Literal)
+	if t.Column < 1 || t.Column > len(line) {
+		return width
+	}
+	switch c := line[t.Column-1]; {
+	case t.Type == token.STRING && (c == '"' || c == '\'' || c == '`'):
+		width += 2
+	case t.Type == token.REGEX && c == '/':
+		width += 2
+	}
+	return width
+}
+
 func printErrors(out io.Writer, tape string, errs []error) {
 	for _, err := range errs {
 		switch err := err.(type) {
@@ -51,6 +151,17 @@ func printErrors(out io.Writer, tape string, errs []error) {
 			}
 			_, _ = fmt.Fprintln(out, ErrorStyle.Render(err.Error()))
 
+		case ExpectationError:
+			for _, v := range err.Errors {
+				printError(out, tape, v)
+			}
+			_, _ = fmt.Fprintln(out, ErrorStyle.Render(err.Error()))
+
+		case VisualError:
+			for _, line := range strings.Split(err.Error(), "\n") {
+				_, _ = fmt.Fprintln(out, ErrorStyle.Render(line))
+			}
+
 		default:
 			_, _ = fmt.Fprintln(out, ErrorStyle.Render(err.Error()))
 		}
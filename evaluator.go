@@ -6,21 +6,81 @@ import (
 	"io"
 	"log"
 	"os"
+	"slices"
 
 	"github.com/charmbracelet/vhs/lexer"
 	"github.com/charmbracelet/vhs/parser"
 	"github.com/charmbracelet/vhs/token"
-	"github.com/go-rod/rod"
 )
 
-// EvaluatorOption is a function that can be used to modify the VHS instance.
+// runtimeSettings are the settings which take effect where they are set in
+// the tape. Other settings are ignored once the tape has started.
+var runtimeSettings = []token.Type{
+	token.TYPING_SPEED,
+	token.FONT_SIZE,
+	token.FONT_FAMILY,
+	token.THEME,
+	token.LINE_HEIGHT,
+	token.LETTER_SPACING,
+	token.PADDING,
+}
+
+// ignoredSetting reports whether the command is a setting which is ignored
+// since it comes after the first command, and warns about it.
+//
+// Settings which change the dimensions of the xterm.js canvas, such as the
+// FontSize, take effect while recording since the frames are normalized to the
+// dimensions of the first one. The other settings apply to the whole video, so
+// they must be at the top.
+func ignoredSetting(cmd parser.Command) bool {
+	if cmd.Type != token.SET || slices.Contains(runtimeSettings, token.Keywords[cmd.Options]) {
+		return false
+	}
+	fmt.Println(ErrorStyle.Render(fmt.Sprintf("WARN: 'Set %s %s' has been ignored. Move the directive to the top of the file.\nLearn more: https://github.com/charmbracelet/vhs#settings", cmd.Options, cmd.Args)))
+	return true
+}
+
+// EvaluatorOption is a function that can be used to modify the VHS instance
+// before the tape is evaluated.
 type EvaluatorOption func(*VHS)
 
+// WithParseOptions returns an EvaluatorOption which configures the parsing of
+// the tape, e.g. to set variables.
+func WithParseOptions(opts ...parser.Option) EvaluatorOption {
+	return func(v *VHS) {
+		v.parseOptions = append(v.parseOptions, opts...)
+	}
+}
+
+// BeforeRecord returns an EvaluatorOption which modifies the VHS instance once
+// the settings at the top of the tape and the outputs are executed, before the
+// recording starts, e.g. to override the videos which are encoded while
+// recording.
+func BeforeRecord(fn func(*VHS)) EvaluatorOption {
+	return func(v *VHS) {
+		v.beforeRecord = append(v.beforeRecord, fn)
+	}
+}
+
+// BeforeRender returns an EvaluatorOption which modifies the VHS instance once
+// all the commands of the tape are executed, before its outputs are rendered,
+// e.g. to override them.
+func BeforeRender(fn func(*VHS)) EvaluatorOption {
+	return func(v *VHS) {
+		v.beforeRender = append(v.beforeRender, fn)
+	}
+}
+
 // Evaluate takes as input a tape string, an output writer, and an output file
 // and evaluates all the commands within the tape string and produces a GIF.
 func Evaluate(ctx context.Context, tape string, out io.Writer, opts ...EvaluatorOption) []error {
+	v := New()
+	for _, opt := range opts {
+		opt(&v)
+	}
+
 	l := lexer.New(tape)
-	p := parser.New(l)
+	p := parser.New(l, v.parseOptions...)
 
 	cmds := p.Parse()
 	errs := p.Errors()
@@ -28,9 +88,9 @@ func Evaluate(ctx context.Context, tape string, out io.Writer, opts ...Evaluator
 		return []error{InvalidSyntaxError{errs}}
 	}
 
-	v := New()
+	v.steps = stepRecorderFrom(ctx)
 	for _, cmd := range cmds {
-		if cmd.Type == token.SET && cmd.Options == "Shell" || cmd.Type == token.ENV {
+		if cmd.Type == token.SET && (cmd.Options == "Shell" || cmd.Options == "Backend") || cmd.Type == token.ENV {
 			err := Execute(cmd, &v)
 			if err != nil {
 				return []error{err}
@@ -42,21 +102,13 @@ func Evaluate(ctx context.Context, tape string, out io.Writer, opts ...Evaluator
 	if err := v.Start(); err != nil {
 		return []error{err}
 	}
-	defer func() { _ = v.close() }()
-
-	// Let's wait until we can access the window.term variable.
-	//
-	// This is necessary because some SET commands modify the terminal.
-	err := v.Page.Wait(rod.Eval("() => window.term != undefined"))
-	if err != nil {
-		return []error{err}
-	}
+	defer func() { _ = v.backend.Close() }()
 
 	var offset int
 	for i, cmd := range cmds {
 		if cmd.Type == token.SET || cmd.Type == token.OUTPUT || cmd.Type == token.REQUIRE {
 			_, _ = fmt.Fprintln(out, Highlight(cmd, false))
-			if cmd.Options != "Shell" {
+			if cmd.Options != "Shell" && cmd.Options != "Backend" {
 				err := Execute(cmd, &v)
 				if err != nil {
 					return []error{err}
@@ -70,11 +122,7 @@ func Evaluate(ctx context.Context, tape string, out io.Writer, opts ...Evaluator
 
 	// Make sure image is big enough to fit padding, bar, and margins
 	video := v.Options.Video
-	minWidth := double(video.Style.Padding) + double(video.Style.Margin)
-	minHeight := double(video.Style.Padding) + double(video.Style.Margin)
-	if video.Style.WindowBar != "" {
-		minHeight += video.Style.WindowBarSize
-	}
+	minWidth, minHeight := video.Style.MinDimensions()
 	if video.Style.Height < minHeight || video.Style.Width < minWidth {
 		//nolint:staticcheck
 		v.Errors = append(
@@ -91,7 +139,9 @@ func Evaluate(ctx context.Context, tape string, out io.Writer, opts ...Evaluator
 	}
 
 	// Setup the terminal session so we can start executing commands.
-	v.Setup()
+	if err := v.Setup(); err != nil {
+		return []error{err}
+	}
 
 	// If the first command (after Settings and Outputs) is a Hide command, we can
 	// begin executing the commands before we start recording to avoid capturing
@@ -110,6 +160,26 @@ func Evaluate(ctx context.Context, tape string, out io.Writer, opts ...Evaluator
 		}
 	}
 
+	// The videos are encoded while recording, into the outputs set when it
+	// starts, so the outputs set later in the tape are set beforehand.
+	for _, cmd := range cmds[offset:] {
+		if cmd.Type == token.OUTPUT {
+			if err := Execute(cmd, &v); err != nil {
+				return []error{err}
+			}
+		}
+	}
+
+	// If running as an SSH server, the output file is a temporary file
+	// to use for the output.
+	//
+	// We need to set the GIF file path after the settings and outputs are
+	// executed, but before the frames are encoded into it, which starts with
+	// the recording. This is done in `serve.go`.
+	for _, fn := range v.beforeRecord {
+		fn(&v)
+	}
+
 	// Begin recording frames as we are now in a recording state.
 	ctx, cancel := context.WithCancel(ctx) //nolint:gosec
 	ch := v.Record(ctx)
@@ -141,22 +211,10 @@ func Evaluate(ctx context.Context, tape string, out io.Writer, opts ...Evaluator
 	for _, cmd := range cmds[offset:] {
 		if ctx.Err() != nil {
 			teardown()
-			return []error{ctx.Err()}
+			return append(v.expectationErrors(), ctx.Err())
 		}
 
-		// When changing the FontFamily, FontSize, LineHeight, Padding
-		// The xterm.js canvas changes dimensions and causes FFMPEG to not work
-		// correctly (specifically) with palettegen.
-		// It will be possible to change settings on the fly in the future, but
-		// it is currently not as it does not result in a proper render of the
-		// GIF as the frame sequence will change dimensions. This is fixable.
-		//
-		// We should remove if isSetting statement.
-		isSetting := cmd.Type == token.SET && cmd.Options != "TypingSpeed"
-
-		if isSetting {
-			fmt.Println(ErrorStyle.Render(fmt.Sprintf("WARN: 'Set %s %s' has been ignored. Move the directive to the top of the file.\nLearn more: https://github.com/charmbracelet/vhs#settings", cmd.Options, cmd.Args)))
-		}
+		isSetting := ignoredSetting(cmd)
 		if isSetting || cmd.Type == token.REQUIRE {
 			_, _ = fmt.Fprintln(out, Highlight(cmd, true))
 			continue
@@ -165,25 +223,17 @@ func Evaluate(ctx context.Context, tape string, out io.Writer, opts ...Evaluator
 		err := Execute(cmd, &v)
 		if err != nil {
 			teardown()
-			return []error{err}
+			return append(v.expectationErrors(), err)
 		}
 	}
 
-	// If running as an SSH server, the output file is a temporary file
-	// to use for the output.
-	//
-	// We need to set the GIF file path before it is created but after all of
-	// the settings and commands are executed. This is done in `serve.go`.
-	//
-	// Since the GIF creation is deferred, setting the output file here will
-	// achieve what we want.
-	for _, opt := range opts {
-		opt(&v)
+	for _, fn := range v.beforeRender {
+		fn(&v)
 	}
 
 	teardown()
 	if err := v.Render(); err != nil {
-		return []error{err}
+		return append(v.expectationErrors(), err)
 	}
-	return nil
+	return v.expectationErrors()
 }
@@ -22,16 +22,14 @@ func NewVideoFilterBuilder(videoOpts *VideoOptions) *FilterComplexBuilder {
 	termWidth, termHeight := calcTermDimensions(*videoOpts.Style)
 
 	_, _ = fmt.Fprintf(&filterCode, `
-		[0][1]overlay[merged];
-		[merged]scale=%d:%d:force_original_aspect_ratio=1[scaled];
-		[scaled]fps=%d,setpts=PTS/%f[speed];
+		[0]scale=%d:%d:force_original_aspect_ratio=1[scaled];
+		[scaled]setpts=PTS/%f[speed];
 		[speed]pad=%d:%d:(ow-iw)/2:(oh-ih)/2:%s[padded];
 		[padded]fillborders=left=%d:right=%d:top=%d:bottom=%d:mode=fixed:color=%s[padded]
 		`,
 		termWidth-double(videoOpts.Style.Padding),
 		termHeight-double(videoOpts.Style.Padding),
 
-		videoOpts.Framerate,
 		videoOpts.PlaybackSpeed,
 
 		termWidth,
@@ -168,16 +166,27 @@ func (fb *FilterComplexBuilder) WithMarginFill(marginStream int) *FilterComplexB
 	return fb
 }
 
-// WithGIF adds gif options to ffmepg filter_complex.
-func (fb *FilterComplexBuilder) WithGIF() *FilterComplexBuilder {
+// WithGIF adds gif options to ffmepg filter_complex, with a palette of at
+// most maxColors colors and the given dithering, if any.
+func (fb *FilterComplexBuilder) WithGIF(maxColors int, dither string) *FilterComplexBuilder {
+	paletteuse := "paletteuse"
+	switch dither {
+	case GIFDitherNone:
+		paletteuse += "=dither=none"
+	case GIFDitherFloydSteinberg:
+		paletteuse += "=dither=floyd_steinberg"
+	}
+
 	fb.filterComplex.WriteString(";")
 	_, _ = fmt.Fprintf(
 		fb.filterComplex,
 		`
 		[%s]split[plt_a][plt_b];
-		[plt_a]palettegen=max_colors=256[plt];
-		[plt_b][plt]paletteuse[palette]`,
+		[plt_a]palettegen=max_colors=%d[plt];
+		[plt_b][plt]%s[palette]`,
 		fb.prevStageName,
+		min(max(maxColors, 2), maxGIFColors), //nolint:mnd
+		paletteuse,
 	)
 	fb.prevStageName = "palette"
 
@@ -318,6 +327,32 @@ func (sb *StreamBuilder) WithWebm() *StreamBuilder {
 	return sb
 }
 
+// WithWebP adds animated webp stream with required config.
+func (sb *StreamBuilder) WithWebP(quality int, lossless bool) *StreamBuilder {
+	losslessFlag := "0"
+	if lossless {
+		losslessFlag = "1"
+	}
+	sb.args = append(sb.args,
+		"-vcodec", "libwebp",
+		"-lossless", losslessFlag,
+		"-quality", fmt.Sprint(quality),
+		"-loop", "0",
+		"-an",
+	)
+	return sb
+}
+
+// WithAPNG adds animated png stream with required config.
+func (sb *StreamBuilder) WithAPNG() *StreamBuilder {
+	sb.args = append(sb.args,
+		"-f", "apng",
+		"-plays", "0",
+		"-an",
+	)
+	return sb
+}
+
 // Build returns streams for using with ffmepg.
 func (sb *StreamBuilder) Build() []string {
 	return sb.args
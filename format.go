@@ -0,0 +1,577 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/vhs/lexer"
+	"github.com/charmbracelet/vhs/parser"
+	"github.com/charmbracelet/vhs/token"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fmtWrite bool
+	fmtCheck bool
+
+	fmtCmd = &cobra.Command{
+		Use:   "fmt <file>...",
+		Short: "Format tape files in the canonical style",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			valid := true
+			var unformatted []string
+
+			for _, file := range args {
+				b, err := os.ReadFile(file)
+				if err != nil {
+					return fmt.Errorf("failed to read %s: %w", file, err)
+				}
+
+				formatted, err := Format(string(b), parser.WithFile(file))
+				if err != nil {
+					var syntaxErr InvalidSyntaxError
+					if !errors.As(err, &syntaxErr) {
+						return err
+					}
+					log.Println(ErrorFileStyle.Render(file))
+					for _, err := range syntaxErr.Errors {
+						printError(os.Stderr, string(b), err)
+					}
+					valid = false
+					continue
+				}
+
+				changed := formatted != string(b)
+				if fmtCheck {
+					if changed {
+						unformatted = append(unformatted, file)
+						_, _ = fmt.Fprintln(cmd.OutOrStdout(), file)
+					}
+					continue
+				}
+
+				if !fmtWrite {
+					_, _ = fmt.Fprint(cmd.OutOrStdout(), formatted)
+					continue
+				}
+
+				if changed {
+					if err := os.WriteFile(file, []byte(formatted), 0o600); err != nil { //nolint:mnd
+						return fmt.Errorf("failed to write %s: %w", file, err)
+					}
+					log.Println(GrayStyle.Render("Formatted " + file))
+				}
+			}
+
+			if !valid {
+				return errors.New("invalid tape file(s)")
+			}
+			if len(unformatted) > 0 {
+				return fmt.Errorf("%d tape file(s) not formatted", len(unformatted))
+			}
+
+			return nil
+		},
+	}
+)
+
+// headerOrder is the order of the groups of commands which are moved to the
+// top of a formatted tape.
+var headerOrder = []token.Type{
+	token.OUTPUT,
+	token.REQUIRE,
+	token.LET,
+	token.SET,
+	token.ENV,
+}
+
+// settingsOrder is the order of the settings at the top of a formatted tape.
+var settingsOrder = []token.Type{
+	token.SHELL,
+	token.FONT_SIZE,
+	token.FONT_FAMILY,
+	token.WIDTH,
+	token.HEIGHT,
+	token.LETTER_SPACING,
+	token.LINE_HEIGHT,
+	token.TYPING_SPEED,
+	token.THEME,
+	token.PADDING,
+	token.MARGIN,
+	token.MARGIN_FILL,
+	token.WINDOW_BAR,
+	token.WINDOW_BAR_SIZE,
+	token.BORDER_RADIUS,
+	token.FRAMERATE,
+	token.PLAYBACK_SPEED,
+	token.LOOP_OFFSET,
+	token.CURSOR_BLINK,
+	token.CLOCK,
+	token.BACKEND,
+	token.GIF_ENCODER,
+	token.GIF_DITHER,
+	token.MAX_COLORS,
+	token.WEBP_QUALITY,
+	token.WEBP_LOSSLESS,
+	token.WAIT_TIMEOUT,
+	token.WAIT_PATTERN,
+}
+
+// keypresses are the commands which take an optional speed and repeat count.
+var keypresses = []token.Type{
+	token.SPACE, token.BACKSPACE, token.DELETE, token.INSERT, token.ENTER,
+	token.ESCAPE, token.TAB, token.DOWN, token.LEFT, token.RIGHT, token.UP,
+	token.PAGE_UP, token.PAGE_DOWN, token.SCROLL_UP, token.SCROLL_DOWN,
+}
+
+// tapeLine is a line of a tape, either a command or a comment.
+type tapeLine struct {
+	tokens  []token.Token
+	comment string
+	blank   bool
+	depth   int
+}
+
+// tapeItem is a top-level command of a tape, along with the comments preceding
+// it and the body of its block.
+type tapeItem struct {
+	lines []tapeLine
+}
+
+// kind returns the type of the command of the item.
+func (it tapeItem) kind() token.Type {
+	for _, l := range it.lines {
+		if l.tokens[0].Type != token.COMMENT {
+			return l.tokens[0].Type
+		}
+	}
+	return token.COMMENT
+}
+
+// command returns the tokens of the command of the item.
+func (it tapeItem) command() []token.Token {
+	for _, l := range it.lines {
+		if l.tokens[0].Type != token.COMMENT {
+			return l.tokens
+		}
+	}
+	return nil
+}
+
+// Format returns the canonical formatting of a tape. Comments are preserved,
+// settings are grouped at the top of the tape, strings are quoted consistently,
+// durations are normalized and repeated key presses are collapsed.
+//
+// The tape is parsed first and an InvalidSyntaxError is returned if it is not
+// valid.
+func Format(tape string, opts ...parser.Option) (string, error) {
+	p := parser.New(lexer.New(tape), opts...)
+	_ = p.Parse()
+	if errs := p.Errors(); len(errs) > 0 {
+		return "", InvalidSyntaxError{errs}
+	}
+
+	lines := collapseKeypresses(splitLines(tape))
+	items, trailer := groupItems(lines)
+	header, body := hoistItems(items)
+
+	var b strings.Builder
+	for _, group := range header {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		for _, it := range group {
+			writeItem(&b, it, false)
+		}
+	}
+	for i, it := range body {
+		blank := it.lines[0].blank && i > 0
+		if i == 0 && b.Len() > 0 {
+			blank = true
+		}
+		writeItem(&b, tapeItem{lines: it.lines}, blank)
+	}
+	if len(trailer) > 0 {
+		trailer[0].blank = trailer[0].blank && b.Len() > 0
+		writeItem(&b, tapeItem{lines: trailer}, trailer[0].blank)
+	}
+
+	return b.String(), nil
+}
+
+// splitLines splits the tokens of a tape into lines.
+func splitLines(tape string) []tapeLine {
+	var lines []tapeLine
+	l := lexer.New(tape)
+	prev := 0
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		if len(lines) > 0 && lines[len(lines)-1].tokens[0].Line == tok.Line {
+			last := &lines[len(lines)-1]
+			if tok.Type == token.COMMENT {
+				last.comment = strings.TrimRight(tok.Literal, " \t\r")
+				continue
+			}
+			last.tokens = append(last.tokens, tok)
+			continue
+		}
+		lines = append(lines, tapeLine{
+			tokens: []token.Token{tok},
+			blank:  prev > 0 && tok.Line > prev+1,
+		})
+		prev = tok.Line
+	}
+	return lines
+}
+
+// collapseKeypresses merges consecutive lines pressing the same key at the
+// same speed into a single command with a repeat count.
+func collapseKeypresses(lines []tapeLine) []tapeLine {
+	collapsed := make([]tapeLine, 0, len(lines))
+	for _, l := range lines {
+		speed, count, ok := keypress(l.tokens)
+		if !ok {
+			collapsed = append(collapsed, l)
+			continue
+		}
+
+		if n := len(collapsed); n > 0 && !l.blank && collapsed[n-1].comment == "" {
+			prev := collapsed[n-1]
+			prevSpeed, prevCount, prevOK := keypress(prev.tokens)
+			if prevOK && prev.tokens[0].Type == l.tokens[0].Type && prevSpeed == speed {
+				l.blank = prev.blank
+				l.tokens = withCount(l.tokens, prevCount+count)
+				collapsed[n-1] = l
+				continue
+			}
+		}
+
+		l.tokens = withCount(l.tokens, count)
+		collapsed = append(collapsed, l)
+	}
+	return collapsed
+}
+
+// keypress returns the formatted speed and the repeat count of a key press
+// command.
+func keypress(ts []token.Token) (string, int, bool) {
+	if !slices.Contains(keypresses, ts[0].Type) {
+		return "", 0, false
+	}
+
+	i := 1
+	var speed string
+	if i < len(ts) && ts[i].Type == token.AT {
+		end := i + 2 //nolint:mnd
+		if end < len(ts) && isUnit(ts[end].Type) {
+			end++
+		}
+		if end > len(ts) {
+			return "", 0, false
+		}
+		speed = formatTokens(ts[i:end], ts[0].Type)
+		i = end
+	}
+
+	count := 1
+	if i < len(ts) {
+		if ts[i].Type != token.NUMBER || i != len(ts)-1 {
+			return "", 0, false
+		}
+		n, err := strconv.Atoi(ts[i].Literal)
+		if err != nil {
+			return "", 0, false
+		}
+		count = n
+	}
+
+	return speed, count, true
+}
+
+// withCount sets the repeat count of a key press command, omitting it when
+// the key is pressed once.
+func withCount(ts []token.Token, count int) []token.Token {
+	if last := ts[len(ts)-1]; last.Type == token.NUMBER && ts[len(ts)-2].Type != token.AT {
+		ts = ts[:len(ts)-1]
+	}
+	if count == 1 {
+		return ts
+	}
+	return append(slices.Clone(ts), token.Token{Type: token.NUMBER, Literal: strconv.Itoa(count)})
+}
+
+// groupItems groups the lines of a tape into top-level items. Comments belong
+// to the command following them and blocks include all the lines up to their
+// End. The comments at the end of the tape are returned separately.
+func groupItems(lines []tapeLine) ([]tapeItem, []tapeLine) {
+	var items []tapeItem
+	var pending []tapeLine
+	depth := 0
+
+	for _, l := range lines {
+		t := l.tokens[0].Type
+		if depth > 0 {
+			switch {
+			case t == token.END:
+				depth--
+				l.depth = depth
+			case t == token.ELSE:
+				l.depth = depth - 1
+			default:
+				l.depth = depth
+				if isBlockStart(t) {
+					depth++
+				}
+			}
+			items[len(items)-1].lines = append(items[len(items)-1].lines, l)
+			continue
+		}
+
+		if t == token.COMMENT {
+			pending = append(pending, l)
+			continue
+		}
+
+		items = append(items, tapeItem{lines: append(pending, l)})
+		pending = nil
+		if isBlockStart(t) {
+			depth++
+		}
+	}
+
+	return items, pending
+}
+
+// hoistItems splits the items of a tape into the groups of commands at the top
+// of the tape, in headerOrder, and the remaining commands. Only the Output
+// commands are moved across the actions of the tape: the other commands are
+// ignored once it has started. A Source starts the body of the tape, since
+// the sourced tape may run actions or define variables. An item referencing a
+// variable is never moved above the Let or Source which may define it.
+func hoistItems(items []tapeItem) ([][]tapeItem, []tapeItem) {
+	groups := make([][]tapeItem, len(headerOrder))
+	var body []tapeItem
+	started, sourced := false, false
+	// lets are the variables defined by Let commands, and whether the Let is
+	// moved to the top.
+	lets := make(map[string]bool)
+
+	for _, it := range items {
+		kind := it.kind()
+		hoist := false
+		switch kind {
+		case token.OUTPUT:
+			hoist = true
+		case token.REQUIRE, token.LET, token.ENV, token.SET:
+			hoist = !started
+		case token.SOURCE:
+			started, sourced = true, true
+		case token.DEFINE:
+		default:
+			started = true
+		}
+
+		// Items referencing variables follow their Let commands, either at
+		// the top of the tape or in place, and stay below a Source which may
+		// define them.
+		group := slices.Index(headerOrder, kind)
+		for _, name := range references(it.command()) {
+			hoisted, ok := lets[name]
+			if !ok {
+				hoist = hoist && !sourced
+				continue
+			}
+			hoist = hoist && hoisted
+			group = max(group, slices.Index(headerOrder, token.LET))
+		}
+		if ts := it.command(); kind == token.LET && len(ts) > 1 {
+			lets[ts[1].Literal] = hoist
+		}
+
+		if !hoist {
+			body = append(body, it)
+			continue
+		}
+		groups[group] = append(groups[group], it)
+	}
+
+	sets := groups[slices.Index(headerOrder, token.SET)]
+	slices.SortStableFunc(sets, func(a, b tapeItem) int {
+		return settingIndex(a.command()) - settingIndex(b.command())
+	})
+
+	header := make([][]tapeItem, 0, len(groups))
+	for _, g := range groups {
+		if len(g) > 0 {
+			header = append(header, g)
+		}
+	}
+	return header, body
+}
+
+// references returns the names of the variables referenced by the tokens.
+func references(ts []token.Token) []string {
+	var names []string
+	for _, t := range ts {
+		names = append(names, parser.References(t.Literal)...)
+	}
+	return names
+}
+
+// isBlockStart returns whether the command starts a block closed by End.
+func isBlockStart(t token.Type) bool {
+	return t == token.DEFINE || t == token.REPEAT || t == token.IF
+}
+
+// settingIndex returns the position of a setting in settingsOrder.
+func settingIndex(ts []token.Token) int {
+	if len(ts) < 2 { //nolint:mnd
+		return len(settingsOrder)
+	}
+	if i := slices.Index(settingsOrder, ts[1].Type); i >= 0 {
+		return i
+	}
+	return len(settingsOrder)
+}
+
+// writeItem writes the lines of an item, indenting the bodies of blocks.
+func writeItem(b *strings.Builder, it tapeItem, blank bool) {
+	for i, l := range it.lines {
+		if (i == 0 && blank) || (i > 0 && l.blank) {
+			b.WriteString("\n")
+		}
+		b.WriteString(strings.Repeat("  ", l.depth))
+		if l.tokens[0].Type == token.COMMENT {
+			b.WriteString("#" + strings.TrimRight(l.tokens[0].Literal, " \t\r"))
+		} else {
+			b.WriteString(formatTokens(l.tokens, l.tokens[0].Type))
+		}
+		if l.comment != "" {
+			b.WriteString(" #" + l.comment)
+		}
+		b.WriteString("\n")
+	}
+}
+
+// formatTokens returns the canonical formatting of the tokens of a command.
+func formatTokens(ts []token.Token, cmd token.Type) string {
+	var b strings.Builder
+	for i := 0; i < len(ts); i++ {
+		t := ts[i]
+		if i > 0 && isSpaced(ts[i-1], t) {
+			b.WriteString(" ")
+		}
+
+		switch t.Type {
+		case token.NUMBER:
+			switch {
+			case i+1 < len(ts) && isUnit(ts[i+1].Type):
+				b.WriteString(formatDuration(t.Literal, ts[i+1].Literal))
+				i++
+			case isDuration(ts, i, cmd):
+				b.WriteString(formatDuration(t.Literal, "s"))
+			default:
+				b.WriteString(t.Literal)
+			}
+			if cmd == token.SET && ts[1].Type == token.LOOP_OFFSET &&
+				(i+1 >= len(ts) || ts[i+1].Type != token.PERCENT) {
+				b.WriteString("%")
+			}
+		case token.STRING:
+			b.WriteString(formatString(ts, i, cmd))
+		case token.REGEX:
+			b.WriteString("/" + t.Literal + "/")
+		default:
+			b.WriteString(t.Literal)
+		}
+	}
+	return b.String()
+}
+
+// isSpaced returns whether two consecutive tokens are separated by a space.
+func isSpaced(prev, t token.Token) bool {
+	switch prev.Type {
+	case token.AT, token.PLUS, token.EQUAL:
+		return false
+	}
+	switch t.Type {
+	case token.AT, token.PLUS, token.EQUAL, token.PERCENT:
+		return false
+	}
+	return true
+}
+
+// isUnit returns whether the token is a time unit.
+func isUnit(t token.Type) bool {
+	return t == token.MILLISECONDS || t == token.SECONDS || t == token.MINUTES
+}
+
+// isDuration returns whether a number without unit is a duration in seconds.
+func isDuration(ts []token.Token, i int, cmd token.Type) bool {
+	switch {
+	case i > 0 && ts[i-1].Type == token.AT:
+		return true
+	case cmd == token.SLEEP:
+		return i == 1
+	case cmd == token.SET && i == 2: //nolint:mnd
+		return ts[1].Type == token.TYPING_SPEED || ts[1].Type == token.WAIT_TIMEOUT
+	}
+	return false
+}
+
+// formatDuration returns the canonical formatting of a duration, in
+// milliseconds below a second and in seconds otherwise.
+func formatDuration(n, unit string) string {
+	d, err := time.ParseDuration(n + unit)
+	if err != nil {
+		return n + unit
+	}
+	switch {
+	case d%time.Millisecond != 0:
+		return n + unit
+	case d < time.Second:
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	default:
+		return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+	}
+}
+
+// formatString returns the canonical formatting of a string argument. Text
+// is always quoted while names and paths are left bare when possible.
+func formatString(ts []token.Token, i int, cmd token.Type) string {
+	s := ts[i].Literal
+	switch cmd {
+	case token.TYPE, token.COPY:
+		return quote(s)
+	case token.ENV, token.LET, token.CALL:
+		if i == 1 {
+			return bare(s)
+		}
+		return quote(s)
+	case token.SET:
+		switch ts[1].Type {
+		case token.SHELL, token.WINDOW_BAR, token.CLOCK, token.BACKEND, token.GIF_ENCODER, token.GIF_DITHER:
+			return bare(s)
+		}
+		return quote(s)
+	default:
+		return bare(s)
+	}
+}
+
+// bare returns the string without quotes if it is lexed as the same string,
+// otherwise it is quoted.
+func bare(s string) string {
+	l := lexer.New(s)
+	t := l.NextToken()
+	if t.Type == token.STRING && t.Literal == s && l.NextToken().Type == token.EOF {
+		return s
+	}
+	return quote(s)
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/charmbracelet/vhs/lexer"
+	"github.com/charmbracelet/vhs/parser"
+	"github.com/charmbracelet/vhs/token"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name: "settings moved to the top",
+			input: `Set Height 600
+Require ls
+Set Shell "bash"
+# Demo
+Type "ls"
+Output demo.gif
+Enter
+Set TypingSpeed 100ms
+Type "pwd"
+`,
+			want: `Output demo.gif
+
+Require ls
+
+Set Shell bash
+Set Height 600
+
+# Demo
+Type "ls"
+Enter
+Set TypingSpeed 100ms
+Type "pwd"
+`,
+		},
+		{
+			name: "settings after an action stay in place",
+			input: `Set Width 1200
+Type "ls"
+Set Height 600
+Require ls
+Enter
+`,
+			want: `Set Width 1200
+
+Type "ls"
+Set Height 600
+Require ls
+Enter
+`,
+		},
+		{
+			name: "output after its variable",
+			input: `Let out "demo"
+Output "${out}.gif"
+Require git
+Let dir "src"
+Type "cd ${dir}"
+Let late "late"
+Output "${late}.mp4"
+Output "$${late}.webm"
+`,
+			want: `Output "$${late}.webm"
+
+Require git
+
+Let out "demo"
+Output "${out}.gif"
+Let dir "src"
+
+Type "cd ${dir}"
+Let late "late"
+Output "${late}.mp4"
+`,
+		},
+		{
+			name: "quoting",
+			input: `Output "demo.gif"
+Set Theme Dracula
+Set MarginFill "#674EFF"
+Set WindowBar "Colorful"
+Env "GREETING" 'hello'
+Type hello
+Type 'say "hi"'
+Type "it's"
+`,
+			want: `Output demo.gif
+
+Set Theme "Dracula"
+Set MarginFill "#674EFF"
+Set WindowBar Colorful
+
+Env GREETING "hello"
+
+Type "hello"
+Type 'say "hi"'
+Type "it's"
+`,
+		},
+		{
+			name: "durations",
+			input: `Set TypingSpeed 0.05
+Set WaitTimeout 1m
+Set LoopOffset 20
+Type@0.1 "x"
+Sleep 2
+Sleep 1500ms
+Sleep 0.5s
+Wait@2000ms /done/
+`,
+			want: `Set TypingSpeed 50ms
+Set LoopOffset 20%
+Set WaitTimeout 60s
+
+Type@100ms "x"
+Sleep 2s
+Sleep 1.5s
+Sleep 500ms
+Wait@2s /done/
+`,
+		},
+		{
+			name: "repeat counts",
+			input: `Down
+Down
+Down 3
+Down@100ms
+Up 1
+Enter
+
+Enter
+Left # go back
+Left
+`,
+			want: `Down 5
+Down@100ms
+Up
+Enter
+
+Enter
+Left # go back
+Left
+`,
+		},
+		{
+			name: "comments and blocks",
+			input: `# Setup
+Set Width 1200
+
+Define greet name
+# say hello
+Type "hello ${name}"
+End
+Repeat 2
+If /ready/
+Call greet "world"
+Else
+Sleep 1
+End
+End
+# the end
+`,
+			want: `# Setup
+Set Width 1200
+
+Define greet name
+  # say hello
+  Type "hello ${name}"
+End
+Repeat 2
+  If /ready/
+    Call greet "world"
+  Else
+    Sleep 1s
+  End
+End
+# the end
+`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Format(tc.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("want:\n%s\ngot:\n%s", tc.want, got)
+			}
+
+			again, err := Format(got)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if again != got {
+				t.Fatalf("formatting is not idempotent:\n%s", again)
+			}
+		})
+	}
+}
+
+// evaluatedCommands parses a tape and returns the commands which apply to the
+// whole recording, i.e. its outputs, environment and the settings at its top,
+// sorted, and the other commands in the order they run.
+func evaluatedCommands(t *testing.T, tape string, opts ...parser.Option) ([]string, []string) {
+	t.Helper()
+	p := parser.New(lexer.New(tape), opts...)
+	cmds := p.Parse()
+	if errs := p.Errors(); len(errs) > 0 {
+		t.Fatalf("unexpected errors in:\n%s\n%v", tape, errs)
+	}
+
+	var header, body []string
+	started := false
+	for _, c := range cmds {
+		s := fmt.Sprintf("%s %s %s %s", c.Type, c.Options, c.Args, c.Source)
+		switch {
+		case c.Type == token.OUTPUT || c.Type == token.ENV:
+			header = append(header, s)
+		case !started && (c.Type == token.SET || c.Type == token.REQUIRE):
+			header = append(header, s)
+		default:
+			started = true
+			body = append(body, s)
+		}
+	}
+	slices.Sort(header)
+	return header, body
+}
+
+func TestFormatKeepsCommands(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"vars.tape": "Let base \"x\"\n",
+		"act.tape":  "Type \"hi\"\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tapes := []string{
+		"Source \"vars.tape\"\nLet full \"${base}/y\"\nType \"${full}\"\n",
+		"Set Width 1200\nSource \"act.tape\"\nSet Height 600\nType \"x\"\n",
+		"Source \"vars.tape\"\nType \"a\"\nOutput \"${base}.gif\"\n",
+		"Let name \"demo\"\nEnv HOME \"/tmp\"\nSet Width 1200\nType \"${name}\"\nOutput \"${name}.gif\"\nSet TypingSpeed 10ms\n",
+	}
+	for _, tape := range tapes {
+		opt := parser.WithFile(filepath.Join(dir, "demo.tape"))
+		formatted, err := Format(tape, opt)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		header, body := evaluatedCommands(t, tape, opt)
+		gotHeader, gotBody := evaluatedCommands(t, formatted, opt)
+		if !slices.Equal(header, gotHeader) || !slices.Equal(body, gotBody) {
+			t.Errorf("formatting changed the commands of:\n%s\ninto:\n%s\nwant %q %q, got %q %q",
+				tape, formatted, header, body, gotHeader, gotBody)
+		}
+	}
+}
+
+func TestFormatInvalid(t *testing.T) {
+	_, err := Format("Type")
+	var syntaxErr InvalidSyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("expected syntax error, got %v", err)
+	}
+}
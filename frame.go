@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+
+	"golang.org/x/image/draw"
+)
+
+// frameLayout is the layout of the captured frames. Settings such as the font
+// size or padding change the dimensions of the xterm.js canvases while
+// recording, so every frame is normalized to the dimensions of the first one
+// before it is encoded: ffmpeg can't encode frames of different sizes.
+type frameLayout struct {
+	// Width and Height are the dimensions of the first captured frame, which
+	// every other frame is normalized to.
+	Width, Height int
+	// Viewport is the viewport of the page when the first frame was captured.
+	Viewport image.Point
+}
+
+// bounds returns the bounds of a normalized frame.
+func (l frameLayout) bounds() image.Rectangle {
+	return image.Rect(0, 0, l.Width, l.Height)
+}
+
+// box returns the area of the normalized frame available to a frame captured
+// with the given viewport, e.g. smaller when the padding has grown. It never
+// exceeds the bounds of the frame.
+func (l frameLayout) box(viewport image.Point) image.Rectangle {
+	inset := max(min(half(l.Viewport.X-viewport.X), half(l.Viewport.Y-viewport.Y)), 0)
+	return l.bounds().Inset(inset)
+}
+
+// capturedFrame is a frame of the recording: the text and cursor layers of the
+// terminal normalized to the layout of the frames, and the image of the cursor
+// drawn over the text which is encoded into the videos.
+type capturedFrame struct {
+	text, cursor image.Image
+	image        *image.RGBA
+	// screen is the screen of the terminal, captured for the SVG output, and
+	// output the output of the shell shown in the frame, captured for the
+	// asciicast output.
+	screen *screen
+	output []byte
+}
+
+// newCapturedFrame returns the frame of the text and cursor layers.
+func newCapturedFrame(text, cursor image.Image) capturedFrame {
+	img := image.NewRGBA(text.Bounds())
+	draw.Draw(img, img.Bounds(), text, text.Bounds().Min, draw.Src)
+	draw.Draw(img, img.Bounds(), cursor, cursor.Bounds().Min, draw.Over)
+	return capturedFrame{text: text, cursor: cursor, image: img}
+}
+
+// screenRecorder records the screens of the terminal for the outputs drawn
+// from its cells. Identical consecutive screens are merged, like the frames of
+// the videos.
+type screenRecorder struct {
+	runs []screenRun
+}
+
+// screenRun is a screen which lasts for a number of recorded frames.
+type screenRun struct {
+	screen screen
+	frames int
+}
+
+// length returns the number of recorded frames of the run.
+func (r screenRun) length() int { return r.frames }
+
+// withLength returns the run lasting for the number of recorded frames.
+func (r screenRun) withLength(frames int) screenRun {
+	return screenRun{screen: r.screen, frames: frames}
+}
+
+// add records the screen for a frame.
+func (r *screenRecorder) add(s screen) {
+	if n := len(r.runs); n > 0 && r.runs[n-1].screen.equal(s) {
+		r.runs[n-1].frames++
+		return
+	}
+	r.runs = append(r.runs, screenRun{screen: s, frames: 1})
+}
+
+// decodeFrame decodes a PNG frame.
+func decodeFrame(frame []byte) (image.Image, error) {
+	img, err := png.Decode(bytes.NewReader(frame))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode frame: %w", err)
+	}
+	return img, nil
+}
+
+// readFrame reads a PNG frame from a file.
+func readFrame(file string) (image.Image, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read frame: %w", err)
+	}
+	return decodeFrame(b)
+}
+
+// encodeFrame encodes a frame as a PNG.
+func encodeFrame(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("could not encode frame: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// normalizeFrame scales a frame to fit the box of the layout for the given
+// viewport, and centers it on a frame of the dimensions of the layout filled
+// with the background color. Frames which already have the dimensions of the
+// layout are returned as is.
+func normalizeFrame(src image.Image, layout frameLayout, viewport image.Point, background color.Color) image.Image {
+	size := src.Bounds().Size()
+	box := layout.box(viewport)
+	if size == layout.bounds().Size() && box == layout.bounds() {
+		return src
+	}
+
+	// Scale the frame to fit the box, keeping its aspect ratio.
+	scale := min(float64(box.Dx())/float64(size.X), float64(box.Dy())/float64(size.Y))
+	scaled := image.Pt(int(float64(size.X)*scale), int(float64(size.Y)*scale))
+	offset := box.Min.Add(box.Size().Sub(scaled).Div(doublingFactor))
+
+	dst := image.NewRGBA(layout.bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	draw.ApproxBiLinear.Scale(dst, image.Rectangle{Min: offset, Max: offset.Add(scaled)}, src, src.Bounds(), draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func solidFrame(width, height int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+func TestNormalizeFrame(t *testing.T) {
+	red := color.RGBA{0xFF, 0, 0, 0xFF}
+	background := color.RGBA{0, 0, 0xFF, 0xFF}
+	layout := frameLayout{Width: 100, Height: 50, Viewport: image.Pt(100, 50)}
+
+	tests := []struct {
+		name     string
+		width    int
+		height   int
+		viewport image.Point
+		// inside is a point covered by the frame, and outside one filled
+		// with the background, if any.
+		inside  image.Point
+		outside *image.Point
+	}{
+		{
+			name:     "same size",
+			width:    100,
+			height:   50,
+			viewport: image.Pt(100, 50),
+			inside:   image.Pt(0, 0),
+		},
+		{
+			name:     "larger font",
+			width:    200,
+			height:   50,
+			viewport: image.Pt(100, 50),
+			inside:   image.Pt(50, 25),
+			outside:  &image.Point{50, 5},
+		},
+		{
+			name:     "smaller font",
+			width:    90,
+			height:   45,
+			viewport: image.Pt(100, 50),
+			inside:   image.Pt(1, 1),
+		},
+		{
+			name:     "larger padding",
+			width:    80,
+			height:   30,
+			viewport: image.Pt(80, 30),
+			inside:   image.Pt(50, 25),
+			outside:  &image.Point{5, 5},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			img := normalizeFrame(solidFrame(tc.width, tc.height, red), layout, tc.viewport, background)
+			if got := img.Bounds().Size(); got != image.Pt(layout.Width, layout.Height) {
+				t.Fatalf("expected %dx%d frame, got %v", layout.Width, layout.Height, got)
+			}
+			if got := color.RGBAModel.Convert(img.At(tc.inside.X, tc.inside.Y)); got != red {
+				t.Errorf("expected frame at %v, got %v", tc.inside, got)
+			}
+			if tc.outside != nil {
+				if got := color.RGBAModel.Convert(img.At(tc.outside.X, tc.outside.Y)); got != background {
+					t.Errorf("expected background at %v, got %v", *tc.outside, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCapturedFrame(t *testing.T) {
+	red := color.RGBA{0xFF, 0, 0, 0xFF}
+	white := color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	cursor := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(cursor, image.Rect(2, 2, 4, 4), image.NewUniform(white), image.Point{}, draw.Src)
+
+	frame := newCapturedFrame(solidFrame(10, 10, red), cursor)
+	if got := frame.image.RGBAAt(0, 0); got != red {
+		t.Errorf("expected text at (0, 0), got %v", got)
+	}
+	if got := frame.image.RGBAAt(3, 3); got != white {
+		t.Errorf("expected cursor at (3, 3), got %v", got)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	gifimage "image/gif"
+	"math"
+	"os"
+	"slices"
+)
+
+// GIF encoders, set with Set GIFEncoder.
+const (
+	// GIFEncoderFFmpeg encodes GIFs with the palettegen and paletteuse
+	// filters of ffmpeg.
+	GIFEncoderFFmpeg = "ffmpeg"
+	// GIFEncoderNative encodes GIFs in Go, with a palette built from the
+	// colors of the theme and only the changed area of each frame.
+	GIFEncoderNative = "native"
+)
+
+// Dithering of GIFs, set with Set GIFDither. By default, each encoder uses its
+// own: ffmpeg dithers and the native encoder doesn't.
+const (
+	GIFDitherNone           = "none"
+	GIFDitherFloydSteinberg = "floyd-steinberg"
+)
+
+// maxGIFColors is the maximum number of colors of a GIF palette.
+const maxGIFColors = 256
+
+// opaque is the alpha above which a pixel is drawn in a GIF, rather than left
+// transparent, e.g. outside rounded corners.
+const opaque = 0x80
+
+// encodeGIF encodes the runs of frames into a GIF, with a delay for each frame
+// covering the recorded frames of its run.
+func encodeGIF(opts VideoOptions, theme Theme, runs []frameRun, path string) error {
+	styler, err := newFrameStyler(*opts.Style)
+	if err != nil {
+		return err
+	}
+	palette, err := gifPalette(opts, theme, styler, runs)
+	if err != nil {
+		return err
+	}
+	enc := newGIFEncoder(palette, styler.Bounds(), opts.GIFDither == GIFDitherFloydSteinberg)
+
+	// Like ffmpeg, the delays are rounded to hundredths of a second from the
+	// start of the video, so that the rounding errors don't add up. Frames
+	// which are shown for less than a hundredth of a second are skipped.
+	speed := opts.PlaybackSpeed
+	if speed <= 0 {
+		speed = defaultPlaybackSpeed
+	}
+	recorded, shown := 0, 0
+	for _, run := range runs {
+		recorded += run.frames
+		end := int(math.Round(float64(recorded) / float64(opts.Framerate) / speed * 100)) //nolint:mnd
+		if end == shown {
+			continue
+		}
+
+		img, err := run.image()
+		if err != nil {
+			return err
+		}
+		enc.add(styler.Draw(img), end-shown)
+		shown = end
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create %s: %w", path, err)
+	}
+	if err := gifimage.EncodeAll(f, enc.anim); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("could not encode %s: %w", path, err)
+	}
+	return f.Close() //nolint:wrapcheck
+}
+
+// gifPalette returns the palette of a GIF of the frames, of at most MaxColors
+// colors with the transparent color last. The colors of the theme and style
+// which appear in the frames come first, then the most frequent colors of the
+// frames, such as the shades of anti-aliased text.
+func gifPalette(opts VideoOptions, theme Theme, styler *frameStyler, runs []frameRun) (color.Palette, error) {
+	size := min(max(opts.MaxColors, 2), maxGIFColors) //nolint:mnd
+
+	counts := make(map[color.RGBA]int)
+	for _, run := range runs {
+		img, err := run.image()
+		if err != nil {
+			return nil, err
+		}
+		styled := styler.Draw(img)
+		for i := 0; i < len(styled.Pix); i += 4 {
+			p := styled.Pix[i : i+4 : i+4]
+			if p[3] < opaque {
+				continue
+			}
+			counts[color.RGBA{p[0], p[1], p[2], 0xFF}]++
+		}
+	}
+
+	seeds := []string{
+		opts.Style.BackgroundColor, opts.Style.WindowBarColor, opts.Style.MarginFill,
+		theme.Background, theme.Foreground, theme.Cursor,
+		theme.Black, theme.Red, theme.Green, theme.Yellow,
+		theme.Blue, theme.Magenta, theme.Cyan, theme.White,
+		theme.BrightBlack, theme.BrightRed, theme.BrightGreen, theme.BrightYellow,
+		theme.BrightBlue, theme.BrightMagenta, theme.BrightCyan, theme.BrightWhite,
+	}
+	var palette color.Palette
+	seen := make(map[color.RGBA]bool)
+	for _, seed := range seeds {
+		c, err := parseHexColor(seed)
+		if err != nil || seen[c] || counts[c] == 0 || len(palette) == size-1 {
+			continue
+		}
+		seen[c] = true
+		palette = append(palette, c)
+	}
+
+	others := make([]color.RGBA, 0, len(counts))
+	for c := range counts {
+		if !seen[c] {
+			others = append(others, c)
+		}
+	}
+	slices.SortFunc(others, func(a, b color.RGBA) int {
+		if n := cmp.Compare(counts[b], counts[a]); n != 0 {
+			return n
+		}
+		return cmp.Compare(uint32(a.R)<<16|uint32(a.G)<<8|uint32(a.B), uint32(b.R)<<16|uint32(b.G)<<8|uint32(b.B))
+	})
+	for _, c := range others[:min(len(others), size-1-len(palette))] {
+		palette = append(palette, c)
+	}
+
+	return append(palette, color.Transparent), nil
+}
+
+// gifEncoder builds a GIF of frames which only hold the area which changed
+// since the previous frame, with the pixels which didn't change inside it left
+// transparent.
+type gifEncoder struct {
+	anim        *gifimage.GIF
+	palette     color.Palette
+	transparent uint8
+	dither      bool
+	// indices caches the nearest color of the palette for each color.
+	indices map[color.RGBA]uint8
+	// last is the last frame added, in indices of the palette.
+	last *image.Paletted
+}
+
+// newGIFEncoder returns an encoder of frames of the given bounds.
+func newGIFEncoder(palette color.Palette, bounds image.Rectangle, dither bool) *gifEncoder {
+	return &gifEncoder{
+		anim: &gifimage.GIF{
+			Config: image.Config{ColorModel: palette, Width: bounds.Dx(), Height: bounds.Dy()},
+		},
+		palette:     palette,
+		transparent: uint8(len(palette) - 1), //nolint:gosec
+		dither:      dither,
+		indices:     make(map[color.RGBA]uint8),
+	}
+}
+
+// add adds the frame, shown for the delay in hundredths of a second.
+func (e *gifEncoder) add(img *image.RGBA, delay int) {
+	frame := e.quantize(img)
+
+	if e.last == nil {
+		e.append(frame, delay)
+		e.last = frame
+		return
+	}
+
+	width, height := frame.Rect.Dx(), frame.Rect.Dy()
+	minX, minY, maxX, maxY := width, height, -1, -1
+	for y := range height {
+		row := frame.Pix[y*frame.Stride : y*frame.Stride+width]
+		last := e.last.Pix[y*e.last.Stride : y*e.last.Stride+width]
+		for x := range row {
+			if row[x] != last[x] {
+				minX, minY = min(minX, x), min(minY, y)
+				maxX, maxY = max(maxX, x), max(maxY, y)
+			}
+		}
+	}
+
+	// Frames which are identical once quantized extend the previous one.
+	if maxX < 0 {
+		e.anim.Delay[len(e.anim.Delay)-1] += delay
+		return
+	}
+	changed := image.Rect(minX, minY, maxX+1, maxY+1)
+
+	diff := image.NewPaletted(changed, e.palette)
+	for y := changed.Min.Y; y < changed.Max.Y; y++ {
+		for x := changed.Min.X; x < changed.Max.X; x++ {
+			i := frame.PixOffset(x, y)
+			if frame.Pix[i] == e.last.Pix[i] {
+				diff.Pix[diff.PixOffset(x, y)] = e.transparent
+			} else {
+				diff.Pix[diff.PixOffset(x, y)] = frame.Pix[i]
+			}
+		}
+	}
+	e.append(diff, delay)
+	e.last = frame
+}
+
+// append appends a frame drawn over the previous ones.
+func (e *gifEncoder) append(frame *image.Paletted, delay int) {
+	e.anim.Image = append(e.anim.Image, frame)
+	e.anim.Delay = append(e.anim.Delay, delay)
+	e.anim.Disposal = append(e.anim.Disposal, gifimage.DisposalNone)
+}
+
+// quantize returns the frame in colors of the palette, leaving the pixels
+// which aren't opaque transparent.
+func (e *gifEncoder) quantize(img *image.RGBA) *image.Paletted {
+	frame := image.NewPaletted(img.Bounds(), e.palette)
+	if e.dither {
+		draw.FloydSteinberg.Draw(frame, frame.Rect, img, img.Rect.Min)
+	}
+	for i, j := 0, 0; i < len(img.Pix); i, j = i+4, j+1 {
+		p := img.Pix[i : i+4 : i+4]
+		switch {
+		case p[3] < opaque:
+			frame.Pix[j] = e.transparent
+		case !e.dither:
+			frame.Pix[j] = e.index(color.RGBA{p[0], p[1], p[2], 0xFF})
+		}
+	}
+	return frame
+}
+
+// index returns the index of the nearest opaque color of the palette.
+func (e *gifEncoder) index(c color.RGBA) uint8 {
+	if i, ok := e.indices[c]; ok {
+		return i
+	}
+	i := uint8(e.palette[:e.transparent].Index(c)) //nolint:gosec
+	e.indices[c] = i
+	return i
+}
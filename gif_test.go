@@ -0,0 +1,98 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	gifimage "image/gif"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGIFEncoder(t *testing.T) {
+	black := color.RGBA{0, 0, 0, 0xFF}
+	red := color.RGBA{0xFF, 0, 0, 0xFF}
+	palette := color.Palette{black, red, color.Transparent}
+	e := newGIFEncoder(palette, image.Rect(0, 0, 8, 8), false)
+
+	e.add(solidFrame(8, 8, black), 2)
+	changed := solidFrame(8, 8, black)
+	draw.Draw(changed, image.Rect(3, 3, 5, 5), image.NewUniform(red), image.Point{}, draw.Src)
+	changed.Set(4, 4, black)
+	e.add(changed, 3)
+	// A frame which is identical once quantized extends the previous one.
+	e.add(changed, 4)
+
+	if len(e.anim.Image) != 2 {
+		t.Fatalf("expected 2 frames, got %d", len(e.anim.Image))
+	}
+	if got := e.anim.Delay; !reflect.DeepEqual(got, []int{2, 7}) {
+		t.Errorf("expected delays [2 7], got %v", got)
+	}
+	diff := e.anim.Image[1]
+	if diff.Rect != image.Rect(3, 3, 5, 5) {
+		t.Fatalf("expected changed area (3,3)-(5,5), got %v", diff.Rect)
+	}
+	if got := diff.ColorIndexAt(3, 3); got != 1 {
+		t.Errorf("expected changed pixel to be red, got index %d", got)
+	}
+	if got := diff.ColorIndexAt(4, 4); got != e.transparent {
+		t.Errorf("expected unchanged pixel to be transparent, got index %d", got)
+	}
+}
+
+func TestEncodeGIF(t *testing.T) {
+	opts := DefaultVideoOptions()
+	_ = os.RemoveAll(opts.Input)
+	opts.Input = t.TempDir()
+	opts.Framerate = 10
+	opts.PlaybackSpeed = 2
+	opts.MaxColors = 4
+	opts.Style = &StyleOptions{Width: 20, Height: 10, BackgroundColor: DefaultTheme.Background}
+
+	opts.GIFEncoder = GIFEncoderNative
+	opts.Output.GIF = filepath.Join(opts.Input, "out.gif")
+
+	e, err := newVideoEncoder(opts, DefaultTheme, image.Pt(20, 10), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	background, _ := parseHexColor(DefaultTheme.Background)
+	red := color.RGBA{0xFF, 0, 0, 0xFF}
+	for _, c := range []color.RGBA{background, background, red, red, red, red} {
+		if err := e.Encode(solidFrame(20, 10, c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := e.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "out.gif")
+	if err := encodeGIF(opts, DefaultTheme, e.runs, path); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() //nolint:errcheck
+	g, err := gifimage.DecodeAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := g.Delay; !reflect.DeepEqual(got, []int{10, 20}) {
+		t.Errorf("expected delays [10 20], got %v", got)
+	}
+	palette, ok := g.Config.ColorModel.(color.Palette)
+	if !ok || len(palette) > opts.MaxColors {
+		t.Fatalf("expected a palette of at most %d colors, got %v", opts.MaxColors, g.Config.ColorModel)
+	}
+	if got := palette[0]; got != background {
+		t.Errorf("expected theme background first in palette, got %v", got)
+	}
+}
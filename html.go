@@ -0,0 +1,351 @@
+// Package vhs html.go renders the recording into a single HTML file with a
+// small player, drawn from the cells of the terminal so that its text can be
+// copied. The player can be paused, seeked, sped up and jump to the chapters
+// starting at each Type command.
+//
+// Output demo.html
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"image"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// chapter is a chapter of the HTML player, starting at a recorded frame.
+type chapter struct {
+	title string
+	frame int
+}
+
+// rotateChapters moves the chapters starting in the given number of first
+// recorded frames to the end, like rotateRuns.
+func rotateChapters(chapters []chapter, offset, total int) []chapter {
+	if offset <= 0 || total <= 0 {
+		return chapters
+	}
+	rotated := make([]chapter, 0, len(chapters))
+	for _, c := range chapters {
+		frame := (c.frame-1-offset+total)%total + 1
+		rotated = append(rotated, chapter{title: c.title, frame: frame})
+	}
+	slices.SortStableFunc(rotated, func(a, b chapter) int { return a.frame - b.frame })
+	return rotated
+}
+
+// htmlChapter is a chapter of the data of the player.
+type htmlChapter struct {
+	Title string  `json:"title"`
+	Time  float64 `json:"time"`
+}
+
+// htmlData is the data of the player: the time at which each run starts with
+// the index of its screen, the duration and the chapters.
+type htmlData struct {
+	Runs     [][2]float64  `json:"runs"`
+	Duration float64       `json:"duration"`
+	Chapters []htmlChapter `json:"chapters"`
+}
+
+// encodeHTML writes the runs of screens into a self-contained HTML player,
+// styled like the videos.
+func encodeHTML(opts Options, runs []screenRun, chapters []chapter, path string) error {
+	if len(runs) == 0 {
+		return fmt.Errorf("could not create %s: no frames", path)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create %s: %w", path, err)
+	}
+	if err := writeHTML(f, opts, runs, chapters); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("could not write %s: %w", path, err)
+	}
+	return f.Close() //nolint:wrapcheck
+}
+
+// writeHTML writes the HTML player of the runs of screens.
+func writeHTML(w io.Writer, opts Options, runs []screenRun, chapters []chapter) error {
+	page, err := newHTMLPage(opts, runs, chapters)
+	if err != nil {
+		return err
+	}
+	return htmlTemplate.Execute(w, page) //nolint:wrapcheck
+}
+
+// htmlPage is the page of the HTML player, with the dimensions of the output,
+// window, terminal and screens in pixels.
+type htmlPage struct {
+	Size, Origin, Window image.Point
+	Terminal, Offset     image.Point
+	Grid                 image.Point
+	Scale                float64
+	BorderRadius         int
+	BarHeight            int
+	Background           string
+	Theme                Theme
+	FontFamily           template.CSS
+	FontSize             int
+	LineHeight           int
+	Margin               template.CSS
+	Bar                  template.HTML
+	Screens              []template.HTML
+	Data                 htmlData
+}
+
+// newHTMLPage returns the page of the HTML player of the runs of screens.
+func newHTMLPage(opts Options, runs []screenRun, chapters []chapter) (htmlPage, error) {
+	style := *opts.Video.Style
+	layout := newScreenLayout(style, runs[0].screen)
+	page := htmlPage{
+		Size:         layout.size,
+		Origin:       layout.origin,
+		Window:       layout.window,
+		Terminal:     image.Pt(layout.termWidth, layout.termHeight),
+		Offset:       layout.offset,
+		Grid:         layout.grid,
+		Scale:        layout.scale,
+		BorderRadius: style.BorderRadius,
+		Background:   hexColor(themeColor(style.BackgroundColor)),
+		Theme:        opts.Theme,
+		FontFamily:   template.CSS(cssFontFamily(opts.FontFamily)), //nolint:gosec
+		FontSize:     opts.FontSize,
+		LineHeight:   runs[0].screen.size.Y,
+	}
+
+	switch {
+	case style.MarginFill == "":
+	case marginFillIsColor(style.MarginFill):
+		page.Margin = template.CSS(hexColor(themeColor(style.MarginFill))) //nolint:gosec
+	default:
+		uri, err := dataURI(style.MarginFill)
+		if err != nil {
+			return page, fmt.Errorf("unable to read margin file: %w", err)
+		}
+		page.Margin = template.CSS(`center / 100% 100% url("` + uri + `")`) //nolint:gosec
+	}
+
+	if layout.bar {
+		page.BarHeight = style.WindowBarSize
+		var sb strings.Builder
+		_, _ = fmt.Fprintf(&sb, `<svg width="%d" height="%d">`, layout.termWidth, style.WindowBarSize)
+		writeSVGWindowBar(&sb, layout.termWidth, style)
+		sb.WriteString("</svg>")
+		page.Bar = template.HTML(sb.String()) //nolint:gosec
+	}
+
+	screens, timeline := drawScreens(runs, func(s screen) string { return htmlScreen(s, opts.Theme) })
+	for _, s := range screens {
+		page.Screens = append(page.Screens, template.HTML(s)) //nolint:gosec
+	}
+
+	starts, duration := runTimes(opts.Video, runs)
+	page.Data = htmlData{Duration: duration, Chapters: []htmlChapter{}}
+	for i, start := range starts {
+		page.Data.Runs = append(page.Data.Runs, [2]float64{start, float64(timeline[i])})
+	}
+	speed := opts.Video.PlaybackSpeed
+	if speed <= 0 {
+		speed = defaultPlaybackSpeed
+	}
+	for _, c := range chapters {
+		page.Data.Chapters = append(page.Data.Chapters, htmlChapter{
+			Title: c.title,
+			Time:  float64(c.frame-1) / float64(opts.Video.Framerate) / speed,
+		})
+	}
+	return page, nil
+}
+
+// htmlScreen returns the HTML of the cells of the screen: a line of text per
+// row, in spans of cells of the same attributes, with the spaces at the end of
+// the rows trimmed so that copied text doesn't end with them.
+func htmlScreen(s screen, theme Theme) string {
+	background := themeColor(theme.Background)
+	var sb strings.Builder
+	for y, row := range s.cells {
+		row = append([]cell(nil), row...)
+		cursor := -1
+		if s.cursorVisible && y == s.cursor.Y && s.cursor.X >= 0 && s.cursor.X < len(row) {
+			cursor = s.cursor.X
+			row[cursor].FG = themeColor(theme.CursorAccent)
+			row[cursor].BG = themeColor(theme.Cursor)
+		}
+
+		end := len(row)
+		for end > 0 && end-1 != cursor && row[end-1].Char <= ' ' && sameColor(row[end-1].BG, background) {
+			end--
+		}
+		for x := 0; x < end; {
+			next := x + 1
+			for next < end && next != cursor && x != cursor && sameText(row[next], row[x]) && sameColor(row[next].BG, row[x].BG) {
+				next++
+			}
+
+			var text strings.Builder
+			for _, c := range row[x:next] {
+				if c.Char < ' ' {
+					text.WriteRune(' ')
+				} else {
+					text.WriteRune(c.Char)
+				}
+			}
+			styles := []string{"color:" + svgColor(row[x].FG)}
+			if !sameColor(row[x].BG, background) {
+				styles = append(styles, "background:"+svgColor(row[x].BG))
+			}
+			if row[x].Bold {
+				styles = append(styles, "font-weight:bold")
+			}
+			if row[x].Underline {
+				styles = append(styles, "text-decoration:underline")
+			}
+			_, _ = fmt.Fprintf(&sb, `<span style="%s">%s</span>`, strings.Join(styles, ";"), template.HTMLEscapeString(text.String()))
+			x = next
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// htmlTemplate is the template of the HTML player.
+var htmlTemplate = template.Must(template.New("player").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>VHS</title>
+<style>
+body { margin: 0; padding: 16px; font-family: system-ui, sans-serif; background: {{.Theme.Background}}; color: {{.Theme.Foreground}}; }
+.player { display: inline-flex; flex-direction: column; gap: 8px; }
+.output { position: relative; width: {{.Size.X}}px; height: {{.Size.Y}}px;{{if .Margin}} background: {{.Margin}};{{end}} }
+.window { position: absolute; left: {{.Origin.X}}px; top: {{.Origin.Y}}px; width: {{.Window.X}}px; height: {{.Window.Y}}px; border-radius: {{.BorderRadius}}px; overflow: hidden; }
+.bar { display: block; height: {{.BarHeight}}px; }
+.terminal { position: relative; width: {{.Terminal.X}}px; height: {{.Terminal.Y}}px; background: {{.Background}}; overflow: hidden; }
+.screens { position: absolute; left: {{.Offset.X}}px; top: {{.Offset.Y}}px; width: {{.Grid.X}}px; height: {{.Grid.Y}}px; transform: scale({{.Scale}}); transform-origin: 0 0; background: {{.Theme.Background}}; overflow: hidden; }
+.screens pre { margin: 0; font-family: {{.FontFamily}}; font-size: {{.FontSize}}px; line-height: {{.LineHeight}}px; color: {{.Theme.Foreground}}; }
+.controls { display: flex; align-items: center; gap: 8px; font-size: 14px; }
+.controls button, .controls select { font: inherit; }
+.timeline { position: relative; flex: 1; }
+.timeline input { width: 100%; margin: 0; }
+.marker { position: absolute; top: -6px; width: 2px; height: 6px; background: {{.Theme.Cursor}}; }
+.time { font-variant-numeric: tabular-nums; }
+.chapters { display: flex; flex-wrap: wrap; gap: 4px; max-width: {{.Size.X}}px; padding: 0; margin: 0; list-style: none; }
+.chapters button { max-width: 16em; overflow: hidden; text-overflow: ellipsis; white-space: nowrap; font: 12px {{.FontFamily}}; }
+.chapters button.current { font-weight: bold; }
+</style>
+</head>
+<body>
+<div class="player">
+<div class="output">
+<div class="window">
+{{- if .Bar}}<div class="bar">{{.Bar}}</div>{{end -}}
+<div class="terminal">
+<div class="screens">
+{{range .Screens}}<pre hidden>{{.}}</pre>
+{{end -}}
+</div>
+</div>
+</div>
+</div>
+<div class="controls">
+<button id="play" type="button" aria-label="Pause">Pause</button>
+<div class="timeline"><input id="seek" type="range" min="0" step="0.01" aria-label="Seek"></div>
+<span id="time" class="time"></span>
+<select id="speed" aria-label="Speed">
+<option value="0.5">0.5x</option>
+<option value="1" selected>1x</option>
+<option value="1.5">1.5x</option>
+<option value="2">2x</option>
+</select>
+</div>
+<ul id="chapters" class="chapters"></ul>
+</div>
+<script>
+(() => {
+	const data = {{.Data}};
+	const screens = document.querySelectorAll(".screens pre");
+	const play = document.getElementById("play");
+	const seek = document.getElementById("seek");
+	const time = document.getElementById("time");
+	const speed = document.getElementById("speed");
+	const list = document.getElementById("chapters");
+	const format = (t) => Math.floor(t / 60) + ":" + String(Math.floor(t % 60)).padStart(2, "0");
+
+	let now = 0, playing = true, last = performance.now(), shown = -1;
+	seek.max = data.duration;
+
+	const chapters = data.chapters.map((chapter) => {
+		const marker = document.createElement("span");
+		marker.className = "marker";
+		marker.style.left = (data.duration ? chapter.time / data.duration * 100 : 0) + "%";
+		marker.title = chapter.title;
+		seek.parentElement.appendChild(marker);
+
+		const item = document.createElement("li");
+		const button = document.createElement("button");
+		button.type = "button";
+		button.textContent = chapter.title;
+		button.title = chapter.title;
+		button.onclick = () => show(chapter.time);
+		item.appendChild(button);
+		list.appendChild(item);
+		return button;
+	});
+
+	// show shows the screen at the time in seconds.
+	const show = (t) => {
+		now = t;
+		let run = 0;
+		while (run + 1 < data.runs.length && data.runs[run + 1][0] <= t) run++;
+		const screen = data.runs[run][1];
+		if (screen !== shown) {
+			if (shown >= 0) screens[shown].hidden = true;
+			screens[screen].hidden = false;
+			shown = screen;
+		}
+		chapters.forEach((button, i) => {
+			const next = data.chapters[i + 1];
+			button.classList.toggle("current", data.chapters[i].time <= t && (!next || t < next.time));
+		});
+		seek.value = t;
+		time.textContent = format(t) + " / " + format(data.duration);
+	};
+
+	const setPlaying = (p) => {
+		playing = p;
+		play.textContent = p ? "Pause" : "Play";
+		play.setAttribute("aria-label", play.textContent);
+		last = performance.now();
+	};
+
+	const tick = (t) => {
+		if (playing && data.duration > 0) {
+			show((now + (t - last) / 1000 * Number(speed.value)) % data.duration);
+		}
+		last = t;
+		requestAnimationFrame(tick);
+	};
+
+	play.onclick = () => setPlaying(!playing);
+	seek.oninput = () => show(Number(seek.value));
+	// Pause to let the text be selected and copied.
+	document.querySelector(".screens").addEventListener("mousedown", () => setPlaying(false));
+	document.addEventListener("keydown", (e) => {
+		if (e.key === " " && e.target === document.body) {
+			e.preventDefault();
+			setPlaying(!playing);
+		}
+	});
+
+	show(0);
+	requestAnimationFrame(tick);
+})();
+</script>
+</body>
+</html>
+`))
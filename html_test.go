@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRotateChapters(t *testing.T) {
+	chapters := []chapter{{title: "a", frame: 1}, {title: "b", frame: 5}, {title: "c", frame: 8}}
+	rotated := rotateChapters(chapters, 4, 10)
+	want := []chapter{{title: "b", frame: 1}, {title: "c", frame: 4}, {title: "a", frame: 7}}
+	if len(rotated) != len(want) {
+		t.Fatalf("expected %+v, got %+v", want, rotated)
+	}
+	for i := range want {
+		if rotated[i] != want[i] {
+			t.Fatalf("expected %+v, got %+v", want, rotated)
+		}
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	opts := DefaultVHSOptions()
+	opts.Video.Framerate = 10
+
+	runs := []screenRun{
+		{screen: textScreen("$"), frames: 5},
+		{screen: textScreen("<b>"), frames: 10},
+		{screen: textScreen("$"), frames: 5},
+	}
+	chapters := []chapter{{title: "echo <b>", frame: 6}}
+
+	var sb strings.Builder
+	if err := writeHTML(&sb, opts, runs, chapters); err != nil {
+		t.Fatal(err)
+	}
+	out := sb.String()
+
+	for _, want := range []string{
+		"<!DOCTYPE html>",
+		// The cursor is drawn over the first cell.
+		`<span style="color:` + hexColor(themeColor(DefaultTheme.Background)) + `;background:` + hexColor(themeColor(DefaultTheme.Cursor)) + `">&lt;</span>`,
+		"b&gt;",
+		// The screen which comes back is shown again.
+		`"runs":[[0,0],[0.5,1],[1.5,0]]`,
+		`"duration":2`,
+		`"chapters":[{"title":"echo \u003cb\u003e","time":0.5}]`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected HTML to contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "<b>") {
+		t.Errorf("expected the text to be escaped:\n%s", out)
+	}
+}
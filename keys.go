@@ -12,6 +12,9 @@
 package main
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/go-rod/rod/lib/input"
 )
 
@@ -129,3 +132,75 @@ var keymap = map[rune]input.Key{
 	'→':    input.ArrowRight,
 	'↓':    input.ArrowDown,
 }
+
+// keySequences maps the codes of the special keys to the sequences a terminal
+// sends to the shell when they are pressed.
+var keySequences = map[string]string{
+	"Enter":      "\r",
+	"Tab":        "\t",
+	"Backspace":  "\x7f",
+	"Escape":     "\x1b",
+	"ArrowUp":    "\x1b[A",
+	"ArrowDown":  "\x1b[B",
+	"ArrowRight": "\x1b[C",
+	"ArrowLeft":  "\x1b[D",
+	"PageUp":     "\x1b[5~",
+	"PageDown":   "\x1b[6~",
+	"Delete":     "\x1b[3~",
+	"Insert":     "\x1b[2~",
+}
+
+// keySequence returns the sequence a terminal sends to the shell when the key
+// is pressed while holding down the modifiers.
+func keySequence(key input.Key, modifiers ...input.Key) (string, error) {
+	var ctrl, alt, shifted bool
+	for _, m := range modifiers {
+		switch m.Modifier() {
+		case input.ModifierControl:
+			ctrl = true
+		case input.ModifierAlt:
+			alt = true
+		case input.ModifierShift:
+			shifted = true
+		}
+	}
+
+	info := key.Info()
+	seq, ok := keySequences[info.Code]
+	switch {
+	case ok:
+		if shifted && info.Code == "Tab" {
+			seq = "\x1b[Z"
+		}
+	case key.Printable():
+		seq = info.Key
+		if k, ok := key.Shift(); ok && shifted {
+			seq = k.Info().Key
+		}
+		if ctrl {
+			seq = controlSequence(seq)
+		}
+	default:
+		return "", fmt.Errorf("unsupported key %s", info.Key)
+	}
+
+	if alt {
+		seq = "\x1b" + seq
+	}
+	return seq, nil
+}
+
+// controlSequence returns the control character of a printable character, as
+// sent by a terminal with the control key held down, e.g. \x03 for c.
+//
+//nolint:mnd
+func controlSequence(s string) string {
+	if s == " " {
+		return "\x00"
+	}
+	c := strings.ToUpper(s)[0]
+	if c < '@' || c > '_' {
+		return s
+	}
+	return string(rune(c & 0x1f))
+}
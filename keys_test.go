@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-rod/rod/lib/input"
+)
+
+func TestKeySequence(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       input.Key
+		modifiers []input.Key
+		want      string
+	}{
+		{"enter", input.Enter, nil, "\r"},
+		{"up", input.ArrowUp, nil, "\x1b[A"},
+		{"ctrl+c", input.KeyC, []input.Key{input.ControlLeft}, "\x03"},
+		{"alt+b", input.KeyB, []input.Key{input.AltLeft}, "\x1bb"},
+		{"shift+tab", input.Tab, []input.Key{input.ShiftLeft}, "\x1b[Z"},
+		{"shift+a", input.KeyA, []input.Key{input.ShiftLeft}, "A"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := keySequence(tc.key, tc.modifiers...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
@@ -32,6 +32,7 @@ func (l *Lexer) readChar() {
 func (l *Lexer) NextToken() token.Token {
 	l.skipWhitespace()
 
+	start := l.pos
 	tok := token.Token{Line: l.line, Column: l.column}
 
 	switch l.ch {
@@ -99,6 +100,7 @@ func (l *Lexer) NextToken() token.Token {
 			l.readChar()
 		}
 	}
+	tok.Raw = l.input[min(start, len(l.input)):min(l.pos, len(l.input))]
 	return tok
 }
 
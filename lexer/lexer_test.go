@@ -34,7 +34,8 @@ Sleep 2
 Wait+Screen@1m /foobar/
 Wait+Screen@1m /foo\/bar/
 Wait+Screen@1m /foo\\/
-Wait+Screen@1m /foo\\\/bar/`
+Wait+Screen@1m /foo\\\/bar/
+Let name "${value}"`
 
 	tests := []struct {
 		expectedType    token.Type
@@ -128,6 +129,9 @@ Wait+Screen@1m /foo\\\/bar/`
 		{token.NUMBER, "1"},
 		{token.MINUTES, "m"},
 		{token.REGEX, "foo\\\\\\/bar"},
+		{token.LET, "Let"},
+		{token.STRING, "name"},
+		{token.STRING, "${value}"},
 	}
 
 	l := New(input)
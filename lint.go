@@ -0,0 +1,486 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/vhs/lexer"
+	"github.com/charmbracelet/vhs/parser"
+	"github.com/charmbracelet/vhs/token"
+	"github.com/spf13/cobra"
+)
+
+var (
+	lintDisable []string
+	lintJSON    bool
+
+	lintCmd = &cobra.Command{
+		Use:   "lint <file>...",
+		Short: "Check tape files for problems which would break the recording",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, id := range lintDisable {
+				if !slices.ContainsFunc(LintRules, func(r LintRule) bool { return r.ID == id }) {
+					return fmt.Errorf("unknown lint rule %q", id)
+				}
+			}
+
+			diagnostics := []LintDiagnostic{}
+			for _, file := range args {
+				b, err := os.ReadFile(file)
+				if err != nil {
+					return fmt.Errorf("failed to read %s: %w", file, err)
+				}
+				for _, d := range Lint(string(b), lintDisable, parser.WithFile(file)) {
+					if d.File == "" {
+						d.File = file
+					}
+					diagnostics = append(diagnostics, d)
+				}
+			}
+
+			if lintJSON {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(diagnostics); err != nil {
+					return fmt.Errorf("failed to encode diagnostics: %w", err)
+				}
+			} else {
+				for _, d := range diagnostics {
+					log.Println(d)
+				}
+			}
+
+			if len(diagnostics) > 0 {
+				return fmt.Errorf("%d problem(s) found", len(diagnostics))
+			}
+			return nil
+		},
+	}
+)
+
+// Lint severities.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// LintDiagnostic is a problem found by a lint rule.
+type LintDiagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// String returns the diagnostic as file:line:column: message (rule).
+func (d LintDiagnostic) String() string {
+	style := GrayStyle
+	if d.Severity == SeverityError {
+		style = ErrorStyle
+	}
+	return fmt.Sprintf("%s:%d:%d: %s %s", d.File, d.Line, d.Column, d.Message, style.Render("("+d.Rule+")"))
+}
+
+// LintRule is a check run by vhs lint.
+type LintRule struct {
+	// ID identifies the rule in diagnostics, --disable flags and
+	// vhs:ignore comments.
+	ID string
+	// Description describes what the rule checks.
+	Description string
+	// Severity is the severity of the diagnostics of the rule.
+	Severity string
+	// Check returns the problems found in the tape.
+	Check func(t *LintTape) []LintDiagnostic
+}
+
+// LintRules are the rules checked by vhs lint. New rules are added by
+// appending to the list.
+var LintRules = []LintRule{
+	{
+		ID:          "set-after-action",
+		Description: "Set commands after the first command are ignored",
+		Severity:    SeverityWarning,
+		Check:       lintSetAfterAction,
+	},
+	{
+		ID:          "output-extension",
+		Description: "Output paths must have a supported extension",
+		Severity:    SeverityWarning,
+		Check:       lintOutputExtension,
+	},
+	{
+		ID:          "screenshot-hidden",
+		Description: "Screenshot is not taken while the recording is hidden",
+		Severity:    SeverityWarning,
+		Check:       lintScreenshotHidden,
+	},
+	{
+		ID:          "wait-never-matches",
+		Description: "Wait patterns must be able to match the terminal",
+		Severity:    SeverityWarning,
+		Check:       lintWaitNeverMatches,
+	},
+	{
+		ID:          "require-missing",
+		Description: "Required programs must be on the PATH",
+		Severity:    SeverityError,
+		Check:       lintRequireMissing,
+	},
+	{
+		ID:          "dimensions",
+		Description: "Dimensions must fit the padding, margins and window bar",
+		Severity:    SeverityError,
+		Check:       lintDimensions,
+	},
+}
+
+// syntaxRule is the rule of the diagnostics for parser errors, which can't be
+// disabled.
+const syntaxRule = "syntax"
+
+// ignoreDirective is the comment which disables rules for the next command,
+// or for the command on the same line.
+//
+//	# vhs:ignore [rule...]
+const ignoreDirective = "vhs:ignore"
+
+// outputExtensions are the extensions of the supported outputs.
+var outputExtensions = []string{".gif", ".mp4", ".webm", ".webp", ".apng", ".svg", ".cast", ".html", ".png", ".test", ".ascii", ".txt", ".ansi"}
+
+// LintTape is a tape being linted.
+type LintTape struct {
+	// Commands are the commands of the tape as they are evaluated: with their
+	// variables interpolated, the macros and Repeat blocks expanded, the
+	// sourced tapes included and the bodies of the If blocks following them.
+	Commands []parser.Command
+	// Lines are the lines of the tape, with its comments.
+	Lines []tapeLine
+	// start is the index of the first command which isn't a setting, before
+	// which settings take effect.
+	start int
+}
+
+// Lint checks a tape against the lint rules, except the disabled ones, and
+// returns the problems found sorted by position. Syntax errors are returned
+// as diagnostics and no other rule is checked.
+func Lint(tape string, disabled []string, opts ...parser.Option) []LintDiagnostic {
+	p := parser.New(lexer.New(tape), opts...)
+	cmds := p.Parse()
+	if errs := p.Errors(); len(errs) > 0 {
+		diagnostics := make([]LintDiagnostic, 0, len(errs))
+		for _, err := range errs {
+			diagnostics = append(diagnostics, LintDiagnostic{
+				File:     err.File,
+				Line:     err.Token.Line,
+				Column:   err.Token.Column,
+				Rule:     syntaxRule,
+				Severity: SeverityError,
+				Message:  err.Msg,
+			})
+		}
+		return diagnostics
+	}
+
+	t := newLintTape(cmds, splitLines(tape))
+	ignored := t.ignored()
+
+	var diagnostics []LintDiagnostic
+	for _, rule := range LintRules {
+		if slices.Contains(disabled, rule.ID) {
+			continue
+		}
+		for _, d := range rule.Check(t) {
+			if ids, ok := ignored[d.Line]; ok && d.File == "" && (len(ids) == 0 || slices.Contains(ids, rule.ID)) {
+				continue
+			}
+			d.Rule = rule.ID
+			d.Severity = rule.Severity
+			// The commands of a macro or a Repeat block are reported once.
+			if !slices.Contains(diagnostics, d) {
+				diagnostics = append(diagnostics, d)
+			}
+		}
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		if diagnostics[i].File != diagnostics[j].File {
+			return diagnostics[i].File < diagnostics[j].File
+		}
+		if diagnostics[i].Line != diagnostics[j].Line {
+			return diagnostics[i].Line < diagnostics[j].Line
+		}
+		return diagnostics[i].Column < diagnostics[j].Column
+	})
+	return diagnostics
+}
+
+// newLintTape returns the lint tape of the parsed commands and the lines of a
+// tape.
+func newLintTape(cmds []parser.Command, lines []tapeLine) *LintTape {
+	t := &LintTape{Commands: flattenCommands(cmds), Lines: lines}
+	t.start = slices.IndexFunc(t.Commands, func(c parser.Command) bool {
+		return c.Type != token.SET && c.Type != token.OUTPUT && c.Type != token.REQUIRE
+	})
+	if t.start < 0 {
+		t.start = len(t.Commands)
+	}
+	return t
+}
+
+// flattenCommands returns the commands with the bodies of the If blocks
+// following them.
+func flattenCommands(cmds []parser.Command) []parser.Command {
+	var flat []parser.Command
+	for _, c := range cmds {
+		flat = append(flat, c)
+		flat = append(flat, flattenCommands(c.Body)...)
+		flat = append(flat, flattenCommands(c.Else)...)
+	}
+	return flat
+}
+
+// ignored returns the rules ignored on each line by vhs:ignore comments. An
+// empty list ignores every rule.
+func (t *LintTape) ignored() map[int][]string {
+	ignored := map[int][]string{}
+	var pending []string
+	var isPending bool
+	for _, l := range t.Lines {
+		if l.tokens[0].Type == token.COMMENT {
+			if ids, ok := parseIgnore(l.tokens[0].Literal); ok {
+				pending = append(pending, ids...)
+				isPending = true
+			}
+			continue
+		}
+
+		line := l.tokens[0].Line
+		if isPending {
+			ignored[line] = pending
+		}
+		if ids, ok := parseIgnore(l.comment); ok {
+			ignored[line] = append(ignored[line], ids...)
+		}
+		pending, isPending = nil, false
+	}
+	return ignored
+}
+
+// parseIgnore parses the rules of a vhs:ignore comment.
+func parseIgnore(comment string) ([]string, bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(comment), ignoreDirective)
+	if !ok || (rest != "" && rest[0] != ' ' && rest[0] != '\t') {
+		return nil, false
+	}
+	return strings.FieldsFunc(rest, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == ','
+	}), true
+}
+
+// settings returns the settings which take effect, i.e. the ones before the
+// first command.
+func (t *LintTape) settings() []parser.Command {
+	var settings []parser.Command
+	for _, c := range t.Commands[:t.start] {
+		if c.Type == token.SET {
+			settings = append(settings, c)
+		}
+	}
+	return settings
+}
+
+// prompt returns the prompt of the shell set in the tape, if it is known.
+func (t *LintTape) prompt() (string, bool) {
+	name := defaultShell
+	for _, c := range t.settings() {
+		if c.Options == "Shell" {
+			name = c.Args
+		}
+	}
+	shell, ok := Shells[name]
+	return shell.Prompt, ok && shell.Prompt != ""
+}
+
+// diagnostic returns a diagnostic at the given token of a command.
+func diagnostic(c parser.Command, t token.Token, msg string) LintDiagnostic {
+	return LintDiagnostic{File: c.Source, Line: t.Line, Column: t.Column, Message: msg}
+}
+
+// argument returns the token of the argument of the command with the given
+// type, or the start of the command if it has none.
+func argument(c parser.Command, typ token.Type) token.Token {
+	for _, t := range c.Tokens[min(1, len(c.Tokens)):] {
+		if t.Type == typ {
+			return t
+		}
+	}
+	return c.Start
+}
+
+// lintSetAfterAction reports the settings which Evaluate ignores because they
+// come after the first command.
+func lintSetAfterAction(t *LintTape) []LintDiagnostic {
+	var diagnostics []LintDiagnostic
+	for _, c := range t.Commands[t.start:] {
+		if c.Type != token.SET || slices.Contains(runtimeSettings, token.Keywords[c.Options]) {
+			continue
+		}
+		diagnostics = append(diagnostics, diagnostic(c, c.Start,
+			fmt.Sprintf("Set %s is ignored after the first command, move it to the top of the tape", c.Options)))
+	}
+	return diagnostics
+}
+
+// lintOutputExtension reports the outputs which would be written as a GIF
+// because their extension isn't supported.
+func lintOutputExtension(t *LintTape) []LintDiagnostic {
+	var diagnostics []LintDiagnostic
+	for _, c := range t.Commands {
+		if c.Type != token.OUTPUT {
+			continue
+		}
+		ext := filepath.Ext(c.Args)
+		if ext == "" || slices.Contains(outputExtensions, ext) {
+			continue
+		}
+		diagnostics = append(diagnostics, diagnostic(c, argument(c, token.STRING),
+			fmt.Sprintf("Unsupported output extension %s, a GIF would be written", ext)))
+	}
+	return diagnostics
+}
+
+// lintScreenshotHidden reports the screenshots taken between Hide and Show,
+// which capture the first frame after Show instead.
+func lintScreenshotHidden(t *LintTape) []LintDiagnostic {
+	var diagnostics []LintDiagnostic
+	hidden := false
+	for _, c := range t.Commands {
+		switch c.Type {
+		case token.HIDE:
+			hidden = true
+		case token.SHOW:
+			hidden = false
+		case token.SCREENSHOT:
+			if hidden {
+				diagnostics = append(diagnostics, diagnostic(c, c.Start,
+					"Screenshot while Hide is active captures the first frame after Show"))
+			}
+		}
+	}
+	return diagnostics
+}
+
+// lintWaitNeverMatches reports the Wait commands which can never match, i.e.
+// waiting for a WaitPattern which doesn't match the prompt of the shell, or
+// for a pattern spanning several lines on the current line.
+func lintWaitNeverMatches(t *LintTape) []LintDiagnostic {
+	pattern := defaultWaitPattern
+	var patternSetting *parser.Command
+	for _, c := range t.settings() {
+		if c.Options != "WaitPattern" {
+			continue
+		}
+		rx, err := regexp.Compile(c.Args)
+		if err != nil {
+			continue
+		}
+		pattern, patternSetting = rx, &c
+	}
+	prompt, knownPrompt := t.prompt()
+
+	var diagnostics []LintDiagnostic
+	for _, c := range t.Commands {
+		if c.Type != token.WAIT {
+			continue
+		}
+
+		scope, rx, ok := strings.Cut(c.Args, " ")
+		if !ok {
+			if knownPrompt && !pattern.MatchString(prompt) {
+				msg := fmt.Sprintf("WaitPattern /%s/ never matches the prompt", pattern)
+				if patternSetting != nil && patternSetting.Source == c.Source {
+					msg += fmt.Sprintf(" (set on line %d)", patternSetting.Start.Line)
+				}
+				diagnostics = append(diagnostics, diagnostic(c, c.Start, msg))
+			}
+			continue
+		}
+
+		if scope == "Line" && strings.Contains(rx, `\n`) {
+			diagnostics = append(diagnostics, diagnostic(c, argument(c, token.REGEX),
+				"Pattern matches a newline which is never part of the current line, use Wait+Screen"))
+		}
+	}
+	return diagnostics
+}
+
+// lintRequireMissing reports the required programs which aren't on the PATH.
+func lintRequireMissing(t *LintTape) []LintDiagnostic {
+	var diagnostics []LintDiagnostic
+	for _, c := range t.Commands {
+		if c.Type != token.REQUIRE {
+			continue
+		}
+		if _, err := exec.LookPath(c.Args); err != nil {
+			diagnostics = append(diagnostics, diagnostic(c, argument(c, token.STRING),
+				fmt.Sprintf("%s is not installed or not on the PATH", c.Args)))
+		}
+	}
+	return diagnostics
+}
+
+// lintDimensions reports a terminal too small for its padding, margins and
+// window bar, which Evaluate refuses to record.
+func lintDimensions(t *LintTape) []LintDiagnostic {
+	style := DefaultStyleOptions()
+	last := parser.Command{Start: token.Token{Line: 1, Column: 1}}
+	for _, c := range t.settings() {
+		n, _ := strconv.Atoi(c.Args)
+		switch token.Keywords[c.Options] {
+		case token.WIDTH:
+			style.Width = n
+		case token.HEIGHT:
+			style.Height = n
+		case token.PADDING:
+			style.Padding = n
+		case token.MARGIN:
+			style.Margin = n
+		case token.WINDOW_BAR:
+			style.WindowBar = c.Args
+		case token.WINDOW_BAR_SIZE:
+			style.WindowBarSize = n
+		default:
+			continue
+		}
+		last = c
+	}
+
+	minWidth, minHeight := style.MinDimensions()
+	if style.Width >= minWidth && style.Height >= minHeight {
+		return nil
+	}
+	return []LintDiagnostic{diagnostic(last, last.Start,
+		fmt.Sprintf("Dimensions %d x %d must be at least %d x %d", style.Width, style.Height, minWidth, minHeight))}
+}
+
+// lintRuleIDs returns the IDs of the lint rules.
+func lintRuleIDs() []string {
+	ids := make([]string, 0, len(LintRules))
+	for _, r := range LintRules {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
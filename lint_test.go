@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/charmbracelet/vhs/parser"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name     string
+		tape     string
+		disabled []string
+		want     []string
+	}{
+		{
+			name: "valid tape",
+			tape: `Output demo.gif
+Require sh
+Set Width 1200
+Type "ls"
+Set TypingSpeed 10ms
+Enter
+Wait`,
+			want: nil,
+		},
+		{
+			name: "syntax",
+			tape: `Type`,
+			want: []string{"1:5 syntax"},
+		},
+		{
+			name: "set after action",
+			tape: `Set Width 1200
+Env FOO "bar"
+Set Height 600`,
+			want: []string{"3:1 set-after-action"},
+		},
+		{
+			name: "runtime settings",
+			tape: `Type "ls"
+Set FontSize 40
+Set Theme "Dracula"
+Set Padding 60`,
+			want: nil,
+		},
+		{
+			name: "output extension",
+			tape: `Output demo.mov
+Output frames/
+Output golden.txt`,
+			want: []string{"1:8 output-extension"},
+		},
+		{
+			name: "screenshot hidden",
+			tape: `Hide
+Screenshot hidden.png
+Show
+Screenshot shown.png`,
+			want: []string{"2:1 screenshot-hidden"},
+		},
+		{
+			name: "wait never matches",
+			tape: `Set WaitPattern /\$$/
+Wait
+Wait+Screen /a\nb/
+Wait /a\nb/`,
+			want: []string{"2:1 wait-never-matches", "4:6 wait-never-matches"},
+		},
+		{
+			name: "require missing",
+			tape: `Require sh
+Require vhs-lint-missing-program`,
+			want: []string{"2:9 require-missing"},
+		},
+		{
+			name: "interpolated variables",
+			tape: `Let bin "sh"
+Let ext "mov"
+Require "${bin}"
+Output "demo.${ext}"`,
+			want: []string{"4:8 output-extension"},
+		},
+		{
+			name: "expanded blocks",
+			tape: `Define hidden
+Set Height 600
+Screenshot hidden.png
+End
+Type "ls"
+Hide
+Call hidden
+Repeat 2
+Call hidden
+End
+Show`,
+			want: []string{"2:1 set-after-action", "3:1 screenshot-hidden"},
+		},
+		{
+			name: "dimensions",
+			tape: `Set Height 100
+Set Padding 40
+Set WindowBar Colorful`,
+			want: []string{"3:1 dimensions"},
+		},
+		{
+			name: "ignore comments",
+			tape: `# vhs:ignore output-extension
+Output demo.mov
+Output demo.avi # vhs:ignore
+# vhs:ignore dimensions
+Output demo.mkv`,
+			want: []string{"5:8 output-extension"},
+		},
+		{
+			name:     "disabled rules",
+			tape:     `Output demo.mov`,
+			disabled: []string{"output-extension"},
+			want:     nil,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, d := range Lint(tc.tape, tc.disabled) {
+				got = append(got, fmt.Sprintf("%d:%d %s", d.Line, d.Column, d.Rule))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestLintSource(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sub.tape"), []byte("Type \"ls\"\nRequire \"${name}\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, d := range Lint(`Source sub.tape name=vhs-missing-binary`, nil, parser.WithFile(filepath.Join(dir, "demo.tape"))) {
+		got = append(got, fmt.Sprintf("%s:%d:%d %s", d.File, d.Line, d.Column, d.Rule))
+	}
+	if want := []string{"sub.tape:2:9 require-missing"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	"github.com/charmbracelet/vhs/lexer"
+	"github.com/charmbracelet/vhs/parser"
+	"github.com/charmbracelet/vhs/token"
+	"github.com/spf13/cobra"
+)
+
+var lspCmd = &cobra.Command{
+	Use:   "lsp",
+	Short: "Start a language server for tape files over stdio",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return ServeLSP(cmd.InOrStdin(), cmd.OutOrStdout())
+	},
+}
+
+// LSP constants, see the Language Server Protocol specification.
+const (
+	lspMethodNotFound = -32601
+	lspInvalidParams  = -32602
+
+	lspSyncFull = 1
+
+	lspSeverityError = 1
+
+	lspCompletionProperty = 10
+	lspCompletionValue    = 12
+	lspCompletionKeyword  = 14
+	lspCompletionFile     = 17
+)
+
+// lspMessage is a JSON-RPC request or notification received from the client.
+type lspMessage struct {
+	ID     *json.RawMessage `json:"id,omitempty"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params,omitempty"`
+}
+
+// lspResponse is a JSON-RPC response sent to the client.
+type lspResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  any              `json:"result"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+// lspNotification is a JSON-RPC notification sent to the client.
+type lspNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspTextDocument struct {
+	URI  string `json:"uri"`
+	Text string `json:"text,omitempty"`
+}
+
+type lspDocumentParams struct {
+	TextDocument   lspTextDocument `json:"textDocument"`
+	Position       lspPosition     `json:"position"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Code     string   `json:"code,omitempty"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspCompletionItem struct {
+	Label      string `json:"label"`
+	Kind       int    `json:"kind"`
+	Detail     string `json:"detail,omitempty"`
+	InsertText string `json:"insertText,omitempty"`
+}
+
+type lspMarkupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type lspHover struct {
+	Contents lspMarkupContent `json:"contents"`
+	Range    lspRange         `json:"range"`
+}
+
+// lspServer is a language server for tape files.
+type lspServer struct {
+	in   *bufio.Reader
+	out  io.Writer
+	mu   sync.Mutex
+	docs map[string]string
+}
+
+// ServeLSP serves the Language Server Protocol over the given reader and
+// writer until the client exits.
+func ServeLSP(r io.Reader, w io.Writer) error {
+	s := &lspServer{
+		in:   bufio.NewReader(r),
+		out:  w,
+		docs: map[string]string{},
+	}
+
+	for {
+		b, err := s.read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var msg lspMessage
+		if err := json.Unmarshal(b, &msg); err != nil {
+			return fmt.Errorf("failed to decode message: %w", err)
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// read reads the content of the next message.
+func (s *lspServer) read() ([]byte, error) {
+	length := 0
+	for {
+		line, err := s.in.ReadString('\n')
+		if err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid content length: %w", err)
+			}
+		}
+	}
+	if length <= 0 {
+		return nil, errors.New("missing content length")
+	}
+
+	b := make([]byte, length)
+	if _, err := io.ReadFull(s.in, b); err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+	return b, nil
+}
+
+// write writes a message to the client.
+func (s *lspServer) write(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(b), b); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	return nil
+}
+
+// reply responds to a request, notifications are not responded to.
+func (s *lspServer) reply(msg lspMessage, result any, rerr *lspError) error {
+	if msg.ID == nil {
+		return nil
+	}
+	return s.write(lspResponse{JSONRPC: "2.0", ID: msg.ID, Result: result, Error: rerr})
+}
+
+// handle handles a request or notification from the client.
+func (s *lspServer) handle(msg lspMessage) error {
+	var params lspDocumentParams
+	if len(msg.Params) > 0 {
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return s.reply(msg, nil, &lspError{Code: lspInvalidParams, Message: err.Error()})
+		}
+	}
+	uri := params.TextDocument.URI
+
+	switch msg.Method {
+	case "initialize":
+		return s.reply(msg, map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync": lspSyncFull,
+				"completionProvider": map[string]any{
+					"triggerCharacters": []string{" ", "."},
+				},
+				"hoverProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "vhs", "version": Version},
+		}, nil)
+	case "shutdown":
+		return s.reply(msg, nil, nil)
+	case "textDocument/didOpen":
+		s.docs[uri] = params.TextDocument.Text
+		return s.publishDiagnostics(uri)
+	case "textDocument/didChange":
+		if n := len(params.ContentChanges); n > 0 {
+			s.docs[uri] = params.ContentChanges[n-1].Text
+		}
+		return s.publishDiagnostics(uri)
+	case "textDocument/didClose":
+		delete(s.docs, uri)
+		return s.write(lspNotification{
+			JSONRPC: "2.0",
+			Method:  "textDocument/publishDiagnostics",
+			Params:  map[string]any{"uri": uri, "diagnostics": []lspDiagnostic{}},
+		})
+	case "textDocument/completion":
+		return s.reply(msg, lspComplete(s.docs[uri], params.Position), nil)
+	case "textDocument/hover":
+		hover := lspHoverAt(s.docs[uri], params.Position)
+		if hover == nil {
+			return s.reply(msg, nil, nil)
+		}
+		return s.reply(msg, hover, nil)
+	default:
+		return s.reply(msg, nil, &lspError{Code: lspMethodNotFound, Message: "method not found: " + msg.Method})
+	}
+}
+
+// publishDiagnostics sends the parser errors of a document to the client.
+func (s *lspServer) publishDiagnostics(uri string) error {
+	return s.write(lspNotification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params: map[string]any{
+			"uri":         uri,
+			"diagnostics": lspDiagnostics(s.docs[uri], uri),
+		},
+	})
+}
+
+// lspDiagnostics returns the parser errors of a tape as diagnostics. Errors in
+// sourced tapes are reported at the start of the document.
+func lspDiagnostics(tape, uri string) []lspDiagnostic {
+	var opts []parser.Option
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		opts = append(opts, parser.WithFile(u.Path))
+	}
+
+	p := parser.New(lexer.New(tape), opts...)
+	_ = p.Parse()
+
+	diagnostics := []lspDiagnostic{}
+	for _, err := range p.Errors() {
+		d := lspDiagnostic{Severity: lspSeverityError, Code: string(err.Code), Source: "vhs", Message: err.Msg}
+		if err.File != "" {
+			d.Message = err.String()
+		} else {
+			d.Range = lspErrorRange(tape, err)
+		}
+		if err.Hint != "" {
+			d.Message += " (" + err.Hint + ")"
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics
+}
+
+// lspErrorRange returns the range of the command of an error when it is on the
+// line of the error, and of the token of the error otherwise, including the
+// delimiters of strings.
+func lspErrorRange(tape string, err parser.Error) lspRange {
+	start, end := err.Token.Column-1, tokenEnd(err.Token)
+	if err.Start.Line == err.Token.Line && err.End.Line == err.Token.Line {
+		start = min(start, err.Start.Column-1)
+		end = max(end, tokenEnd(err.End))
+	}
+
+	line := max(err.Token.Line-1, 0)
+	text := lineAt(tape, line)
+	return lspRange{
+		Start: lspPosition{Line: line, Character: utf16Column(text, max(start, 0))},
+		End:   lspPosition{Line: line, Character: utf16Column(text, end)},
+	}
+}
+
+// tokenEnd returns the byte offset of the end of a token on its line.
+func tokenEnd(t token.Token) int {
+	return t.Column - 1 + max(len(t.Raw), 1)
+}
+
+// utf16Column returns the position of a byte offset of a line in UTF-16 code
+// units, which LSP positions are expressed in.
+func utf16Column(line string, offset int) int {
+	if offset > len(line) {
+		return len(utf16.Encode([]rune(line))) + offset - len(line)
+	}
+	return len(utf16.Encode([]rune(line[:offset])))
+}
+
+// manEntry is a command or setting of the manual.
+type manEntry struct {
+	Name   string
+	Syntax string
+}
+
+// manEntries returns the entries of a list of the manual, named after their
+// highlighted word, e.g. Type for * %Type% "<string>".
+func manEntries(s string) []manEntry {
+	var entries []manEntry
+	for _, line := range strings.Split(s, "\n") {
+		entry, ok := strings.CutPrefix(line, "* ")
+		if !ok {
+			continue
+		}
+		_, rest, _ := strings.Cut(entry, specialChar)
+		name, _, ok := strings.Cut(rest, specialChar)
+		if !ok {
+			continue
+		}
+		entries = append(entries, manEntry{Name: name, Syntax: sanitizeSpecial(entry)})
+	}
+	return entries
+}
+
+// lspComplete returns the completions at a position of a tape: commands at the
+// start of a line, settings after Set, themes after Set Theme and extensions
+// after Output.
+func lspComplete(tape string, pos lspPosition) []lspCompletionItem {
+	line := lineAt(tape, pos.Line)
+	prefix := line[:min(max(pos.Character, 0), len(line))]
+	fields := strings.Fields(prefix)
+	word := ""
+	if len(fields) > 0 && !strings.HasSuffix(prefix, " ") && !strings.HasSuffix(prefix, "\t") {
+		word = fields[len(fields)-1]
+		fields = fields[:len(fields)-1]
+	}
+
+	items := []lspCompletionItem{}
+	switch {
+	case len(fields) == 0:
+		for _, e := range manEntries(manDescription) {
+			items = append(items, lspCompletionItem{Label: e.Name, Kind: lspCompletionKeyword, Detail: e.Syntax})
+		}
+	case fields[0] == "Set" && len(fields) == 1:
+		for _, e := range manSettingEntries() {
+			items = append(items, lspCompletionItem{Label: e.Name, Kind: lspCompletionProperty, Detail: e.Syntax})
+		}
+	case fields[0] == "Set" && fields[1] == "Theme":
+		themes, err := sortedThemeNames()
+		if err != nil {
+			return items
+		}
+		for _, theme := range themes {
+			items = append(items, lspCompletionItem{Label: theme, Kind: lspCompletionValue, InsertText: quote(theme)})
+		}
+	case fields[0] == "Output" && len(fields) == 1:
+		stem := strings.TrimSuffix(word, filepath.Ext(word))
+		if stem == "" {
+			stem = "demo"
+		}
+		for _, ext := range outputExtensions {
+			items = append(items, lspCompletionItem{Label: stem + ext, Kind: lspCompletionFile})
+		}
+	}
+	return items
+}
+
+// manSettingEntries returns the settings of the manual, along with the other
+// settings which are not documented in the manual.
+func manSettingEntries() []manEntry {
+	entries := manEntries(manSettings)
+	for _, name := range settingNames() {
+		documented := false
+		for _, e := range entries {
+			documented = documented || e.Name == name
+		}
+		if !documented {
+			entries = append(entries, manEntry{Name: name, Syntax: "Set " + name + " <value>"})
+		}
+	}
+	return entries
+}
+
+// settingNames returns the names of all the settings, in settingsOrder.
+func settingNames() []string {
+	names := make([]string, 0, len(settingsOrder))
+	for _, t := range settingsOrder {
+		for name, kt := range token.Keywords {
+			if kt == t && token.IsSetting(kt) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// lspHoverAt returns the documentation of the command or setting at a
+// position of a tape.
+func lspHoverAt(tape string, pos lspPosition) *lspHover {
+	line := lineAt(tape, pos.Line)
+	if pos.Character < 0 || pos.Character > len(line) {
+		return nil
+	}
+
+	isWord := func(c byte) bool { return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' }
+	start, end := pos.Character, pos.Character
+	for start > 0 && isWord(line[start-1]) {
+		start--
+	}
+	for end < len(line) && isWord(line[end]) {
+		end++
+	}
+	word := line[start:end]
+	if word == "" {
+		return nil
+	}
+
+	var doc string
+	fields := strings.Fields(line)
+	if len(fields) > 1 && fields[0] == "Set" && fields[1] == word {
+		for _, e := range manSettingEntries() {
+			if e.Name == word {
+				doc = "```\n" + e.Syntax + "\n```\n\n" + sanitizeMarkdown(strings.SplitN(manSettings, "\n", 2)[0]) //nolint:mnd
+			}
+		}
+	} else {
+		for _, e := range manEntries(manDescription) {
+			if e.Name != word {
+				continue
+			}
+			doc = "```\n" + e.Syntax + "\n```"
+			switch word {
+			case "Output":
+				doc += "\n\n" + sanitizeMarkdown(manOutput)
+			case "Set":
+				doc += "\n\n" + sanitizeMarkdown(strings.SplitN(manSettings, "\n", 2)[0]) //nolint:mnd
+			}
+		}
+	}
+	if doc == "" {
+		return nil
+	}
+
+	return &lspHover{
+		Contents: lspMarkupContent{Kind: "markdown", Value: doc},
+		Range: lspRange{
+			Start: lspPosition{Line: pos.Line, Character: start},
+			End:   lspPosition{Line: pos.Line, Character: end},
+		},
+	}
+}
+
+// lineAt returns the given line of a text, or an empty string if it doesn't
+// exist.
+func lineAt(text string, n int) string {
+	lines := strings.Split(text, "\n")
+	if n < 0 || n >= len(lines) {
+		return ""
+	}
+	return strings.TrimRight(lines[n], "\r")
+}
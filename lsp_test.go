@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// lspClient drives a language server over in-memory pipes.
+type lspClient struct {
+	t   *testing.T
+	in  *io.PipeWriter
+	out *bufio.Reader
+	id  int
+}
+
+func newLSPClient(t *testing.T) (*lspClient, chan error) {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeLSP(inR, outW)
+		_ = outW.Close()
+	}()
+	return &lspClient{t: t, in: inW, out: bufio.NewReader(outR)}, done
+}
+
+func (c *lspClient) send(method string, params any, request bool) {
+	c.t.Helper()
+	msg := map[string]any{"jsonrpc": "2.0", "method": method, "params": params}
+	if request {
+		c.id++
+		msg["id"] = c.id
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := fmt.Fprintf(c.in, "Content-Length: %d\r\n\r\n%s", len(b), b); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *lspClient) receive(v any) {
+	c.t.Helper()
+	length := 0
+	for {
+		line, err := c.out.ReadString('\n')
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if v, ok := strings.CutPrefix(line, "Content-Length: "); ok {
+			length, _ = strconv.Atoi(v)
+		}
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(c.out, b); err != nil {
+		c.t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *lspClient) request(method string, params any, result any) {
+	c.t.Helper()
+	c.send(method, params, true)
+	var resp struct {
+		ID     int             `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  *lspError       `json:"error"`
+	}
+	c.receive(&resp)
+	if resp.Error != nil {
+		c.t.Fatalf("%s failed: %s", method, resp.Error.Message)
+	}
+	if resp.ID != c.id {
+		c.t.Fatalf("expected response to %d, got %d", c.id, resp.ID)
+	}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func position(uri string, line, character int) map[string]any {
+	return map[string]any{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     map[string]int{"line": line, "character": character},
+	}
+}
+
+func TestLSP(t *testing.T) {
+	c, done := newLSPClient(t)
+	const uri = "untitled:demo.tape"
+
+	var initialize struct {
+		Capabilities struct {
+			HoverProvider bool `json:"hoverProvider"`
+		} `json:"capabilities"`
+	}
+	c.request("initialize", map[string]any{}, &initialize)
+	if !initialize.Capabilities.HoverProvider {
+		t.Errorf("expected hover capability")
+	}
+	c.send("initialized", map[string]any{}, false)
+
+	tape := "Output demo\nSet \nSet Theme \nType\n"
+	c.send("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "languageId": "tape", "version": 1, "text": tape},
+	}, false)
+
+	var published struct {
+		Method string `json:"method"`
+		Params struct {
+			URI         string          `json:"uri"`
+			Diagnostics []lspDiagnostic `json:"diagnostics"`
+		} `json:"params"`
+	}
+	c.receive(&published)
+	if published.Method != "textDocument/publishDiagnostics" || published.Params.URI != uri {
+		t.Fatalf("unexpected notification: %+v", published)
+	}
+	var got []string
+	for _, d := range published.Params.Diagnostics {
+		got = append(got, fmt.Sprintf("%d:%d-%d:%d %s",
+			d.Range.Start.Line, d.Range.Start.Character, d.Range.End.Line, d.Range.End.Character, d.Message))
+	}
+	want := []string{
+		"0:0-0:11 Expected folder with trailing slash",
+		"2:0-2:3 Unknown setting: Set",
+	}
+	if strings.Join(got[:2], "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected diagnostics:\n%s", strings.Join(got, "\n"))
+	}
+
+	tests := []struct {
+		name      string
+		line, col int
+		want      string
+	}{
+		{"commands", 3, 2, "Type"},
+		{"settings", 1, 4, "TypingSpeed"},
+		{"themes", 2, 10, "Catppuccin Mocha"},
+		{"outputs", 0, 11, "demo.webm"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var items []lspCompletionItem
+			c.request("textDocument/completion", position(uri, tc.line, tc.col), &items)
+			for _, item := range items {
+				if item.Label == tc.want {
+					return
+				}
+			}
+			t.Errorf("expected %s in %d completions", tc.want, len(items))
+		})
+	}
+
+	var hover lspHover
+	c.request("textDocument/hover", position(uri, 3, 1), &hover)
+	if !strings.Contains(hover.Contents.Value, `Type "<string>"`) {
+		t.Errorf("unexpected hover: %s", hover.Contents.Value)
+	}
+
+	var shutdown any
+	c.request("shutdown", nil, &shutdown)
+	c.send("exit", nil, false)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLSPDiagnosticRanges(t *testing.T) {
+	tape := "Type \"😀 é\" @ 5\nSource \"😀.txt\"\n"
+	var got []string
+	for _, d := range lspDiagnostics(tape, "untitled:demo.tape") {
+		got = append(got, fmt.Sprintf("%d:%d-%d:%d", d.Range.Start.Line, d.Range.Start.Character, d.Range.End.Line, d.Range.End.Character))
+	}
+	want := []string{"0:12-0:13", "1:0-1:15"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("want ranges %v, got %v", want, got)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	_ "embed"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -16,9 +17,11 @@ import (
 	"runtime/debug"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/charmbracelet/vhs/lexer"
 	"github.com/charmbracelet/vhs/parser"
+	"github.com/charmbracelet/vhs/token"
 	version "github.com/hashicorp/go-version"
 	"github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
@@ -85,18 +88,35 @@ var (
 				return errors.New("no input provided")
 			}
 
+			tapeVars, err := flagVars(cmd)
+			if err != nil {
+				return err
+			}
+			reports, err := flagReports(cmd)
+			if err != nil {
+				return err
+			}
+
 			var publishFile string
 			out := cmd.OutOrStdout()
 			if quietFlag {
 				out = io.Discard
 			}
-			errs := Evaluate(cmd.Context(), string(input), out, func(v *VHS) {
-				// Output is being overridden, prevent all outputs
-				if len(*outputs) <= 0 {
-					publishFile = v.Options.Video.Output.GIF
-					return
-				}
-
+			parseOpts := []parser.Option{parser.WithVars(tapeVars)}
+			if len(args) > 0 && args[0] != "-" {
+				parseOpts = append(parseOpts, parser.WithFile(args[0]))
+			}
+			ctx := cmd.Context()
+			result := testResult{file: "-", tape: string(input)}
+			if len(args) > 0 {
+				result.file = args[0]
+			}
+			steps := &stepRecorder{}
+			if len(reports) > 0 {
+				ctx = withStepRecorder(ctx, steps)
+			}
+			start := time.Now()
+			errs := Evaluate(ctx, string(input), out, WithParseOptions(parseOpts...), BeforeRecord(func(v *VHS) {
 				for _, output := range *outputs {
 					if strings.HasSuffix(output, gif) {
 						v.Options.Video.Output.GIF = output
@@ -104,11 +124,28 @@ var (
 						v.Options.Video.Output.WebM = output
 					} else if strings.HasSuffix(output, mp4) {
 						v.Options.Video.Output.MP4 = output
+					} else if strings.HasSuffix(output, webp) {
+						v.Options.Video.Output.WebP = output
+					} else if strings.HasSuffix(output, apng) {
+						v.Options.Video.Output.APNG = output
+					} else if strings.HasSuffix(output, svg) {
+						v.Options.Video.Output.SVG = output
+					} else if strings.HasSuffix(output, cast) {
+						v.Options.Video.Output.Cast = output
+					} else if strings.HasSuffix(output, html) {
+						v.Options.Video.Output.HTML = output
 					}
 				}
-
+			}), BeforeRender(func(v *VHS) {
+				result.artifacts = outputArtifacts(v)
 				publishFile = v.Options.Video.Output.GIF
-			})
+			}))
+			result.duration = time.Since(start)
+			result.errs = errs
+			result.steps = steps.steps
+			if err := writeReports(reports, []reportTape{newReportTape(result)}); err != nil {
+				return err
+			}
 
 			publishEnv, publishEnvSet := os.LookupEnv("VHS_PUBLISH")
 			if !publishEnvSet && !publishFlag && len(errs) == 0 {
@@ -177,6 +214,36 @@ var (
 		RunE:  Record,
 	}
 
+	convertOutput string
+	convertCmd    = &cobra.Command{
+		Use:   "convert <file>.cast",
+		Short: "Convert an asciinema recording into a tape file",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to open %s: %w", args[0], err)
+			}
+			defer f.Close() //nolint:errcheck
+
+			header, events, err := readCast(f)
+			if err != nil {
+				return err
+			}
+			tape := castToTape(header, events)
+
+			if convertOutput == "" {
+				_, _ = fmt.Fprint(cmd.OutOrStdout(), tape)
+				return nil
+			}
+			if err := os.WriteFile(convertOutput, []byte(tape), 0o600); err != nil {
+				return fmt.Errorf("failed to write %s: %w", convertOutput, err)
+			}
+			log.Println("Created " + convertOutput)
+			return nil
+		},
+	}
+
 	//nolint:wrapcheck
 	newCmd = &cobra.Command{
 		Use:   "new <name>",
@@ -206,9 +273,14 @@ var (
 		Use:   "validate <file>...",
 		Short: "Validate a glob file path and parses all the files to ensure they are valid without running them.",
 		Args:  cobra.MinimumNArgs(1),
-		RunE: func(_ *cobra.Command, args []string) error {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			valid := true
 
+			tapeVars, err := flagVars(cmd)
+			if err != nil {
+				return err
+			}
+
 			for _, file := range args {
 				b, err := os.ReadFile(file)
 				if err != nil {
@@ -216,7 +288,7 @@ var (
 				}
 
 				l := lexer.New(string(b))
-				p := parser.New(l)
+				p := parser.New(l, parser.WithVars(tapeVars), parser.WithFile(file))
 
 				_ = p.Parse()
 				errs := p.Errors()
@@ -238,8 +310,69 @@ var (
 			return nil
 		},
 	}
+
+	parseJSON bool
+	parseCmd  = &cobra.Command{
+		Use:   "parse <file>",
+		Short: "Parse a tape file and print its commands with their positions",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tapeVars, err := flagVars(cmd)
+			if err != nil {
+				return err
+			}
+
+			b, err := os.ReadFile(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", args[0], err)
+			}
+
+			p := parser.New(lexer.New(string(b)), parser.WithVars(tapeVars), parser.WithFile(args[0]))
+			_ = p.Parse()
+			if errs := p.Errors(); len(errs) != 0 {
+				printErrors(os.Stderr, string(b), []error{InvalidSyntaxError{errs}})
+				return errors.New("invalid tape file")
+			}
+
+			// The tape is valid once expanded, print it as it is written.
+			p = parser.New(lexer.New(string(b)), parser.WithFile(args[0]), parser.WithComments(), parser.WithoutExpansion())
+			cmds := p.Parse()
+
+			out := cmd.OutOrStdout()
+			if parseJSON {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(cmds); err != nil {
+					return fmt.Errorf("failed to encode commands: %w", err)
+				}
+				return nil
+			}
+
+			printCommands(out, cmds, 0)
+			return nil
+		},
+	}
 )
 
+// printCommands prints the commands of a tape with their position, indenting
+// the commands of blocks.
+func printCommands(out io.Writer, cmds []parser.Command, depth int) {
+	for _, c := range cmds {
+		pos := GrayStyle.Render(fmt.Sprintf("%3d:%-3d", c.Start.Line, c.Start.Column))
+		indent := strings.Repeat("  ", depth)
+		if c.Type == token.COMMENT {
+			_, _ = fmt.Fprintf(out, "%s %s%s\n", pos, indent, FaintStyle.Render("#"+c.Args))
+			continue
+		}
+		_, _ = fmt.Fprintf(out, "%s %s%s\n", pos, indent, Highlight(c, false))
+		printCommands(out, c.Body, depth+1)
+		if len(c.Else) > 0 {
+			_, _ = fmt.Fprintf(out, "%s %s%s\n", GrayStyle.Render(strings.Repeat(" ", 7)), indent, CommandStyle.Render("Else"))
+			printCommands(out, c.Else, depth+1)
+		}
+	}
+}
+
 func main() {
 	ctx, cancel := signal.NotifyContext(
 		context.Background(),
@@ -258,6 +391,20 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&quietFlag, "quiet", "q", false, "quiet do not log messages. If publish flag is provided, it will log shareable URL")
 
 	outputs = rootCmd.Flags().StringSliceP("output", "o", []string{}, "file name(s) of video output")
+	rootCmd.Flags().StringArray("var", []string{}, "set a tape variable, overriding its Let value (name=value)")
+	rootCmd.Flags().StringArray("report", []string{}, "write a test report of the tape (junit=path.xml or json=path.json)")
+	validateCmd.Flags().StringArray("var", []string{}, "set a tape variable, overriding its Let value (name=value)")
+	fmtCmd.Flags().BoolVarP(&fmtWrite, "write", "w", false, "write the formatted tape to the file instead of stdout")
+	fmtCmd.Flags().BoolVar(&fmtCheck, "check", false, "exit with a non-zero status if any file is not formatted")
+	parseCmd.Flags().BoolVar(&parseJSON, "json", false, "print the commands as JSON")
+	parseCmd.Flags().StringArray("var", []string{}, "set a tape variable, overriding its Let value (name=value)")
+	testCmd.Flags().StringArray("var", []string{}, "set a tape variable, overriding its Let value (name=value)")
+	testCmd.Flags().BoolVarP(&testUpdate, "update", "u", false, "write the golden files and reference images instead of comparing against them")
+	testCmd.Flags().BoolVar(&testVisual, "visual", false, "compare the screenshots against the reference images at their paths")
+	testCmd.Flags().Float64Var(&testTolerance, "tolerance", 0, "fraction of the pixels of a screenshot which may differ from its reference image")
+	testCmd.Flags().StringArray("report", []string{}, "write a test report of the tapes (junit=path.xml or json=path.json)")
+	lintCmd.Flags().StringSliceVar(&lintDisable, "disable", []string{}, "disable lint rules ("+strings.Join(lintRuleIDs(), ", ")+")")
+	lintCmd.Flags().BoolVar(&lintJSON, "json", false, "output the problems as JSON")
 	themesCmd.Flags().BoolVar(&markdown, "markdown", false, "output as markdown")
 	_ = themesCmd.Flags().MarkHidden("markdown")
 	recordShell := filepath.Base(os.Getenv("SHELL"))
@@ -265,11 +412,18 @@ func init() {
 		recordShell = defaultShell
 	}
 	recordCmd.Flags().StringVarP(&shell, "shell", "s", recordShell, "shell for recording")
+	convertCmd.Flags().StringVarP(&convertOutput, "output", "o", "", "file to write the tape to, instead of stdout")
 	rootCmd.AddCommand(
 		recordCmd,
+		convertCmd,
 		newCmd,
 		themesCmd,
 		validateCmd,
+		parseCmd,
+		testCmd,
+		fmtCmd,
+		lintCmd,
+		lspCmd,
 		manCmd,
 		serveCmd,
 		publishCmd,
@@ -290,6 +444,28 @@ func init() {
 	rootCmd.Version = Version
 }
 
+// flagVars returns the variables set with the --var flag of the command.
+func flagVars(cmd *cobra.Command) (map[string]string, error) {
+	pairs, err := cmd.Flags().GetStringArray("var")
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	return parseVars(pairs)
+}
+
+// parseVars parses the name=value pairs given through the --var flag.
+func parseVars(pairs []string) (map[string]string, error) {
+	vars := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid variable %q, expected name=value", pair)
+		}
+		vars[name] = value
+	}
+	return vars, nil
+}
+
 var versionRegex = regexp.MustCompile(`\d+\.\d+\.\d+`)
 
 // getVersion returns the parsed version of a program.
@@ -310,15 +486,22 @@ func ensureDependencies() error {
 	if ffmpegErr != nil {
 		return fmt.Errorf("ffmpeg is not installed. Install it from: http://ffmpeg.org")
 	}
-	_, ttydErr := exec.LookPath("ttyd")
-	if ttydErr != nil {
-		return fmt.Errorf("ttyd is not installed. Install it from: https://github.com/tsl0922/ttyd")
-	}
 	_, shellErr := exec.LookPath(defaultShell)
 	if shellErr != nil {
 		return fmt.Errorf("%v is not installed", defaultShell)
 	}
 
+	return nil
+}
+
+// ensureTtyd ensures that ttyd, which the browser backend needs, is correctly
+// installed and versioned.
+func ensureTtyd() error {
+	_, ttydErr := exec.LookPath("ttyd")
+	if ttydErr != nil {
+		return fmt.Errorf("ttyd is not installed. Install it from: https://github.com/tsl0922/ttyd")
+	}
+
 	ttydVersion := getVersion("ttyd")
 	if ttydVersion == nil || ttydVersion.LessThan(ttydMinVersion) {
 		return fmt.Errorf("ttyd version (%s) is out of date, VHS requires %s\n%s",
@@ -24,7 +24,7 @@ A tape file is a script made up of commands describing what actions to perform i
 
 The following is a list of all possible commands in VHS:
 
-* %Output% <path>.(gif|webm|mp4)
+* %Output% <path>.(gif|webm|mp4|webp|apng|svg|cast|html)
 * %Require% <program>
 * %Set% <setting> <value>
 * %Sleep% <time>
@@ -46,17 +46,24 @@ The following is a list of all possible commands in VHS:
 * %Hide%
 * %Show%
 * %Wait%[+Screen][@<timeout>] /<regexp>/
+* %Expect% [Line|Screen] /<regexp>/
+* %ExpectNot% [Line|Screen] /<regexp>/
 * %Escape%
 * %Alt%+<key>
 * %Space% [repeat]
-* %Source% <path>.tape
+* %Source% <path>.tape [key=value...]
 * %Screenshot% <path>.png
 * %Copy% "<string>"
 * %Paste%
+* %Let% <name> "<value>"
+* %Define% <name> [params...] ... %End%
+* %Call% <name> [args...]
+* %Repeat% <count> [variable] ... %End%
+* %If% [Line|Screen] /<regexp>/ ... [%Else% ...] %End%
 `
 
 	manOutput = `The Output command instructs VHS where to save the output of the recording.
-File names with the extension %.gif%, %.webm%, %.mp4% will have the respective file types.
+File names with the extension %.gif%, %.webm%, %.mp4%, %.webp%, %.apng%, %.svg%, %.cast%, %.html% will have the respective file types.
 `
 
 	manSettings = `The Set command allows VHS to adjust settings in the terminal, such as fonts, dimensions, and themes.
@@ -77,6 +84,13 @@ The following is a list of all possible setting commands in VHS:
 * Set %PlaybackSpeed% <float>
 * Set %WaitTimeout% <time>
 * Set %WaitPattern% <regexp>
+* Set %Clock% real|virtual
+* Set %Backend% browser|native
+* Set %GIFEncoder% ffmpeg|native
+* Set %GIFDither% none|floyd-steinberg
+* Set %MaxColors% <number>
+* Set %WebPQuality% <number>
+* Set %WebPLossless% <boolean>
 `
 	manBugs = "See GitHub Issues: <https://github.com/charmbracelet/vhs/issues>"
 
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/binary"
+	"image"
+	"io"
+	"time"
+)
+
+// The frames are streamed to ffmpeg as a Matroska stream of raw RGBA frames.
+// Unlike raw frames read at a fixed frame rate, each frame of the stream has
+// its own timestamp and duration, so that a frame which doesn't change is only
+// sent once, however long it lasts.
+//
+// https://www.matroska.org/technical/elements.html
+const (
+	ebmlHeaderID         = 0x1A45DFA3
+	ebmlVersionID        = 0x4286
+	ebmlReadVersionID    = 0x42F7
+	ebmlMaxIDLengthID    = 0x42F2
+	ebmlMaxSizeLengthID  = 0x42F3
+	ebmlDocTypeID        = 0x4282
+	ebmlDocTypeVersionID = 0x4287
+	ebmlDocTypeReadID    = 0x4285
+
+	mkvSegmentID        = 0x18538067
+	mkvInfoID           = 0x1549A966
+	mkvTimestampScaleID = 0x2AD7B1
+	mkvMuxingAppID      = 0x4D80
+	mkvWritingAppID     = 0x5741
+	mkvTracksID         = 0x1654AE6B
+	mkvTrackEntryID     = 0xAE
+	mkvTrackNumberID    = 0xD7
+	mkvTrackUIDID       = 0x73C5
+	mkvTrackTypeID      = 0x83
+	mkvFlagLacingID     = 0x9C
+	mkvCodecID          = 0x86
+	mkvVideoID          = 0xE0
+	mkvPixelWidthID     = 0xB0
+	mkvPixelHeightID    = 0xBA
+	mkvColourSpaceID    = 0x2EB524
+	mkvClusterID        = 0x1F43B675
+	mkvTimestampID      = 0xE7
+	mkvBlockGroupID     = 0xA0
+	mkvBlockID          = 0xA1
+	mkvBlockDurationID  = 0x9B
+)
+
+// mkvTimestampScale is the unit of the timestamps and durations of the
+// frames.
+const mkvTimestampScale = time.Microsecond
+
+// mkvUnknownSize is the size of the segment, whose length isn't known while
+// it is streamed.
+var mkvUnknownSize = []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+// writeMatroskaHeader writes the header of a stream of RGBA frames of the
+// given size, up to its first frame.
+func writeMatroskaHeader(w io.Writer, size image.Point) error {
+	header := ebmlElement(ebmlHeaderID,
+		ebmlUint(ebmlVersionID, 1),
+		ebmlUint(ebmlReadVersionID, 1),
+		ebmlUint(ebmlMaxIDLengthID, 4),   //nolint:mnd
+		ebmlUint(ebmlMaxSizeLengthID, 8), //nolint:mnd
+		ebmlString(ebmlDocTypeID, "matroska"),
+		ebmlUint(ebmlDocTypeVersionID, 4), //nolint:mnd
+		ebmlUint(ebmlDocTypeReadID, 2),    //nolint:mnd
+	)
+	info := ebmlElement(mkvInfoID,
+		ebmlUint(mkvTimestampScaleID, uint64(mkvTimestampScale)),
+		ebmlString(mkvMuxingAppID, "vhs"),
+		ebmlString(mkvWritingAppID, "vhs"),
+	)
+	tracks := ebmlElement(mkvTracksID,
+		ebmlElement(mkvTrackEntryID,
+			ebmlUint(mkvTrackNumberID, 1),
+			ebmlUint(mkvTrackUIDID, 1),
+			ebmlUint(mkvTrackTypeID, 1),
+			ebmlUint(mkvFlagLacingID, 0),
+			ebmlString(mkvCodecID, "V_UNCOMPRESSED"),
+			ebmlElement(mkvVideoID,
+				ebmlUint(mkvPixelWidthID, uint64(size.X)),  //nolint:gosec
+				ebmlUint(mkvPixelHeightID, uint64(size.Y)), //nolint:gosec
+				// The FourCC of the pixel format of the raw frames.
+				ebmlString(mkvColourSpaceID, "RGBA"),
+			),
+		),
+	)
+
+	var b []byte
+	b = append(b, header...)
+	b = append(b, ebmlID(mkvSegmentID)...)
+	b = append(b, mkvUnknownSize...)
+	b = append(b, info...)
+	b = append(b, tracks...)
+	_, err := w.Write(b)
+	return err //nolint:wrapcheck
+}
+
+// writeMatroskaFrame writes the raw pixels of a frame shown at the given time
+// for the given duration, in a cluster of its own.
+func writeMatroskaFrame(w io.Writer, pix []byte, start, duration time.Duration) error {
+	// The block of the frame, on the first track, with no relative
+	// timestamp and no flags.
+	block := []byte{0x81, 0x00, 0x00, 0x00}
+	timestamp := ebmlUint(mkvTimestampID, uint64(start/mkvTimestampScale))            //nolint:gosec
+	blockDuration := ebmlUint(mkvBlockDurationID, uint64(duration/mkvTimestampScale)) //nolint:gosec
+
+	blockSize := uint64(len(block) + len(pix))
+	blockGroupSize := ebmlHeaderLength(mkvBlockID) + blockSize + uint64(len(blockDuration))
+	clusterSize := uint64(len(timestamp)) + ebmlHeaderLength(mkvBlockGroupID) + blockGroupSize
+
+	var b []byte
+	b = append(b, ebmlID(mkvClusterID)...)
+	b = append(b, ebmlSize(clusterSize)...)
+	b = append(b, timestamp...)
+	b = append(b, ebmlID(mkvBlockGroupID)...)
+	b = append(b, ebmlSize(blockGroupSize)...)
+	b = append(b, ebmlID(mkvBlockID)...)
+	b = append(b, ebmlSize(blockSize)...)
+	b = append(b, block...)
+	if _, err := w.Write(b); err != nil {
+		return err //nolint:wrapcheck
+	}
+	if _, err := w.Write(pix); err != nil {
+		return err //nolint:wrapcheck
+	}
+	_, err := w.Write(blockDuration)
+	return err //nolint:wrapcheck
+}
+
+// ebmlElement returns an element with the given ID, holding the given
+// elements.
+func ebmlElement(id uint32, children ...[]byte) []byte {
+	var data []byte
+	for _, child := range children {
+		data = append(data, child...)
+	}
+	return append(append(ebmlID(id), ebmlSize(uint64(len(data)))...), data...)
+}
+
+// ebmlUint returns an element holding an unsigned integer.
+func ebmlUint(id uint32, v uint64) []byte {
+	var data []byte
+	for shift := 56; shift > 0; shift -= 8 {
+		if v>>shift != 0 || len(data) > 0 {
+			data = append(data, byte(v>>shift))
+		}
+	}
+	data = append(data, byte(v))
+	return append(append(ebmlID(id), ebmlSize(uint64(len(data)))...), data...)
+}
+
+// ebmlString returns an element holding a string.
+func ebmlString(id uint32, s string) []byte {
+	return append(append(ebmlID(id), ebmlSize(uint64(len(s)))...), s...)
+}
+
+// ebmlID returns the bytes of an element ID, which include the marker of
+// their length.
+func ebmlID(id uint32) []byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], id)
+	switch {
+	case id > 0xFFFFFF:
+		return b[:]
+	case id > 0xFFFF:
+		return b[1:]
+	case id > 0xFF:
+		return b[2:]
+	default:
+		return b[3:]
+	}
+}
+
+// ebmlSize returns the size of an element's data, always encoded on 8 bytes
+// so that sizes can be computed ahead of the data.
+func ebmlSize(n uint64) []byte {
+	b := make([]byte, 8) //nolint:mnd
+	binary.BigEndian.PutUint64(b, n|1<<56)
+	return b
+}
+
+// ebmlHeaderLength returns the length of the ID and size of an element.
+func ebmlHeaderLength(id uint32) uint64 {
+	return uint64(len(ebmlID(id))) + 8 //nolint:mnd
+}
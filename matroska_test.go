@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"math/bits"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// ebmlNode is an element read from an EBML stream.
+type ebmlNode struct {
+	id   uint32
+	data []byte
+}
+
+// readEBML reads the consecutive elements of the stream. An element of
+// unknown size holds the rest of the stream.
+func readEBML(t *testing.T, b []byte) []ebmlNode {
+	t.Helper()
+	var nodes []ebmlNode
+	for len(b) > 0 {
+		n := bits.LeadingZeros8(b[0]) + 1
+		if n > 4 || len(b) < n {
+			t.Fatalf("invalid element ID % x", b[:min(len(b), 4)])
+		}
+		var id uint32
+		for _, c := range b[:n] {
+			id = id<<8 | uint32(c)
+		}
+		b = b[n:]
+
+		n = bits.LeadingZeros8(b[0]) + 1
+		if n > 8 || len(b) < n {
+			t.Fatalf("invalid size of element %x", id)
+		}
+		size := uint64(b[0]) & (0xFF >> n)
+		unknown := size == 0xFF>>n
+		for _, c := range b[1:n] {
+			size = size<<8 | uint64(c)
+			unknown = unknown && c == 0xFF
+		}
+		b = b[n:]
+		if unknown {
+			size = uint64(len(b))
+		}
+		if size > uint64(len(b)) {
+			t.Fatalf("element %x of %d bytes exceeds the stream", id, size)
+		}
+		nodes = append(nodes, ebmlNode{id: id, data: b[:size]})
+		b = b[size:]
+	}
+	return nodes
+}
+
+// ebmlValue returns the unsigned integer held by an element.
+func ebmlValue(data []byte) uint64 {
+	var b [8]byte
+	copy(b[8-len(data):], data)
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// matroskaFrame is a frame read from a Matroska stream.
+type matroskaFrame struct {
+	pix             []byte
+	start, duration uint64
+}
+
+// readMatroska reads the size and frames of a stream written by the video
+// encoder.
+func readMatroska(t *testing.T, b []byte) (image.Point, []matroskaFrame) {
+	t.Helper()
+	top := readEBML(t, b)
+	if len(top) != 2 || top[0].id != ebmlHeaderID || top[1].id != mkvSegmentID {
+		t.Fatalf("expected an EBML header and a segment, got %v", top)
+	}
+
+	var (
+		size   image.Point
+		frames []matroskaFrame
+	)
+	for _, node := range readEBML(t, top[1].data) {
+		switch node.id {
+		case mkvTracksID:
+			entry := readEBML(t, node.data)[0]
+			for _, e := range readEBML(t, entry.data) {
+				if e.id != mkvVideoID {
+					continue
+				}
+				for _, v := range readEBML(t, e.data) {
+					switch v.id {
+					case mkvPixelWidthID:
+						size.X = int(ebmlValue(v.data)) //nolint:gosec
+					case mkvPixelHeightID:
+						size.Y = int(ebmlValue(v.data)) //nolint:gosec
+					case mkvColourSpaceID:
+						if string(v.data) != "RGBA" {
+							t.Errorf("expected RGBA frames, got %q", v.data)
+						}
+					}
+				}
+			}
+		case mkvClusterID:
+			var frame matroskaFrame
+			for _, c := range readEBML(t, node.data) {
+				switch c.id {
+				case mkvTimestampID:
+					frame.start = ebmlValue(c.data)
+				case mkvBlockGroupID:
+					for _, g := range readEBML(t, c.data) {
+						switch g.id {
+						case mkvBlockID:
+							frame.pix = g.data[4:]
+						case mkvBlockDurationID:
+							frame.duration = ebmlValue(g.data)
+						}
+					}
+				}
+			}
+			frames = append(frames, frame)
+		}
+	}
+	return size, frames
+}
+
+func TestMatroskaStream(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeMatroskaHeader(&buf, image.Pt(2, 1)); err != nil {
+		t.Fatal(err)
+	}
+	red := []byte{0xFF, 0, 0, 0xFF, 0xFF, 0, 0, 0xFF}
+	blue := []byte{0, 0, 0xFF, 0xFF, 0, 0, 0xFF, 0xFF}
+	if err := writeMatroskaFrame(&buf, red, 0, 60*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeMatroskaFrame(&buf, blue, 60*time.Millisecond, 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	size, frames := readMatroska(t, buf.Bytes())
+	if size != image.Pt(2, 1) {
+		t.Errorf("expected frames of 2x1, got %v", size)
+	}
+	want := []matroskaFrame{
+		{pix: red, start: 0, duration: 60_000},
+		{pix: blue, start: 60_000, duration: 2_000_000},
+	}
+	if !reflect.DeepEqual(frames, want) {
+		t.Errorf("expected frames %v, got %v", want, frames)
+	}
+}
@@ -3,13 +3,16 @@ package parser
 
 import (
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/agnivade/levenshtein"
 	"github.com/charmbracelet/vhs/lexer"
 	"github.com/charmbracelet/vhs/token"
 )
@@ -19,9 +22,75 @@ func NewError(token token.Token, msg string) Error {
 	return Error{
 		Token: token,
 		Msg:   msg,
+		Code:  ErrSyntax,
 	}
 }
 
+// ErrorCode identifies the kind of a parser.Error.
+type ErrorCode string
+
+// Parser error codes.
+const (
+	ErrSyntax            ErrorCode = "syntax"
+	ErrInvalidCommand    ErrorCode = "invalid-command"
+	ErrUnknownSetting    ErrorCode = "unknown-setting"
+	ErrUndefinedVariable ErrorCode = "undefined-variable"
+	ErrUndefinedMacro    ErrorCode = "undefined-macro"
+	ErrUnmatchedBlock    ErrorCode = "unmatched-block"
+)
+
+// maxSuggestionDistance is the maximum Levenshtein distance between a
+// misspelled word and its suggestion.
+const maxSuggestionDistance = 2
+
+// suggest returns the candidate closest to the given word, or an empty string
+// if none is close enough to be a likely typo.
+func suggest(word string, candidates []string) string {
+	threshold := min(maxSuggestionDistance, len(word)/2)
+	best, bestDistance := "", threshold+1
+	lword := strings.ToLower(word)
+	for _, c := range candidates {
+		d := levenshtein.ComputeDistance(lword, strings.ToLower(c))
+		if d < bestDistance || (d == bestDistance && c < best) {
+			best, bestDistance = c, d
+		}
+	}
+	return best
+}
+
+// withSuggestion adds a "did you mean" hint to the error, along with the
+// suggestion as a fix, if a candidate is close to the literal of its token.
+func withSuggestion(err Error, word string, candidates []string) Error {
+	if suggestion := suggest(word, candidates); suggestion != "" {
+		err.Hint = "did you mean " + suggestion + "?"
+		err.Fix = suggestion
+	}
+	return err
+}
+
+// keywords returns the sorted keywords of the tokens matching the filter.
+func keywords(filter func(token.Type) bool) []string {
+	var names []string
+	for name, t := range token.Keywords {
+		if filter(t) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// isCommandKeyword returns whether the token starts a command.
+func isCommandKeyword(t token.Type) bool {
+	switch t {
+	case token.ILLEGAL, token.END, token.ELSE:
+		return false
+	case token.SHIFT, token.LET, token.DEFINE, token.CALL, token.REPEAT:
+		return true
+	}
+	return slices.Contains(CommandTypes, CommandType(t))
+}
+
 // CommandType is a type that represents a command.
 type CommandType token.Type
 
@@ -53,22 +122,40 @@ var CommandTypes = []CommandType{
 	token.TYPE,
 	token.UP,
 	token.WAIT,
+	token.EXPECT,
+	token.EXPECT_NOT,
 	token.SOURCE,
 	token.SCREENSHOT,
 	token.COPY,
 	token.PASTE,
 	token.ENV,
+	token.IF,
 }
 
 // String returns the string representation of the command.
 func (c CommandType) String() string { return token.ToCamel(string(c)) }
 
 // Command represents a command with options and arguments.
+//
+// Commands which contain other commands, i.e. If, hold them in Body and, if
+// they have an alternative branch, in Else. Without expansion, so do Define
+// and Repeat.
+//
+// Start and End are the first and last tokens of the command in the tape,
+// Tokens the tokens on its first line, e.g. to report a problem with one of
+// its arguments, and Raw holds their text as it is written, with the quotes
+// of strings and before variables are interpolated.
 type Command struct {
-	Type    CommandType
-	Options string
-	Args    string
-	Source  string
+	Type    CommandType   `json:"type"`
+	Options string        `json:"options,omitempty"`
+	Args    string        `json:"args,omitempty"`
+	Source  string        `json:"source,omitempty"`
+	Body    []Command     `json:"body,omitempty"`
+	Else    []Command     `json:"else,omitempty"`
+	Start   token.Token   `json:"start"`
+	End     token.Token   `json:"end"`
+	Raw     []string      `json:"raw,omitempty"`
+	Tokens  []token.Token `json:"-"`
 }
 
 // String returns the string representation of the command.
@@ -85,11 +172,27 @@ func (c Command) String() string {
 type Error struct {
 	Token token.Token
 	Msg   string
+	// File is the sourced tape in which the error occurred, empty for errors
+	// in the tape being parsed.
+	File string
+	// Start and End are the first and last tokens of the command in which the
+	// error occurred.
+	Start token.Token
+	End   token.Token
+	// Code identifies the kind of error.
+	Code ErrorCode
+	// Hint is a suggestion to fix the error, and Fix, when set, the literal
+	// which should replace the token.
+	Hint string
+	Fix  string
 }
 
 // String returns a human readable error message printing the token line number
 // and message.
 func (e Error) String() string {
+	if e.File != "" {
+		return fmt.Sprintf("%s:%d:%d │ %s", e.File, e.Token.Line, e.Token.Column, e.Msg)
+	}
 	return fmt.Sprintf("%2d:%-2d │ %s", e.Token.Line, e.Token.Column, e.Msg)
 }
 
@@ -100,15 +203,114 @@ func (e Error) Error() string {
 
 // Parser is the structure that manages the parsing of tokens.
 type Parser struct {
-	l      *lexer.Lexer
-	errors []Error
-	cur    token.Token
-	peek   token.Token
+	l         tokenSource
+	errors    []Error
+	cur       token.Token
+	peek      token.Token
+	vars      map[string]string
+	overrides map[string]string
+	macros    map[string]*macro
+	calls     []string
+	nested    bool
+	file      string
+	sources   []string
+	comments  bool
+	literal   bool
+	consumed  []token.Token
+}
+
+// tokenSource is anything that produces tokens, i.e. the lexer or the replay
+// of the tokens of a macro body.
+type tokenSource interface {
+	NextToken() token.Token
+}
+
+// tokenReplay is a tokenSource which replays previously read tokens.
+type tokenReplay struct {
+	tokens []token.Token
+	eof    token.Token
+	pos    int
+}
+
+// NextToken returns the next recorded token, or EOF once all the tokens have
+// been replayed.
+func (r *tokenReplay) NextToken() token.Token {
+	if r.pos >= len(r.tokens) {
+		return r.eof
+	}
+	t := r.tokens[r.pos]
+	r.pos++
+	return t
+}
+
+// macro is a block of commands defined with Define which can be expanded with
+// Call.
+type macro struct {
+	name   string
+	params []string
+	body   []token.Token
+	end    token.Token
+	// file is the path of the tape the macro is defined in, and source the
+	// Source path of that tape when it is a sourced tape.
+	file   string
+	source string
+}
+
+// Option is a function that can be used to configure the Parser.
+type Option func(*Parser)
+
+// WithVars sets variables which take precedence over the values defined in
+// the tape with Let, e.g. to override them from the command line.
+func WithVars(vars map[string]string) Option {
+	return func(p *Parser) {
+		p.overrides = vars
+	}
+}
+
+// WithFile sets the path of the tape being parsed, Source paths are resolved
+// relative to its directory.
+func WithFile(path string) Option {
+	return func(p *Parser) {
+		p.file = path
+	}
+}
+
+// WithComments makes the parser return the comments of the tape as COMMENT
+// commands, e.g. to inspect the tape rather than to evaluate it.
+func WithComments() Option {
+	return func(p *Parser) {
+		p.comments = true
+	}
+}
+
+// WithoutExpansion makes the parser return Let, Define, Call, Repeat and
+// Source as commands of their own, with the commands of their blocks in Body,
+// and keep variable references as they are written, e.g. to inspect the tape
+// as it is written rather than as it is evaluated.
+func WithoutExpansion() Option {
+	return func(p *Parser) {
+		p.literal = true
+	}
 }
 
 // New returns a new Parser.
-func New(l *lexer.Lexer) *Parser {
-	p := &Parser{l: l, errors: []Error{}}
+func New(l *lexer.Lexer, opts ...Option) *Parser {
+	p := &Parser{
+		l:      l,
+		errors: []Error{},
+		vars:   map[string]string{},
+		macros: map[string]*macro{},
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.file != "" {
+		if abs, err := filepath.Abs(p.file); err == nil {
+			p.sources = []string{abs}
+		}
+	}
 
 	// Read two tokens, so cur and peek are both set.
 	p.nextToken()
@@ -124,16 +326,84 @@ func (p *Parser) Parse() []Command {
 
 	for p.cur.Type != token.EOF {
 		if p.cur.Type == token.COMMENT {
+			if p.comments {
+				cmds = append(cmds, Command{
+					Type:  token.COMMENT,
+					Args:  p.cur.Literal,
+					Start: p.cur,
+					End:   p.cur,
+					Raw:   literals([]token.Token{p.cur}),
+				})
+			}
 			p.nextToken()
 			continue
 		}
-		cmds = append(cmds, p.parseCommand()...)
+
+		start, errs := p.cur, len(p.errors)
+		p.consumed = []token.Token{p.cur}
+		parsed := p.parseCommand()
+
+		// Commands and errors of macros, blocks and sourced tapes already
+		// have the position of their own tokens.
+		for i := range parsed {
+			if parsed[i].Start.Type == "" {
+				parsed[i].Start, parsed[i].End = start, p.cur
+				parsed[i].Tokens = p.lineTokens(start)
+				parsed[i].Raw = literals(parsed[i].Tokens)
+			}
+		}
+		for i := errs; i < len(p.errors); i++ {
+			if p.errors[i].Start.Type == "" {
+				p.errors[i].Start, p.errors[i].End = start, p.cur
+			}
+		}
+		cmds = append(cmds, parsed...)
+
+		// Skip the rest of the line of an invalid command, so that a single
+		// mistake doesn't cascade into errors for each of its tokens.
+		if len(p.errors) > errs && !isBlockStart(start.Type) {
+			p.synchronize(start.Line)
+			continue
+		}
 		p.nextToken()
 	}
 
 	return cmds
 }
 
+// synchronize skips the tokens up to the first token after the given line.
+func (p *Parser) synchronize(line int) {
+	for p.cur.Type != token.EOF && p.cur.Line <= line {
+		p.nextToken()
+	}
+}
+
+// lineTokens returns the tokens consumed on the line of the start of a
+// command.
+func (p *Parser) lineTokens(start token.Token) []token.Token {
+	var tokens []token.Token
+	for _, t := range p.consumed {
+		if t.Line == start.Line && t.Type != token.COMMENT && t.Type != token.EOF {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// literals returns the literals of the tokens as they are written, e.g. with
+// the quotes of strings.
+func literals(tokens []token.Token) []string {
+	var raw []string
+	for _, t := range tokens {
+		if t.Raw == "" {
+			raw = append(raw, t.Literal)
+			continue
+		}
+		raw = append(raw, t.Raw)
+	}
+	return raw
+}
+
 // parseCommand parses a command.
 func (p *Parser) parseCommand() []Command {
 	switch p.cur.Type {
@@ -175,6 +445,8 @@ func (p *Parser) parseCommand() []Command {
 		return []Command{p.parseShow()}
 	case token.WAIT:
 		return []Command{p.parseWait()}
+	case token.EXPECT, token.EXPECT_NOT:
+		return []Command{p.parseExpect()}
 	case token.SOURCE:
 		return p.parseSource()
 	case token.SCREENSHOT:
@@ -185,8 +457,31 @@ func (p *Parser) parseCommand() []Command {
 		return []Command{p.parsePaste()}
 	case token.ENV:
 		return []Command{p.parseEnv()}
+	case token.LET:
+		return p.parseLet()
+	case token.DEFINE:
+		return p.parseDefine()
+	case token.CALL:
+		return p.parseCall()
+	case token.REPEAT:
+		return p.parseRepeatBlock()
+	case token.IF:
+		return []Command{p.parseIf()}
+	case token.END:
+		err := NewError(p.cur, "Unexpected End without a matching block")
+		err.Code = ErrUnmatchedBlock
+		err.Hint = "remove End or start a block with Define, Repeat or If"
+		p.errors = append(p.errors, err)
+		return []Command{{Type: token.ILLEGAL}}
+	case token.ELSE:
+		err := NewError(p.cur, "Unexpected Else without a matching If")
+		err.Code = ErrUnmatchedBlock
+		p.errors = append(p.errors, err)
+		return []Command{{Type: token.ILLEGAL}}
 	default:
-		p.errors = append(p.errors, NewError(p.cur, "Invalid command: "+p.cur.Literal))
+		err := NewError(p.cur, "Invalid command: "+p.cur.Literal)
+		err.Code = ErrInvalidCommand
+		p.errors = append(p.errors, withSuggestion(err, p.cur.Literal, keywords(isCommandKeyword)))
 		return []Command{{Type: token.ILLEGAL}}
 	}
 }
@@ -194,17 +489,11 @@ func (p *Parser) parseCommand() []Command {
 func (p *Parser) parseWait() Command {
 	cmd := Command{Type: token.WAIT}
 
-	if p.peek.Type == token.PLUS {
-		p.nextToken()
-		if p.peek.Type != token.STRING || (p.peek.Literal != "Line" && p.peek.Literal != "Screen") {
-			p.errors = append(p.errors, NewError(p.peek, "Wait+ expects Line or Screen"))
-			return cmd
-		}
-		cmd.Args = p.peek.Literal
-		p.nextToken()
-	} else {
-		cmd.Args = "Line"
+	scope, ok := p.parsePlusScope("Wait")
+	if !ok {
+		return cmd
 	}
+	cmd.Args = scope
 
 	cmd.Options = p.parseSpeed()
 	if cmd.Options != "" {
@@ -219,14 +508,78 @@ func (p *Parser) parseWait() Command {
 		// fallback to default
 		return cmd
 	}
-	p.nextToken()
-	if _, err := regexp.Compile(p.cur.Literal); err != nil {
-		p.errors = append(p.errors, NewError(p.cur, fmt.Sprintf("Invalid regular expression '%s': %v", p.cur.Literal, err)))
+	rx, ok := p.parseRegex()
+	if !ok {
 		return cmd
 	}
 
-	cmd.Args += " " + p.cur.Literal
+	cmd.Args += " " + rx
+
+	return cmd
+}
+
+// parsePlusScope parses the optional scope of a command checking the
+// terminal, i.e. +Line or +Screen. It defaults to Line.
+func (p *Parser) parsePlusScope(name string) (string, bool) {
+	if p.peek.Type != token.PLUS {
+		return "Line", true
+	}
+	p.nextToken()
+	if p.peek.Type != token.STRING || (p.peek.Literal != "Line" && p.peek.Literal != "Screen") {
+		p.errors = append(p.errors, NewError(p.peek, name+"+ expects Line or Screen"))
+		return "", false
+	}
+	p.nextToken()
+	return p.cur.Literal, true
+}
+
+// parseRegex parses the regular expression which follows, with its variables
+// interpolated, and reports whether it is valid.
+func (p *Parser) parseRegex() (string, bool) {
+	p.nextToken()
+	rx := p.interpolate(p.cur)
+	if _, err := regexp.Compile(rx); err != nil {
+		p.errors = append(p.errors, NewError(p.cur, fmt.Sprintf("Invalid regular expression '%s': %v", rx, err)))
+		return rx, false
+	}
+	return rx, true
+}
+
+// parseExpect parses an Expect or ExpectNot command, which asserts that a
+// regular expression matches, or doesn't match, the current line or the
+// screen. The scope is written like If, or like Wait.
+//
+//	Expect [Line|Screen] /<regexp>/
+//	ExpectNot+Screen /<regexp>/
+func (p *Parser) parseExpect() Command {
+	start := p.cur
+	cmd := Command{Type: CommandType(start.Type)}
+	name := start.Type.String()
+
+	if p.peek.Type == token.STRING && p.peek.Line == start.Line {
+		if p.peek.Literal != "Line" && p.peek.Literal != "Screen" {
+			p.errors = append(p.errors, NewError(p.peek, name+" expects Line or Screen"))
+			return cmd
+		}
+		p.nextToken()
+		cmd.Args = p.cur.Literal
+	} else {
+		scope, ok := p.parsePlusScope(name)
+		if !ok {
+			return cmd
+		}
+		cmd.Args = scope
+	}
 
+	if p.peek.Type != token.REGEX {
+		p.errors = append(p.errors, NewError(p.cur, name+" expects a regular expression"))
+		return cmd
+	}
+	rx, ok := p.parseRegex()
+	if !ok {
+		return cmd
+	}
+	cmd.Args += " " + rx
 	return cmd
 }
 
@@ -422,17 +775,18 @@ func (p *Parser) parseOutput() Command {
 		return cmd
 	}
 
-	ext := filepath.Ext(p.peek.Literal)
+	path := p.interpolate(p.peek)
+	ext := filepath.Ext(path)
 	if ext != "" {
 		cmd.Options = ext
 	} else {
 		cmd.Options = ".png"
-		if !strings.HasSuffix(p.peek.Literal, "/") {
+		if !strings.HasSuffix(path, "/") {
 			p.errors = append(p.errors, NewError(p.peek, "Expected folder with trailing slash"))
 		}
 	}
 
-	cmd.Args = p.peek.Literal
+	cmd.Args = path
 	p.nextToken()
 	return cmd
 }
@@ -447,7 +801,9 @@ func (p *Parser) parseSet() Command {
 	if token.IsSetting(p.peek.Type) {
 		cmd.Options = p.peek.Literal
 	} else {
-		p.errors = append(p.errors, NewError(p.peek, "Unknown setting: "+p.peek.Literal))
+		err := NewError(p.peek, "Unknown setting: "+p.peek.Literal)
+		err.Code = ErrUnknownSetting
+		p.errors = append(p.errors, withSuggestion(err, p.peek.Literal, keywords(token.IsSetting)))
 	}
 	p.nextToken()
 
@@ -455,10 +811,10 @@ func (p *Parser) parseSet() Command {
 	case token.WAIT_TIMEOUT:
 		cmd.Args = p.parseTime()
 	case token.WAIT_PATTERN:
-		cmd.Args = p.peek.Literal
-		_, err := regexp.Compile(p.peek.Literal)
+		cmd.Args = p.interpolate(p.peek)
+		_, err := regexp.Compile(cmd.Args)
 		if err != nil {
-			p.errors = append(p.errors, NewError(p.peek, "Invalid regexp pattern: "+p.peek.Literal))
+			p.errors = append(p.errors, NewError(p.peek, "Invalid regexp pattern: "+cmd.Args))
 		}
 		p.nextToken()
 	case token.LOOP_OFFSET:
@@ -513,7 +869,7 @@ func (p *Parser) parseSet() Command {
 				)
 			}
 		}
-	case token.CURSOR_BLINK:
+	case token.CURSOR_BLINK, token.WEBP_LOSSLESS:
 		cmd.Args = p.peek.Literal
 		p.nextToken()
 
@@ -524,8 +880,46 @@ func (p *Parser) parseSet() Command {
 			)
 		}
 
+	case token.CLOCK:
+		cmd.Args = p.peek.Literal
+		p.nextToken()
+
+		if p.cur.Literal != "real" && p.cur.Literal != "virtual" {
+			err := NewError(p.cur, "Clock must be real or virtual")
+			p.errors = append(p.errors, withSuggestion(err, p.cur.Literal, []string{"real", "virtual"}))
+		}
+
+	case token.BACKEND:
+		cmd.Args = p.peek.Literal
+		p.nextToken()
+
+		if p.cur.Literal != "browser" && p.cur.Literal != "native" {
+			err := NewError(p.cur, "Backend must be browser or native")
+			p.errors = append(p.errors, withSuggestion(err, p.cur.Literal, []string{"browser", "native"}))
+		}
+
+	case token.GIF_ENCODER:
+		cmd.Args = p.peek.Literal
+		p.nextToken()
+
+		if p.cur.Literal != "ffmpeg" && p.cur.Literal != "native" {
+			err := NewError(p.cur, "GIFEncoder must be ffmpeg or native")
+			p.errors = append(p.errors, withSuggestion(err, p.cur.Literal, []string{"ffmpeg", "native"}))
+		}
+
+	case token.GIF_DITHER:
+		cmd.Args = p.peek.Literal
+		p.nextToken()
+
+		if p.cur.Literal != "none" && p.cur.Literal != "floyd-steinberg" {
+			err := NewError(p.cur, "GIFDither must be none or floyd-steinberg")
+			p.errors = append(p.errors, withSuggestion(err, p.cur.Literal, []string{"none", "floyd-steinberg"}))
+		}
 	default:
 		cmd.Args = p.peek.Literal
+		if p.peek.Type == token.STRING {
+			cmd.Args = p.interpolate(p.peek)
+		}
 		p.nextToken()
 	}
 
@@ -558,9 +952,11 @@ func (p *Parser) parseRequire() Command {
 
 	if p.peek.Type != token.STRING {
 		p.errors = append(p.errors, NewError(p.peek, p.cur.Literal+" expects one string"))
+		cmd.Args = p.peek.Literal
+	} else {
+		cmd.Args = p.interpolate(p.peek)
 	}
 
-	cmd.Args = p.peek.Literal
 	p.nextToken()
 
 	return cmd
@@ -589,7 +985,7 @@ func (p *Parser) parseType() Command {
 
 	for p.peek.Type == token.STRING {
 		p.nextToken()
-		cmd.Args += p.cur.Literal
+		cmd.Args += p.interpolate(p.cur)
 
 		// If the next token is a string, add a space between them.
 		// Since tokens must be separated by a whitespace, this is most likely
@@ -619,7 +1015,7 @@ func (p *Parser) parseCopy() Command {
 	}
 	for p.peek.Type == token.STRING {
 		p.nextToken()
-		cmd.Args += p.cur.Literal
+		cmd.Args += p.interpolate(p.cur)
 
 		// If the next token is a string, add a space between them.
 		// Since tokens must be separated by a whitespace, this is most likely
@@ -657,20 +1053,438 @@ func (p *Parser) parseEnv() Command {
 
 	if p.peek.Type != token.STRING {
 		p.errors = append(p.errors, NewError(p.peek, p.cur.Literal+" expects string"))
+		cmd.Args = p.peek.Literal
+	} else {
+		cmd.Args = p.interpolate(p.peek)
 	}
 
-	cmd.Args = p.peek.Literal
 	p.nextToken()
 
 	return cmd
 }
 
+// parseLet parses a Let command.
+// A Let command defines a variable which can be referenced as ${name} in the
+// string arguments of the commands that follow it.
+//
+//	Let name "value"
+func (p *Parser) parseLet() []Command {
+	if !variableNameRegex.MatchString(p.peek.Literal) {
+		p.errors = append(p.errors, NewError(p.peek, "Expected variable name after Let, got "+p.peek.Literal))
+		p.nextToken()
+		// Skip the value as well so it isn't reported as an invalid command.
+		if p.peek.Type == token.STRING || p.peek.Type == token.NUMBER {
+			p.nextToken()
+		}
+		return nil
+	}
+	p.nextToken()
+	name := p.cur.Literal
+
+	if p.peek.Type != token.STRING && p.peek.Type != token.NUMBER {
+		p.errors = append(p.errors, NewError(p.peek, "Let expects a string value"))
+		return nil
+	}
+	p.nextToken()
+
+	if p.literal {
+		return []Command{{Type: token.LET, Options: name, Args: p.interpolate(p.cur)}}
+	}
+
+	// Variables passed to the parser (i.e. with --var) win over the tape, the
+	// value isn't interpolated since it is never used.
+	if _, ok := p.overrides[name]; ok {
+		return nil
+	}
+	p.vars[name] = p.interpolate(p.cur)
+	return nil
+}
+
+// parseDefine parses a Define block.
+// A Define block declares a macro with optional parameters. Its body is
+// expanded, with the parameters bound to the given arguments, by Call.
+//
+//	Define <name> [params...]
+//	  <commands...>
+//	End
+func (p *Parser) parseDefine() []Command {
+	def := p.cur
+
+	if p.nested {
+		p.errors = append(p.errors, NewError(def, "Define cannot be nested inside a block"))
+	}
+
+	if p.peek.Line != def.Line || p.peek.Type == token.EOF {
+		p.errors = append(p.errors, NewError(def, "Expected macro name after Define"))
+		p.skipBlock(def)
+		return nil
+	}
+	if !variableNameRegex.MatchString(p.peek.Literal) {
+		p.errors = append(p.errors, NewError(p.peek, "Invalid macro name: "+p.peek.Literal))
+		p.skipBlock(def)
+		return nil
+	}
+	p.nextToken()
+	m := &macro{name: p.cur.Literal, file: p.file}
+
+	// Parameters are the remaining words on the line of the Define.
+	for p.peek.Line == def.Line && p.peek.Type != token.EOF {
+		p.nextToken()
+		if !variableNameRegex.MatchString(p.cur.Literal) {
+			p.errors = append(p.errors, NewError(p.cur, "Invalid parameter name: "+p.cur.Literal))
+			continue
+		}
+		m.params = append(m.params, p.cur.Literal)
+	}
+
+	body, end, ok := p.readBlock(def)
+	if !ok {
+		return nil
+	}
+	m.body = body
+	m.end = end
+
+	if p.literal {
+		child := p.child(body, end)
+		cmd := Command{Type: token.DEFINE, Options: m.name, Args: strings.Join(m.params, " "), Body: child.Parse()}
+		p.errors = append(p.errors, child.errors...)
+		return []Command{cmd}
+	}
+
+	if _, ok := p.macros[m.name]; ok {
+		p.errors = append(p.errors, NewError(def, "Macro "+m.name+" is already defined"))
+		return nil
+	}
+	p.macros[m.name] = m
+	return nil
+}
+
+// parseCall parses a Call command and expands the called macro.
+//
+//	Call <name> [args...]
+func (p *Parser) parseCall() []Command {
+	call := p.cur
+
+	if p.peek.Line != call.Line || p.peek.Type == token.EOF {
+		p.errors = append(p.errors, NewError(call, "Expected macro name after Call"))
+		return nil
+	}
+	p.nextToken()
+	name := p.cur
+
+	// Arguments are the remaining strings and numbers on the line of the Call.
+	var args []string
+	for p.peek.Line == call.Line && (p.peek.Type == token.STRING || p.peek.Type == token.NUMBER) {
+		p.nextToken()
+		args = append(args, p.interpolate(p.cur))
+	}
+
+	if p.literal {
+		return []Command{{Type: token.CALL, Options: name.Literal, Args: strings.Join(args, " ")}}
+	}
+
+	m, ok := p.macros[name.Literal]
+	if !ok {
+		err := NewError(name, "Undefined macro: "+name.Literal)
+		err.Code = ErrUndefinedMacro
+		p.errors = append(p.errors, withSuggestion(err, name.Literal, slices.Sorted(maps.Keys(p.macros))))
+		return nil
+	}
+	if slices.Contains(p.calls, m.name) {
+		p.errors = append(p.errors, NewError(name, "Recursive call to macro "+m.name))
+		return nil
+	}
+	if len(args) != len(m.params) {
+		p.errors = append(p.errors, NewError(name, fmt.Sprintf("Macro %s expects %d argument(s), got %d", m.name, len(m.params), len(args))))
+		return nil
+	}
+
+	// The body is parsed in the scope of the caller, with the parameters bound
+	// to the arguments. Errors point into the body of the macro.
+	child := p.child(m.body, m.end)
+	child.calls = append(slices.Clone(p.calls), m.name)
+	child.file = m.file
+	for i, param := range m.params {
+		delete(child.overrides, param)
+		child.vars[param] = args[i]
+	}
+
+	cmds := child.Parse()
+	if m.source != "" {
+		// The macro is defined in a sourced tape.
+		for i := range child.errors {
+			if child.errors[i].File == "" {
+				child.errors[i].File = m.file
+			}
+		}
+		setSource(cmds, m.source)
+	}
+	p.errors = append(p.errors, child.errors...)
+	return cmds
+}
+
+// parseRepeatBlock parses a Repeat block.
+// A Repeat block expands its body the given number of times. The index of the
+// current iteration, starting at 1, is available as ${i} or as the variable
+// with the given name.
+//
+//	Repeat <count> [variable]
+//	  <commands...>
+//	End
+func (p *Parser) parseRepeatBlock() []Command {
+	start := p.cur
+
+	if p.peek.Type != token.NUMBER || p.peek.Line != start.Line {
+		p.errors = append(p.errors, NewError(start, "Repeat expects a count"))
+		p.skipBlock(start)
+		return nil
+	}
+	p.nextToken()
+	count, err := strconv.Atoi(p.cur.Literal)
+	if err != nil || count < 0 {
+		p.errors = append(p.errors, NewError(p.cur, "Invalid repeat count: "+p.cur.Literal))
+		p.skipBlock(start)
+		return nil
+	}
+
+	cmd := Command{Type: token.REPEAT, Options: p.cur.Literal}
+	name := "i"
+	if p.peek.Line == start.Line && p.peek.Type != token.EOF {
+		p.nextToken()
+		name = p.cur.Literal
+		cmd.Args = name
+		if !variableNameRegex.MatchString(name) {
+			p.errors = append(p.errors, NewError(p.cur, "Invalid variable name: "+name))
+		}
+	}
+
+	body, end, ok := p.readBlock(start)
+	if !ok {
+		return nil
+	}
+
+	if p.literal {
+		child := p.child(body, end)
+		cmd.Body = child.Parse()
+		p.errors = append(p.errors, child.errors...)
+		return []Command{cmd}
+	}
+
+	var cmds []Command
+	for i := 1; i <= count; i++ {
+		child := p.child(body, end)
+		delete(child.overrides, name)
+		child.vars[name] = strconv.Itoa(i)
+
+		cmds = append(cmds, child.Parse()...)
+
+		// Every iteration would report the same errors.
+		if len(child.errors) > 0 {
+			p.errors = append(p.errors, child.errors...)
+			break
+		}
+	}
+
+	return cmds
+}
+
+// parseIf parses an If block.
+// An If block executes its commands if the regular expression matches the
+// current line (default) or the whole screen at the time it is evaluated, and
+// the commands after Else otherwise.
+//
+//	If [Line|Screen] /<regexp>/
+//	  <commands...>
+//	[Else
+//	  <commands...>]
+//	End
+func (p *Parser) parseIf() Command {
+	start := p.cur
+	cmd := Command{Type: token.IF, Args: "Line"}
+
+	if p.peek.Type == token.STRING && p.peek.Line == start.Line {
+		if p.peek.Literal != "Line" && p.peek.Literal != "Screen" {
+			p.errors = append(p.errors, NewError(p.peek, "If expects Line or Screen"))
+		}
+		cmd.Args = p.peek.Literal
+		p.nextToken()
+	}
+
+	if p.peek.Type != token.REGEX {
+		p.errors = append(p.errors, NewError(p.cur, "If expects a regular expression"))
+		p.skipBlock(start)
+		return cmd
+	}
+	rx, _ := p.parseRegex()
+	cmd.Args += " " + rx
+
+	body, end, ok := p.readBlock(start)
+	if !ok {
+		return cmd
+	}
+
+	// Split the body on the Else which belongs to this block, if any. Any
+	// further Else of this block is reported and dropped.
+	then, otherwise := body, []token.Token(nil)
+	elseTok := end
+	depth := 0
+	for i, t := range body {
+		switch {
+		case isBlockStart(t.Type):
+			depth++
+		case t.Type == token.END:
+			depth--
+		case t.Type == token.ELSE && depth == 0:
+			if elseTok != end {
+				p.errors = append(p.errors, NewError(t, "Unexpected Else, If already has an Else"))
+				continue
+			}
+			then, elseTok = body[:i], t
+			otherwise = []token.Token{}
+			continue
+		}
+		if elseTok != end {
+			otherwise = append(otherwise, t)
+		}
+	}
+
+	child := p.child(then, elseTok)
+	cmd.Body = child.Parse()
+	p.errors = append(p.errors, child.errors...)
+
+	if elseTok != end {
+		child = p.child(otherwise, end)
+		cmd.Else = child.Parse()
+		p.errors = append(p.errors, child.errors...)
+	}
+
+	return cmd
+}
+
+// child returns a parser for the given tokens, e.g. the body of a block,
+// which shares the variables and macros defined so far. The end token is the
+// token closing the block.
+func (p *Parser) child(tokens []token.Token, end token.Token) *Parser {
+	eof := token.Token{Type: token.EOF, Line: end.Line, Column: end.Column}
+	child := &Parser{
+		l:         &tokenReplay{tokens: tokens, eof: eof},
+		errors:    []Error{},
+		vars:      maps.Clone(p.vars),
+		overrides: maps.Clone(p.overrides),
+		macros:    p.macros,
+		calls:     p.calls,
+		nested:    true,
+		file:      p.file,
+		sources:   p.sources,
+		comments:  p.comments,
+		literal:   p.literal,
+	}
+
+	// Read two tokens, so cur and peek are both set.
+	child.nextToken()
+	child.nextToken()
+
+	return child
+}
+
+// readBlock reads the tokens of a block up to its matching End, which is
+// consumed. Nested blocks are included in the returned tokens.
+func (p *Parser) readBlock(start token.Token) ([]token.Token, token.Token, bool) {
+	var body []token.Token
+	depth := 0
+	for {
+		p.nextToken()
+		switch {
+		case p.cur.Type == token.EOF:
+			err := NewError(start, start.Literal+" is missing a matching End")
+			err.Code = ErrUnmatchedBlock
+			err.Hint = "add End after the last command of the block"
+			p.errors = append(p.errors, err)
+			return nil, p.cur, false
+		case p.cur.Type == token.END && depth == 0:
+			return body, p.cur, true
+		case p.cur.Type == token.END:
+			depth--
+		case isBlockStart(p.cur.Type):
+			depth++
+		}
+		body = append(body, p.cur)
+	}
+}
+
+// skipBlock skips to the End of a block which could not be parsed, so its
+// body isn't reported as a set of errors.
+func (p *Parser) skipBlock(start token.Token) {
+	_, _, _ = p.readBlock(start)
+}
+
+// isBlockStart returns whether the token opens a block which is closed by End.
+func isBlockStart(t token.Type) bool {
+	return t == token.DEFINE || t == token.REPEAT || t == token.IF
+}
+
+var (
+	variableNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	variableRefRegex  = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+// References returns the names of the variables referenced as ${name} in the
+// given string, ignoring the escaped $${name} references.
+func References(s string) []string {
+	var names []string
+	for _, m := range variableRefRegex.FindAllStringSubmatch(s, -1) {
+		if !strings.HasPrefix(m[0], "$$") {
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// interpolate returns the literal of the given token with every ${name}
+// reference replaced by the value of the variable. References to undefined
+// variables are reported as errors. A reference can be escaped as $${name}.
+// Without expansion, the literal is returned as it is.
+func (p *Parser) interpolate(t token.Token) string {
+	if p.literal {
+		return t.Literal
+	}
+	return variableRefRegex.ReplaceAllStringFunc(t.Literal, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+
+		name := ref[2 : len(ref)-1]
+		if v, ok := p.overrides[name]; ok {
+			return v
+		}
+		if v, ok := p.vars[name]; ok {
+			return v
+		}
+
+		err := NewError(t, "Undefined variable: "+name)
+		err.Code = ErrUndefinedVariable
+		names := slices.Sorted(maps.Keys(p.vars))
+		names = append(names, slices.Sorted(maps.Keys(p.overrides))...)
+		err = withSuggestion(err, name, names)
+		if err.Hint != "" {
+			err.Hint = "did you mean ${" + err.Fix + "}?"
+			err.Fix = ""
+		} else {
+			err.Hint = "define it with Let " + name + " \"<value>\" or pass --var " + name + "=<value>"
+		}
+		p.errors = append(p.errors, err)
+		return ref
+	})
+}
+
 // parseSource parses source command.
-// Source command takes a tape path to include in current tape.
+// Source command takes a tape path to include in current tape, relative to the
+// including tape, and optional variables to pass to the sourced tape.
 //
-//	Source <path>
+//	Source <path> [key=value...]
 func (p *Parser) parseSource() []Command {
 	cmd := Command{Type: token.SOURCE}
+	src := p.cur
 
 	if p.peek.Type != token.STRING {
 		p.errors = append(p.errors, NewError(p.cur, "Expected path after Source"))
@@ -678,30 +1492,54 @@ func (p *Parser) parseSource() []Command {
 		return []Command{cmd}
 	}
 
-	srcPath := p.peek.Literal
+	p.nextToken()
+	pathToken := p.cur
+	srcPath := p.interpolate(pathToken)
+	params, pairs, ok := p.parseSourceParams(src)
+	if !ok {
+		return []Command{cmd}
+	}
+
+	if p.literal {
+		cmd.Args = strings.Join(append([]string{srcPath}, pairs...), " ")
+		return []Command{cmd}
+	}
 
 	// Check if path has .tape extension
 	ext := filepath.Ext(srcPath)
 	if ext != ".tape" {
-		p.errors = append(p.errors, NewError(p.peek, "Expected file with .tape extension"))
-		p.nextToken()
+		p.errors = append(p.errors, NewError(pathToken, "Expected file with .tape extension"))
 		return []Command{cmd}
 	}
 
 	// Check if tape exist
-	if _, err := os.Stat(srcPath); os.IsNotExist(err) { //nolint:gosec
+	path := p.resolve(srcPath)
+	if _, err := os.Stat(path); os.IsNotExist(err) { //nolint:gosec
 		notFoundErr := fmt.Sprintf("File %s not found", srcPath)
-		p.errors = append(p.errors, NewError(p.peek, notFoundErr))
-		p.nextToken()
+		p.errors = append(p.errors, NewError(pathToken, notFoundErr))
+		return []Command{cmd}
+	}
+
+	// Check the tape isn't already being sourced
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	if i := slices.Index(p.sources, abs); i >= 0 {
+		chain := make([]string, 0, len(p.sources)-i+1)
+		for _, s := range p.sources[i:] {
+			chain = append(chain, filepath.Base(s))
+		}
+		chain = append(chain, filepath.Base(abs))
+		circularErr := "Circular Source detected: " + strings.Join(chain, " -> ")
+		p.errors = append(p.errors, NewError(pathToken, circularErr))
 		return []Command{cmd}
 	}
 
-	// Check if source tape contains nested Source command
-	d, err := os.ReadFile(srcPath) //nolint:gosec
+	d, err := os.ReadFile(path) //nolint:gosec
 	if err != nil {
 		readErr := fmt.Sprintf("Unable to read file: %s", srcPath)
-		p.errors = append(p.errors, NewError(p.peek, readErr))
-		p.nextToken()
+		p.errors = append(p.errors, NewError(pathToken, readErr))
 		return []Command{cmd}
 	}
 
@@ -709,44 +1547,109 @@ func (p *Parser) parseSource() []Command {
 	// Check source tape is NOT empty
 	if len(srcTape) == 0 {
 		readErr := fmt.Sprintf("Source tape: %s is empty", srcPath)
-		p.errors = append(p.errors, NewError(p.peek, readErr))
-		p.nextToken()
+		p.errors = append(p.errors, NewError(pathToken, readErr))
 		return []Command{cmd}
 	}
 
+	// The sourced tape sees the variables defined so far, the parameters
+	// passed to Source take precedence over them.
+	overrides := maps.Clone(p.overrides)
+	if overrides == nil {
+		overrides = map[string]string{}
+	}
+	maps.Copy(overrides, params)
+
+	// The sourced tape shares the macros of the including tape, and the
+	// variables it defines are visible to it afterwards.
 	srcLexer := lexer.New(srcTape)
-	srcParser := New(srcLexer)
+	srcParser := New(srcLexer, WithVars(overrides), WithFile(path))
+	maps.Copy(srcParser.vars, p.vars)
+	srcParser.macros = p.macros
+	srcParser.sources = append(slices.Clone(p.sources), abs)
 
-	// Check not nested source
 	srcCmds := srcParser.Parse()
-	for _, cmd := range srcCmds {
-		if cmd.Type == token.SOURCE {
-			p.errors = append(p.errors, NewError(p.peek, "Nested Source detected"))
-			p.nextToken()
-			return []Command{cmd}
+	// The outputs of the sourced tape are dropped, so that it doesn't
+	// overwrite the output of the including tape.
+	srcCmds = slices.DeleteFunc(srcCmds, func(c Command) bool { return c.Type == token.OUTPUT })
+	maps.Copy(p.vars, srcParser.vars)
+	for _, m := range p.macros {
+		if m.file == path && m.source == "" {
+			m.source = srcPath
 		}
 	}
+	for _, err := range srcParser.Errors() {
+		if err.File == "" {
+			err.File = path
+		}
+		p.errors = append(p.errors, err)
+	}
 
-	// Check src errors
-	srcErrors := srcParser.Errors()
-	if len(srcErrors) > 0 {
-		p.errors = append(p.errors, NewError(p.peek, fmt.Sprintf("%s has %d errors", srcPath, len(srcErrors))))
+	setSource(srcCmds, srcPath)
+	return srcCmds
+}
+
+// parseSourceParams parses the key=value pairs following the path of a Source
+// command on the same line. The pairs are also returned in the order they are
+// written.
+func (p *Parser) parseSourceParams(src token.Token) (map[string]string, []string, bool) {
+	params := map[string]string{}
+	var pairs []string
+	for p.peek.Line == src.Line && p.peek.Type != token.EOF {
 		p.nextToken()
-		return []Command{cmd}
+		key := p.cur
+		if key.Type != token.STRING || p.peek.Type != token.EQUAL {
+			p.errors = append(p.errors, NewError(key, "Expected key=value after Source path"))
+			p.skipLine(src)
+			return nil, nil, false
+		}
+		if !variableNameRegex.MatchString(key.Literal) {
+			p.errors = append(p.errors, NewError(key, "Invalid variable name: "+key.Literal))
+			p.skipLine(src)
+			return nil, nil, false
+		}
+		p.nextToken()
+		if p.peek.Line != src.Line || (p.peek.Type != token.STRING && p.peek.Type != token.NUMBER) {
+			p.errors = append(p.errors, NewError(p.cur, "Expected value after "+key.Literal+"="))
+			p.skipLine(src)
+			return nil, nil, false
+		}
+		p.nextToken()
+		params[key.Literal] = p.interpolate(p.cur)
+		pairs = append(pairs, key.Literal+"="+params[key.Literal])
 	}
+	return params, pairs, true
+}
 
-	filtered := make([]Command, 0)
-	for _, srcCmd := range srcCmds {
-		// Output have to be avoid in order to not overwrite output of the original tape.
-		if srcCmd.Type == token.SOURCE ||
-			srcCmd.Type == token.OUTPUT {
-			continue
-		}
-		filtered = append(filtered, srcCmd)
+// skipLine skips the remaining tokens on the line of the given token.
+func (p *Parser) skipLine(t token.Token) {
+	for p.peek.Line == t.Line && p.peek.Type != token.EOF {
+		p.nextToken()
 	}
+}
 
-	p.nextToken()
-	return filtered
+// resolve returns the path of a sourced tape relative to the directory of the
+// including tape, falling back to the working directory.
+func (p *Parser) resolve(path string) string {
+	if p.file == "" || filepath.IsAbs(path) {
+		return path
+	}
+	rel := filepath.Join(filepath.Dir(p.file), path)
+	if _, err := os.Stat(rel); err == nil {
+		return rel
+	}
+	return path
+}
+
+// setSource marks the commands, and the commands of their blocks, as coming
+// from the given sourced tape.
+func setSource(cmds []Command, src string) {
+	for i := range cmds {
+		if cmds[i].Source == "" {
+			cmds[i].Source = src
+		}
+		setSource(cmds[i].Body, src)
+		setSource(cmds[i].Else, src)
+	}
 }
 
 // parseScreenshot parses screenshot command.
@@ -762,7 +1665,7 @@ func (p *Parser) parseScreenshot() Command {
 		return cmd
 	}
 
-	path := p.peek.Literal
+	path := p.interpolate(p.peek)
 
 	// Check if path has .png extension
 	ext := filepath.Ext(path)
@@ -788,6 +1691,7 @@ func (p *Parser) Errors() []Error {
 func (p *Parser) nextToken() {
 	p.cur = p.peek
 	p.peek = p.l.NextToken()
+	p.consumed = append(p.consumed, p.cur)
 }
 
 // Check if a given windowbar type is valid.
@@ -1,7 +1,10 @@
 package parser
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -103,7 +106,6 @@ Sleep Bar`
 		" 2:6  │ Type expects string",
 		" 4:1  │ Invalid command: Foo",
 		" 5:1  │ Expected time after Sleep",
-		" 5:7  │ Invalid command: Bar",
 	}
 
 	if len(p.errors) != len(expectedErrors) {
@@ -422,14 +424,24 @@ func TestParseSource(t *testing.T) {
 		test.run(t)
 	})
 
-	t.Run("should return error when find nested Source commands", func(t *testing.T) {
+	t.Run("should return errors of nested Source commands", func(t *testing.T) {
 		test := &parseSourceTest{
 			tape: "Source source.tape",
 			srcTape: `Type "echo 'Welcome to VHS!'"
 	Source magic.tape
 	Type "goodbye"
 	`,
-			errors:    []string{"Nested Source detected"},
+			errors:    []string{"File magic.tape not found"},
+			writeFile: true,
+		}
+
+		test.run(t)
+	})
+
+	t.Run("should return error when Source parameter is malformed", func(t *testing.T) {
+		test := &parseSourceTest{
+			tape:      "Source source.tape name",
+			errors:    []string{"Expected key=value after Source path"},
 			writeFile: true,
 		}
 
@@ -437,6 +449,109 @@ func TestParseSource(t *testing.T) {
 	})
 }
 
+func TestParseSourceNested(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.tape":          "Source lib/setup.tape name=world count=2\nType \"done\"",
+		"lib/setup.tape":     "Source common.tape\nType \"hello ${name}\"\nOutput out.gif",
+		"lib/common.tape":    "Type \"x${count}\"",
+		"cycle/a.tape":       "Source b.tape",
+		"cycle/b.tape":       "Source a.tape",
+		"broken/main.tape":   "Source bad.tape",
+		"broken/bad.tape":    "Type \"ok\"\nSleep",
+		"override/main.tape": "Let name \"tape\"\nSource ../lib/setup.tape",
+		"macros/main.tape":   "Define greet who\n  Type \"hi ${who}\"\nEnd\nSource lib.tape\nCall hello\nType \"${greeting}\"",
+		"macros/lib.tape":    "Let greeting \"hey\"\nDefine hello\n  Call greet \"lib\"\n  Sleep\nEnd",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	parse := func(t *testing.T, name string) ([]Command, []Error) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := New(lexer.New(string(b)), WithFile(path))
+		cmds := p.Parse()
+		return cmds, p.Errors()
+	}
+
+	t.Run("resolves paths relative to the including tape", func(t *testing.T) {
+		cmds, errs := parse(t, "main.tape")
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		expected := []Command{
+			{Type: token.TYPE, Args: "x2", Source: "common.tape"},
+			{Type: token.TYPE, Args: "hello world", Source: "lib/setup.tape"},
+			{Type: token.TYPE, Args: "done"},
+		}
+		if !reflect.DeepEqual(withoutPositions(cmds), expected) {
+			t.Fatalf("expected %v, got %v", expected, cmds)
+		}
+	})
+
+	t.Run("detects circular sources", func(t *testing.T) {
+		_, errs := parse(t, "cycle/a.tape")
+		if len(errs) != 1 {
+			t.Fatalf("expected 1 error, got %v", errs)
+		}
+		if errs[0].Msg != "Circular Source detected: a.tape -> b.tape -> a.tape" {
+			t.Errorf("unexpected error: %s", errs[0].Msg)
+		}
+		if filepath.Base(errs[0].File) != "b.tape" {
+			t.Errorf("expected error in b.tape, got %q", errs[0].File)
+		}
+	})
+
+	t.Run("reports errors with the sourced file", func(t *testing.T) {
+		_, errs := parse(t, "broken/main.tape")
+		if len(errs) != 1 {
+			t.Fatalf("expected 1 error, got %v", errs)
+		}
+		if errs[0].File != filepath.Join(dir, "broken", "bad.tape") || errs[0].Token.Line != 2 {
+			t.Errorf("unexpected error location: %s", errs[0])
+		}
+	})
+
+	t.Run("shares macros and variables with the sourced tape", func(t *testing.T) {
+		cmds, errs := parse(t, "macros/main.tape")
+		if len(errs) != 1 || errs[0].Msg != "Expected time after Sleep" {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		if errs[0].File != filepath.Join(dir, "macros", "lib.tape") || errs[0].Token.Line != 4 {
+			t.Errorf("expected the error in the body of the macro, got %s", errs[0])
+		}
+		expected := []Command{
+			{Type: token.TYPE, Args: "hi lib", Source: "lib.tape"},
+			{Type: token.SLEEP, Source: "lib.tape"},
+			{Type: token.TYPE, Args: "hey"},
+		}
+		if !reflect.DeepEqual(withoutPositions(cmds), expected) {
+			t.Fatalf("expected %v, got %v", expected, cmds)
+		}
+	})
+
+	t.Run("passes variables of the including tape", func(t *testing.T) {
+		cmds, errs := parse(t, "override/main.tape")
+		if len(errs) != 1 || errs[0].Msg != "Undefined variable: count" {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+		if cmds[1].Args != "hello tape" {
+			t.Errorf("expected variable of the including tape, got %q", cmds[1].Args)
+		}
+	})
+}
+
 type parseScreenshotTest struct {
 	tape   string
 	errors []string
@@ -481,3 +596,610 @@ func TestParseScreeenshot(t *testing.T) {
 		test.run(t)
 	})
 }
+
+func TestParseClock(t *testing.T) {
+	p := New(lexer.New("Set Clock virtual\nSet Clock real\nSet Clock fake\nSet Clock virtaul"))
+	cmds := p.Parse()
+
+	expected := []Command{
+		{Type: token.SET, Options: "Clock", Args: "virtual"},
+		{Type: token.SET, Options: "Clock", Args: "real"},
+		{Type: token.SET, Options: "Clock", Args: "fake"},
+		{Type: token.SET, Options: "Clock", Args: "virtaul"},
+	}
+	if got := withoutPositions(cmds); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	errs := p.Errors()
+	if len(errs) != 2 || errs[0].Msg != "Clock must be real or virtual" || errs[1].Fix != "virtual" {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestParseBackend(t *testing.T) {
+	p := New(lexer.New("Set Backend native\nSet Backend browser\nSet Backend nativ"))
+	cmds := p.Parse()
+
+	expected := []Command{
+		{Type: token.SET, Options: "Backend", Args: "native"},
+		{Type: token.SET, Options: "Backend", Args: "browser"},
+		{Type: token.SET, Options: "Backend", Args: "nativ"},
+	}
+	if got := withoutPositions(cmds); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	errs := p.Errors()
+	if len(errs) != 1 || errs[0].Msg != "Backend must be browser or native" || errs[0].Fix != "native" {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestParseGIFSettings(t *testing.T) {
+	p := New(lexer.New("Set GIFEncoder native\nSet GIFDither floyd-steinberg\nSet MaxColors 64\nSet GIFEncoder ffmpg\nSet GIFDither bayer"))
+	cmds := p.Parse()
+
+	expected := []Command{
+		{Type: token.SET, Options: "GIFEncoder", Args: "native"},
+		{Type: token.SET, Options: "GIFDither", Args: "floyd-steinberg"},
+		{Type: token.SET, Options: "MaxColors", Args: "64"},
+		{Type: token.SET, Options: "GIFEncoder", Args: "ffmpg"},
+		{Type: token.SET, Options: "GIFDither", Args: "bayer"},
+	}
+	if got := withoutPositions(cmds); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	errs := p.Errors()
+	if len(errs) != 2 || errs[0].Fix != "ffmpeg" || errs[1].Msg != "GIFDither must be none or floyd-steinberg" {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestParseLet(t *testing.T) {
+	input := `
+Let bin "./demo"
+Let version 1
+Let out "${bin}-v${version}"
+Output "${out}.gif"
+Type "${bin} --version"
+Type "echo $${HOME}"
+Wait /${bin}/
+Screenshot "${out}.png"
+Require "${bin}"
+Set FontFamily "${bin}"`
+
+	expected := []Command{
+		{Type: token.OUTPUT, Options: ".gif", Args: "./demo-v1.gif"},
+		{Type: token.TYPE, Options: "", Args: "./demo --version"},
+		{Type: token.TYPE, Options: "", Args: "echo ${HOME}"},
+		{Type: token.WAIT, Options: "", Args: "Line ./demo"},
+		{Type: token.SCREENSHOT, Options: "", Args: "./demo-v1.png"},
+		{Type: token.REQUIRE, Options: "", Args: "./demo"},
+		{Type: token.SET, Options: "FontFamily", Args: "./demo"},
+	}
+
+	l := lexer.New(input)
+	p := New(l)
+
+	cmds := p.Parse()
+
+	if len(p.errors) > 0 {
+		t.Fatalf("Expected no errors, got %v", p.errors)
+	}
+
+	if len(cmds) != len(expected) {
+		t.Fatalf("Expected %d commands, got %d; %v", len(expected), len(cmds), cmds)
+	}
+
+	for i, cmd := range withoutPositions(cmds) {
+		if !reflect.DeepEqual(cmd, expected[i]) {
+			t.Errorf("Expected command %d to be %v, got %v", i, expected[i], cmd)
+		}
+	}
+}
+
+func TestParseLetOverrides(t *testing.T) {
+	input := `
+Let version "${major}.0.0"
+Type "v${version}"`
+
+	l := lexer.New(input)
+	p := New(l, WithVars(map[string]string{"version": "1.2.0"}))
+
+	cmds := p.Parse()
+
+	if len(p.errors) > 0 {
+		t.Fatalf("Expected no errors, got %v", p.errors)
+	}
+
+	if len(cmds) != 1 || cmds[0].Args != "v1.2.0" {
+		t.Fatalf("Expected overridden variable, got %v", cmds)
+	}
+}
+
+func TestParseLetErrors(t *testing.T) {
+	input := `
+Type "${missing}"
+Let my-var "foo"
+Let name`
+
+	l := lexer.New(input)
+	p := New(l)
+
+	_ = p.Parse()
+
+	expectedErrors := []string{
+		" 2:6  │ Undefined variable: missing",
+		" 3:5  │ Expected variable name after Let, got my-var",
+		" 4:9  │ Let expects a string value",
+	}
+
+	if len(p.errors) != len(expectedErrors) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expectedErrors), len(p.errors), p.errors)
+	}
+
+	for i, err := range p.errors {
+		if err.String() != expectedErrors[i] {
+			t.Errorf("Expected error %d to be [%s], got (%s)", i, expectedErrors[i], err)
+		}
+	}
+}
+
+func TestParseMacro(t *testing.T) {
+	input := `
+Define open file
+  Type "vim ${file}"
+  Enter
+End
+
+Define greet greeting who
+  Type "${greeting}, ${who}!"
+End
+
+Call open "main.go"
+Call greet "Hello" "World"`
+
+	expected := []Command{
+		{Type: token.TYPE, Options: "", Args: "vim main.go"},
+		{Type: token.ENTER, Options: "", Args: "1"},
+		{Type: token.TYPE, Options: "", Args: "Hello, World!"},
+	}
+
+	l := lexer.New(input)
+	p := New(l)
+
+	cmds := p.Parse()
+
+	if len(p.errors) > 0 {
+		t.Fatalf("Expected no errors, got %v", p.errors)
+	}
+
+	if len(cmds) != len(expected) {
+		t.Fatalf("Expected %d commands, got %d; %v", len(expected), len(cmds), cmds)
+	}
+
+	for i, cmd := range withoutPositions(cmds) {
+		if !reflect.DeepEqual(cmd, expected[i]) {
+			t.Errorf("Expected command %d to be %v, got %v", i, expected[i], cmd)
+		}
+	}
+}
+
+func TestParseMacroErrors(t *testing.T) {
+	input := `
+Define sleepy
+  Sleep Foo
+End
+Call sleepy
+Call sleepy 1
+Call missing
+Define loop
+  Call loop
+End
+Call loop
+End
+Define unterminated
+  Enter`
+
+	l := lexer.New(input)
+	p := New(l)
+
+	_ = p.Parse()
+
+	expectedErrors := []string{
+		" 3:3  │ Expected time after Sleep",
+		" 6:6  │ Macro sleepy expects 0 argument(s), got 1",
+		" 7:6  │ Undefined macro: missing",
+		" 9:8  │ Recursive call to macro loop",
+		"12:1  │ Unexpected End without a matching block",
+		"13:1  │ Define is missing a matching End",
+	}
+
+	if len(p.errors) != len(expectedErrors) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expectedErrors), len(p.errors), p.errors)
+	}
+
+	for i, err := range p.errors {
+		if err.String() != expectedErrors[i] {
+			t.Errorf("Expected error %d to be [%s], got (%s)", i, expectedErrors[i], err)
+		}
+	}
+}
+
+func TestParseRepeatBlock(t *testing.T) {
+	input := `
+Repeat 2
+  Down 3
+  Screenshot "item-${i}.png"
+End
+Repeat 2 row
+  Repeat 2 col
+    Type "${row}x${col}"
+  End
+End
+Repeat 0
+  Enter
+End`
+
+	expected := []Command{
+		{Type: token.DOWN, Options: "", Args: "3"},
+		{Type: token.SCREENSHOT, Options: "", Args: "item-1.png"},
+		{Type: token.DOWN, Options: "", Args: "3"},
+		{Type: token.SCREENSHOT, Options: "", Args: "item-2.png"},
+		{Type: token.TYPE, Options: "", Args: "1x1"},
+		{Type: token.TYPE, Options: "", Args: "1x2"},
+		{Type: token.TYPE, Options: "", Args: "2x1"},
+		{Type: token.TYPE, Options: "", Args: "2x2"},
+	}
+
+	l := lexer.New(input)
+	p := New(l)
+
+	cmds := p.Parse()
+
+	if len(p.errors) > 0 {
+		t.Fatalf("Expected no errors, got %v", p.errors)
+	}
+
+	if len(cmds) != len(expected) {
+		t.Fatalf("Expected %d commands, got %d; %v", len(expected), len(cmds), cmds)
+	}
+
+	for i, cmd := range withoutPositions(cmds) {
+		if !reflect.DeepEqual(cmd, expected[i]) {
+			t.Errorf("Expected command %d to be %v, got %v", i, expected[i], cmd)
+		}
+	}
+}
+
+func TestParseRepeatBlockErrors(t *testing.T) {
+	input := `
+Repeat
+  Enter
+End
+Repeat 3
+  Sleep Foo
+End
+Repeat 2
+  Define nested
+  End
+End
+Repeat 2
+  Enter`
+
+	l := lexer.New(input)
+	p := New(l)
+
+	_ = p.Parse()
+
+	expectedErrors := []string{
+		" 2:1  │ Repeat expects a count",
+		" 6:3  │ Expected time after Sleep",
+		" 9:3  │ Define cannot be nested inside a block",
+		"12:1  │ Repeat is missing a matching End",
+	}
+
+	if len(p.errors) != len(expectedErrors) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expectedErrors), len(p.errors), p.errors)
+	}
+
+	for i, err := range p.errors {
+		if err.String() != expectedErrors[i] {
+			t.Errorf("Expected error %d to be [%s], got (%s)", i, expectedErrors[i], err)
+		}
+	}
+}
+
+func TestParseIf(t *testing.T) {
+	input := `
+If Screen /accept license\?/
+  Type "y"
+  Enter
+Else
+  If /\$$/
+    Type "ls"
+  End
+End
+If /foo/
+  Sleep 1
+End`
+
+	expected := []Command{
+		{
+			Type: token.IF,
+			Args: `Screen accept license\?`,
+			Body: []Command{
+				{Type: token.TYPE, Args: "y"},
+				{Type: token.ENTER, Args: "1"},
+			},
+			Else: []Command{
+				{
+					Type: token.IF,
+					Args: `Line \$$`,
+					Body: []Command{{Type: token.TYPE, Args: "ls"}},
+				},
+			},
+		},
+		{
+			Type: token.IF,
+			Args: "Line foo",
+			Body: []Command{{Type: token.SLEEP, Args: "1s"}},
+		},
+	}
+
+	l := lexer.New(input)
+	p := New(l)
+
+	cmds := p.Parse()
+
+	if len(p.errors) > 0 {
+		t.Fatalf("Expected no errors, got %v", p.errors)
+	}
+
+	if !reflect.DeepEqual(withoutPositions(cmds), expected) {
+		t.Fatalf("Expected %v, got %v", expected, cmds)
+	}
+}
+
+func TestParseIfErrors(t *testing.T) {
+	input := `
+If Foo /bar/
+End
+If
+  Enter
+End
+If /foo/
+Else
+Else
+End
+Else
+If /foo/
+  Enter`
+
+	l := lexer.New(input)
+	p := New(l)
+
+	_ = p.Parse()
+
+	expectedErrors := []string{
+		" 2:4  │ If expects Line or Screen",
+		" 4:1  │ If expects a regular expression",
+		" 9:1  │ Unexpected Else, If already has an Else",
+		"11:1  │ Unexpected Else without a matching If",
+		"12:1  │ If is missing a matching End",
+	}
+
+	if len(p.errors) != len(expectedErrors) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expectedErrors), len(p.errors), p.errors)
+	}
+
+	for i, err := range p.errors {
+		if err.String() != expectedErrors[i] {
+			t.Errorf("Expected error %d to be [%s], got (%s)", i, expectedErrors[i], err)
+		}
+	}
+}
+
+func TestParseExpect(t *testing.T) {
+	input := `
+Expect /\$ $/
+Expect Screen /Done/
+ExpectNot+Screen /[Ee]rror/
+Let word "hello"
+ExpectNot Line /${word}/
+Expect
+Expect Foo /bar/
+ExpectNot /(/`
+
+	l := lexer.New(input)
+	p := New(l)
+	cmds := p.Parse()
+
+	expected := []Command{
+		{Type: token.EXPECT, Args: `Line \$ $`},
+		{Type: token.EXPECT, Args: "Screen Done"},
+		{Type: token.EXPECT_NOT, Args: "Screen [Ee]rror"},
+		{Type: token.EXPECT_NOT, Args: "Line hello"},
+		{Type: token.EXPECT, Args: "Line"},
+		{Type: token.EXPECT},
+		{Type: token.EXPECT_NOT, Args: "Line"},
+	}
+	if !reflect.DeepEqual(withoutPositions(cmds), expected) {
+		t.Errorf("Expected %v, got %v", expected, withoutPositions(cmds))
+	}
+
+	expectedErrors := []string{
+		" 7:1  │ Expect expects a regular expression",
+		" 8:8  │ Expect expects Line or Screen",
+		" 9:11 │ Invalid regular expression '(': error parsing regexp: missing closing ): `(`",
+	}
+	if len(p.errors) != len(expectedErrors) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expectedErrors), len(p.errors), p.errors)
+	}
+	for i, err := range p.errors {
+		if err.String() != expectedErrors[i] {
+			t.Errorf("Expected error %d to be [%s], got (%s)", i, expectedErrors[i], err)
+		}
+	}
+}
+
+// withoutPositions clears the positions and raw tokens of commands, so they
+// can be compared with the expected commands.
+func withoutPositions(cmds []Command) []Command {
+	if cmds == nil {
+		return nil
+	}
+	stripped := make([]Command, len(cmds))
+	for i, cmd := range cmds {
+		cmd.Start, cmd.End, cmd.Raw, cmd.Tokens = token.Token{}, token.Token{}, nil, nil
+		cmd.Body = withoutPositions(cmd.Body)
+		cmd.Else = withoutPositions(cmd.Else)
+		stripped[i] = cmd
+	}
+	return stripped
+}
+
+func TestParsePositions(t *testing.T) {
+	input := `# greet
+Let name "world"
+Type@10ms "hello ${name}" # inline
+If /ready/
+  Enter 2
+End`
+
+	p := New(lexer.New(input), WithComments())
+	cmds := p.Parse()
+	if len(p.errors) > 0 {
+		t.Fatalf("Expected no errors, got %v", p.errors)
+	}
+
+	type position struct {
+		Type       CommandType
+		Start, End string
+		Raw        []string
+	}
+	var got []position
+	for _, cmd := range cmds {
+		got = append(got, position{
+			Type:  cmd.Type,
+			Start: fmt.Sprintf("%d:%d", cmd.Start.Line, cmd.Start.Column),
+			End:   fmt.Sprintf("%d:%d", cmd.End.Line, cmd.End.Column),
+			Raw:   cmd.Raw,
+		})
+	}
+	expected := []position{
+		{Type: token.COMMENT, Start: "1:1", End: "1:1", Raw: []string{"# greet"}},
+		{Type: token.TYPE, Start: "3:1", End: "3:11", Raw: []string{"Type", "@", "10", "ms", `"hello ${name}"`}},
+		{Type: token.COMMENT, Start: "3:27", End: "3:27", Raw: []string{"# inline"}},
+		{Type: token.IF, Start: "4:1", End: "6:1", Raw: []string{"If", "/ready/"}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	enter := cmds[3].Body[0]
+	if enter.Start.Line != 5 || enter.Start.Column != 3 || !reflect.DeepEqual(enter.Raw, []string{"Enter", "2"}) {
+		t.Errorf("unexpected position of nested command: %v %v", enter.Start, enter.Raw)
+	}
+}
+
+func TestParseWithoutExpansion(t *testing.T) {
+	input := `Let name 'world'
+Define greet who
+  Type "hello ${who}"
+End
+Repeat 2 n
+  Call greet "${name} ${n}"
+End
+Source "other.tape" who=` + "`you`"
+
+	p := New(lexer.New(input), WithoutExpansion())
+	cmds := p.Parse()
+	if len(p.errors) > 0 {
+		t.Fatalf("Expected no errors, got %v", p.errors)
+	}
+
+	expected := []Command{
+		{Type: token.LET, Options: "name", Args: "world"},
+		{Type: token.DEFINE, Options: "greet", Args: "who", Body: []Command{
+			{Type: token.TYPE, Args: "hello ${who}"},
+		}},
+		{Type: token.REPEAT, Options: "2", Args: "n", Body: []Command{
+			{Type: token.CALL, Options: "greet", Args: "${name} ${n}"},
+		}},
+		{Type: token.SOURCE, Args: "other.tape who=you"},
+	}
+	if got := withoutPositions(cmds); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	raw := [][]string{
+		{"Let", "name", "'world'"},
+		{"Define", "greet", "who"},
+		{"Repeat", "2", "n"},
+		{"Source", `"other.tape"`, "who", "=", "`you`"},
+	}
+	for i, cmd := range cmds {
+		if !reflect.DeepEqual(cmd.Raw, raw[i]) {
+			t.Errorf("expected raw %q, got %q", raw[i], cmd.Raw)
+		}
+	}
+	if call := cmds[2].Body[0]; !reflect.DeepEqual(call.Raw, []string{"Call", "greet", `"${name} ${n}"`}) {
+		t.Errorf("unexpected raw of nested command: %q", call.Raw)
+	}
+}
+
+func TestParseErrorPositions(t *testing.T) {
+	p := New(lexer.New("Sleep 1\nSet Foo 10"))
+	_ = p.Parse()
+	if len(p.errors) == 0 {
+		t.Fatal("Expected errors")
+	}
+	err := p.errors[0]
+	if err.Token.Column != 5 || err.Start.Column != 1 || err.End.Column != 9 {
+		t.Errorf("unexpected command span of error: %v - %v", err.Start, err.End)
+	}
+}
+
+func TestParseErrorRecovery(t *testing.T) {
+	input := `Tpye "hello" Enter
+Set FontSzie 20
+Sleep Bar Baz
+Let name "world"
+Type "${nmae}"
+Define greet
+  Enter
+End
+Call gret
+Foo
+End`
+
+	p := New(lexer.New(input))
+	_ = p.Parse()
+
+	type result struct {
+		Msg  string
+		Code ErrorCode
+		Hint string
+		Fix  string
+	}
+	expected := []result{
+		{"Invalid command: Tpye", ErrInvalidCommand, "did you mean Type?", "Type"},
+		{"Unknown setting: FontSzie", ErrUnknownSetting, "did you mean FontSize?", "FontSize"},
+		{"Expected time after Sleep", ErrSyntax, "", ""},
+		{"Undefined variable: nmae", ErrUndefinedVariable, "did you mean ${name}?", ""},
+		{"Undefined macro: gret", ErrUndefinedMacro, "did you mean greet?", "greet"},
+		{"Invalid command: Foo", ErrInvalidCommand, "", ""},
+		{"Unexpected End without a matching block", ErrUnmatchedBlock, "remove End or start a block with Define, Repeat or If", ""},
+	}
+
+	var got []result
+	for _, err := range p.Errors() {
+		got = append(got, result{err.Msg, err.Code, err.Hint, err.Fix})
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected errors:\n%v\ngot:\n%v", expected, got)
+	}
+}
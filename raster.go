@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// cell is a character of the terminal with its attributes, as rasterized.
+type cell struct {
+	Char      rune
+	FG, BG    color.Color
+	Bold      bool
+	Underline bool
+}
+
+// rasterizer draws a grid of cells with a monospace font, the way xterm.js
+// draws its text and cursor layers.
+type rasterizer struct {
+	regular, bold font.Face
+	// size is the size of a cell in pixels, and ascent the distance from the
+	// top of a cell to the baseline of its character.
+	size   image.Point
+	ascent int
+	theme  Theme
+}
+
+// newRasterizer returns a rasterizer for the terminal options. Characters are
+// drawn with Go Mono, whatever the font family.
+func newRasterizer(opts TerminalOptions) (*rasterizer, error) {
+	faceOpts := &opentype.FaceOptions{Size: float64(opts.FontSize), DPI: 72, Hinting: font.HintingFull} //nolint:mnd
+	regular, err := newFace(gomono.TTF, faceOpts)
+	if err != nil {
+		return nil, err
+	}
+	bold, err := newFace(gomonobold.TTF, faceOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like xterm.js, the letter spacing is added to the width of the cells,
+	// and the line height multiplies their height.
+	advance, _ := regular.GlyphAdvance('M')
+	metrics := regular.Metrics()
+	height := float64(metrics.Height.Ceil()) * opts.LineHeight
+	return &rasterizer{
+		regular: regular,
+		bold:    bold,
+		size: image.Pt(
+			advance.Ceil()+int(math.Round(opts.LetterSpacing)),
+			int(math.Round(height)),
+		),
+		ascent: metrics.Ascent.Ceil() + int(height-float64(metrics.Height.Ceil()))/doublingFactor,
+		theme:  opts.Theme,
+	}, nil
+}
+
+// newFace returns a face of the TrueType font.
+func newFace(ttf []byte, opts *opentype.FaceOptions) (font.Face, error) {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse font: %w", err)
+	}
+	face, err := opentype.NewFace(f, opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not load font: %w", err)
+	}
+	return face, nil
+}
+
+// grid returns the number of columns and rows of cells which fit in the
+// viewport.
+func (r *rasterizer) grid(viewport image.Point) (int, int) {
+	return max(viewport.X/r.size.X, 1), max(viewport.Y/r.size.Y, 1)
+}
+
+// text draws the cells, given by row, on the background of the theme.
+func (r *rasterizer) text(cells [][]cell) *image.RGBA {
+	img := image.NewRGBA(r.bounds(cells))
+	draw.Draw(img, img.Bounds(), image.NewUniform(r.color(r.theme.Background)), image.Point{}, draw.Src)
+	for y, row := range cells {
+		for x, c := range row {
+			r.drawCell(img, image.Pt(x, y), c)
+		}
+	}
+	return img
+}
+
+// cursor draws a block cursor over the cell at the given position on a
+// transparent layer of the size of the cells.
+func (r *rasterizer) cursor(cells [][]cell, pos image.Point, visible bool) *image.RGBA {
+	img := image.NewRGBA(r.bounds(cells))
+	if !visible || pos.Y < 0 || pos.Y >= len(cells) || pos.X < 0 || pos.X >= len(cells[pos.Y]) {
+		return img
+	}
+	c := cells[pos.Y][pos.X]
+	c.FG, c.BG = r.color(r.theme.CursorAccent), r.color(r.theme.Cursor)
+	r.drawCell(img, pos, c)
+	return img
+}
+
+// bounds returns the bounds of an image of the cells.
+func (r *rasterizer) bounds(cells [][]cell) image.Rectangle {
+	cols := 0
+	if len(cells) > 0 {
+		cols = len(cells[0])
+	}
+	return image.Rect(0, 0, cols*r.size.X, len(cells)*r.size.Y)
+}
+
+// drawCell draws the background and character of the cell at the position in
+// the grid.
+func (r *rasterizer) drawCell(img draw.Image, pos image.Point, c cell) {
+	origin := image.Pt(pos.X*r.size.X, pos.Y*r.size.Y)
+	rect := image.Rectangle{Min: origin, Max: origin.Add(r.size)}
+	if c.BG != nil {
+		draw.Draw(img, rect, image.NewUniform(c.BG), image.Point{}, draw.Src)
+	}
+	if c.Underline {
+		y := origin.Y + r.ascent + 1
+		draw.Draw(img, image.Rect(rect.Min.X, y, rect.Max.X, y+1), image.NewUniform(c.FG), image.Point{}, draw.Src)
+	}
+	if c.Char == 0 || c.Char == ' ' {
+		return
+	}
+
+	face := r.regular
+	if c.Bold {
+		face = r.bold
+	}
+	d := font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(c.FG),
+		Face: face,
+		Dot:  fixed.P(origin.X, origin.Y+r.ascent),
+	}
+	d.DrawString(string(c.Char))
+}
+
+// color returns the color of a hex string of the theme, or black if it is
+// invalid.
+func (r *rasterizer) color(hex string) color.Color {
+	return themeColor(hex)
+}
+
+// palette returns the color of the palette of the theme at the index.
+func (r *rasterizer) palette(i int) color.Color {
+	return themePalette(r.theme, i)
+}
+
+// themeColor returns the color of a hex string of a theme, or black if it is
+// invalid.
+func themeColor(hex string) color.Color {
+	c, _ := parseHexColor(hex)
+	return c
+}
+
+// ansiColor returns the color of the theme for the ANSI color index between 0
+// and 15.
+func ansiColor(t Theme, i int) color.Color {
+	colors := [...]string{
+		t.Black, t.Red, t.Green, t.Yellow, t.Blue, t.Magenta, t.Cyan, t.White,
+		t.BrightBlack, t.BrightRed, t.BrightGreen, t.BrightYellow,
+		t.BrightBlue, t.BrightMagenta, t.BrightCyan, t.BrightWhite,
+	}
+	return themeColor(colors[i])
+}
+
+// themePalette returns the color of the xterm 256 color palette at the index:
+// the ANSI colors of the theme, a 6x6x6 color cube and a grayscale ramp.
+//
+//nolint:mnd
+func themePalette(t Theme, i int) color.Color {
+	switch {
+	case i < 16:
+		return ansiColor(t, i)
+	case i < 232:
+		i -= 16
+		level := func(v int) uint8 {
+			if v == 0 {
+				return 0
+			}
+			return uint8(55 + v*40)
+		}
+		return color.RGBA{level(i / 36), level(i / 6 % 6), level(i % 6), 0xFF}
+	default:
+		v := uint8(8 + (i-232)*10)
+		return color.RGBA{v, v, v, 0xFF}
+	}
+}
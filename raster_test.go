@@ -0,0 +1,70 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestRasterizer(t *testing.T) {
+	r, err := newRasterizer(TerminalOptions{FontSize: 20, LineHeight: 1, Theme: DefaultTheme})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cols, rows := r.grid(image.Pt(10*r.size.X+3, 4*r.size.Y))
+	if cols != 10 || rows != 4 {
+		t.Fatalf("expected 10x4 grid, got %dx%d", cols, rows)
+	}
+
+	red := r.palette(1)
+	cells := make([][]cell, rows)
+	for y := range cells {
+		cells[y] = make([]cell, cols)
+	}
+	cells[0][0] = cell{Char: ' ', BG: red}
+
+	text := r.text(cells)
+	if got := text.Bounds().Size(); got != image.Pt(cols*r.size.X, rows*r.size.Y) {
+		t.Fatalf("unexpected text size %v", got)
+	}
+	if got := text.At(1, 1); got != color.RGBAModel.Convert(red) {
+		t.Errorf("expected cell background %v, got %v", red, got)
+	}
+	if got, want := text.At(r.size.X+1, 1), color.RGBAModel.Convert(r.color(DefaultTheme.Background)); got != want {
+		t.Errorf("expected theme background %v, got %v", want, got)
+	}
+
+	cursor := r.cursor(cells, image.Pt(2, 1), true)
+	if got := cursor.Bounds(); got != text.Bounds() {
+		t.Fatalf("expected cursor layer of %v, got %v", text.Bounds(), got)
+	}
+	if got := cursor.At(2*r.size.X+1, r.size.Y+1); got != color.RGBAModel.Convert(r.color(DefaultTheme.Cursor)) {
+		t.Errorf("expected cursor at (2, 1), got %v", got)
+	}
+	if _, _, _, a := cursor.At(1, 1).RGBA(); a != 0 {
+		t.Errorf("expected transparent cursor layer, got alpha %d", a)
+	}
+	if _, _, _, a := r.cursor(cells, image.Pt(2, 1), false).At(2*r.size.X+1, r.size.Y+1).RGBA(); a != 0 {
+		t.Errorf("expected hidden cursor, got alpha %d", a)
+	}
+}
+
+func TestRasterizerPalette(t *testing.T) {
+	r := &rasterizer{theme: DefaultTheme}
+	tests := []struct {
+		index int
+		want  color.Color
+	}{
+		{16, color.RGBA{0, 0, 0, 0xFF}},
+		{196, color.RGBA{0xFF, 0, 0, 0xFF}},
+		{231, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}},
+		{232, color.RGBA{8, 8, 8, 0xFF}},
+		{255, color.RGBA{0xEE, 0xEE, 0xEE, 0xFF}},
+	}
+	for _, tc := range tests {
+		if got := r.palette(tc.index); got != tc.want {
+			t.Errorf("palette(%d): expected %v, got %v", tc.index, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/vhs/parser"
+	"github.com/spf13/cobra"
+)
+
+// Formats of the test reports.
+const (
+	reportJUnit = "junit"
+	reportJSON  = "json"
+)
+
+// testStep is a Wait, Expect or ExpectNot command evaluated by a tape, which
+// is reported as a test case of its own.
+type testStep struct {
+	command  parser.Command
+	duration time.Duration
+	// failure is the reason the step failed, empty if it passed, and
+	// snapshot the last value of the line or the screen it checked.
+	failure  string
+	snapshot string
+}
+
+// stepRecorder records the steps of a tape.
+type stepRecorder struct {
+	steps []testStep
+}
+
+// stepRecorderKey is the key of the step recorder of a context.
+type stepRecorderKey struct{}
+
+// withStepRecorder returns a context in which the evaluated tapes record their
+// steps with the recorder.
+func withStepRecorder(ctx context.Context, r *stepRecorder) context.Context {
+	return context.WithValue(ctx, stepRecorderKey{}, r)
+}
+
+// stepRecorderFrom returns the step recorder of the context, if any.
+func stepRecorderFrom(ctx context.Context) *stepRecorder {
+	r, _ := ctx.Value(stepRecorderKey{}).(*stepRecorder)
+	return r
+}
+
+// recordStep records a step of the tape, if its steps are recorded.
+func (v *VHS) recordStep(c parser.Command, start time.Time, failure, snapshot string) {
+	if v.steps == nil {
+		return
+	}
+	v.steps.steps = append(v.steps.steps, testStep{
+		command:  c,
+		duration: time.Since(start),
+		failure:  failure,
+		snapshot: snapshot,
+	})
+}
+
+// reportTape is a tape in a test report.
+type reportTape struct {
+	File     string  `json:"file"`
+	Duration float64 `json:"duration"`
+	Passed   bool    `json:"passed"`
+	// Failure is the reason the tape failed, and Details the diff of its text
+	// output or the errors printed.
+	Failure   string       `json:"failure,omitempty"`
+	Details   string       `json:"details,omitempty"`
+	Artifacts []string     `json:"artifacts,omitempty"`
+	Steps     []reportStep `json:"steps,omitempty"`
+}
+
+// reportStep is a step of a tape in a test report.
+type reportStep struct {
+	Name     string  `json:"name"`
+	File     string  `json:"file"`
+	Line     int     `json:"line"`
+	Duration float64 `json:"duration"`
+	Passed   bool    `json:"passed"`
+	Failure  string  `json:"failure,omitempty"`
+	Snapshot string  `json:"snapshot,omitempty"`
+}
+
+// newReportTape returns the report of a tape run as a test.
+func newReportTape(r testResult) reportTape {
+	tape := reportTape{
+		File:      r.file,
+		Duration:  r.duration.Seconds(),
+		Passed:    r.passed(),
+		Artifacts: r.artifacts,
+	}
+
+	var details []string
+	if r.diff != "" {
+		tape.Failure = "text output differs from " + r.golden
+		details = append(details, r.diff)
+	}
+	for _, err := range r.errs {
+		var (
+			expectation ExpectationError
+			syntax      InvalidSyntaxError
+		)
+		switch {
+		case errors.As(err, &expectation):
+			for _, e := range expectation.Errors {
+				details = append(details, e.String())
+			}
+		case errors.As(err, &syntax):
+			for _, e := range syntax.Errors {
+				details = append(details, e.String())
+			}
+		default:
+			details = append(details, err.Error())
+		}
+		if tape.Failure == "" {
+			tape.Failure = strings.SplitN(err.Error(), "\n", 2)[0] //nolint:mnd
+		}
+	}
+	tape.Details = strings.Join(details, "\n")
+
+	for _, s := range r.steps {
+		tape.Steps = append(tape.Steps, reportStep{
+			Name:     stepName(s.command),
+			File:     cmp.Or(s.command.Source, r.file),
+			Line:     s.command.Start.Line,
+			Duration: s.duration.Seconds(),
+			Passed:   s.failure == "",
+			Failure:  s.failure,
+			Snapshot: s.snapshot,
+		})
+	}
+	return tape
+}
+
+// outputArtifacts returns the files written by rendering a tape: its videos,
+// its text output and its screenshots.
+func outputArtifacts(v *VHS) []string {
+	o := v.Options.Video.Output
+	var artifacts []string
+	for _, path := range []string{o.GIF, o.WebM, o.MP4, o.WebP, o.APNG, o.SVG, o.Cast, o.HTML, o.Frames, v.Options.Test.Output} {
+		if path != "" {
+			artifacts = append(artifacts, path)
+		}
+	}
+	return append(artifacts, screenshotArtifacts(v)...)
+}
+
+// screenshotArtifacts returns the paths of the screenshots of a tape.
+func screenshotArtifacts(v *VHS) []string {
+	paths := make([]string, 0, len(v.Options.Screenshot.screenshots))
+	for path := range v.Options.Screenshot.screenshots {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// visualArtifacts returns the actual and diff images of the screenshots which
+// differ from their reference images.
+func visualArtifacts(errs []error) []string {
+	var artifacts []string
+	for _, err := range errs {
+		var visual VisualError
+		if !errors.As(err, &visual) {
+			continue
+		}
+		for _, m := range visual.Mismatches {
+			for _, path := range []string{m.Actual, m.Diff} {
+				if path != "" {
+					artifacts = append(artifacts, path)
+				}
+			}
+		}
+	}
+	return artifacts
+}
+
+// stepName returns the name of a step, its command with its scope and regular
+// expression, e.g. Expect Screen /Done/.
+func stepName(c parser.Command) string {
+	scope, rx, ok := strings.Cut(c.Args, " ")
+	if !ok {
+		return strings.TrimSpace(c.Type.String() + " " + scope)
+	}
+	return fmt.Sprintf("%s %s /%s/", c.Type, scope, rx)
+}
+
+// flagReports returns the reports set with the --report flag of the command,
+// by format.
+func flagReports(cmd *cobra.Command) (map[string]string, error) {
+	flags, err := cmd.Flags().GetStringArray("report")
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+	return parseReportFlags(flags)
+}
+
+// parseReportFlags parses the format=path pairs of the --report flag.
+func parseReportFlags(flags []string) (map[string]string, error) {
+	reports := make(map[string]string, len(flags))
+	for _, flag := range flags {
+		format, path, ok := strings.Cut(flag, "=")
+		if !ok || path == "" {
+			return nil, fmt.Errorf("invalid report %q, expected format=path", flag)
+		}
+		if format != reportJUnit && format != reportJSON {
+			return nil, fmt.Errorf("unknown report format %q, expected %s or %s", format, reportJUnit, reportJSON)
+		}
+		reports[format] = path
+	}
+	return reports, nil
+}
+
+// writeReports writes the reports of the tapes in each format to its path.
+func writeReports(reports map[string]string, tapes []reportTape) error {
+	for format, path := range reports {
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			return fmt.Errorf("failed to create report directory: %w", err)
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to create report: %w", err)
+		}
+		if format == reportJUnit {
+			err = writeJUnitReport(f, tapes)
+		} else {
+			err = writeJSONReport(f, tapes)
+		}
+		if err != nil {
+			_ = f.Close()
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// writeJSONReport writes the report of the tapes as JSON.
+func writeJSONReport(w io.Writer, tapes []reportTape) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct { //nolint:wrapcheck
+		Tapes []reportTape `json:"tapes"`
+	}{tapes})
+}
+
+// junitTestSuites is the root element of a JUnit XML report.
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     float64          `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite is the test suite of a tape, with a test case for the tape
+// and one for each of its steps.
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     float64         `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+// junitTestCase is a test case of a JUnit XML report, with its artifacts
+// attached in its standard output.
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Line      int           `xml:"line,attr,omitempty"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+// junitFailure is the failure of a test case.
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnitReport writes the report of the tapes as JUnit XML.
+func writeJUnitReport(w io.Writer, tapes []reportTape) error {
+	var report junitTestSuites
+	for _, tape := range tapes {
+		tapeCase := junitTestCase{Name: tape.File, Classname: tape.File, File: tape.File, Time: tape.Duration}
+		if !tape.Passed {
+			tapeCase.Failure = &junitFailure{Message: tape.Failure, Text: tape.Details}
+		}
+		var attachments strings.Builder
+		for _, artifact := range tape.Artifacts {
+			_, _ = fmt.Fprintf(&attachments, "[[ATTACHMENT|%s]]\n", artifact)
+		}
+		tapeCase.SystemOut = attachments.String()
+
+		suite := junitTestSuite{Name: tape.File, Time: tape.Duration, Cases: []junitTestCase{tapeCase}}
+		for _, step := range tape.Steps {
+			stepCase := junitTestCase{
+				Name:      fmt.Sprintf("%d: %s", step.Line, step.Name),
+				Classname: tape.File,
+				File:      step.File,
+				Line:      step.Line,
+				Time:      step.Duration,
+			}
+			if !step.Passed {
+				stepCase.Failure = &junitFailure{Message: step.Failure, Text: step.Snapshot}
+			}
+			suite.Cases = append(suite.Cases, stepCase)
+		}
+		for _, c := range suite.Cases {
+			suite.Tests++
+			if c.Failure != nil {
+				suite.Failures++
+			}
+		}
+
+		report.Suites = append(report.Suites, suite)
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Time += suite.Time
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err //nolint:wrapcheck
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err //nolint:wrapcheck
+	}
+	_, err := io.WriteString(w, "\n")
+	return err //nolint:wrapcheck
+}
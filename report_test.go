@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/vhs/parser"
+	"github.com/charmbracelet/vhs/token"
+)
+
+func TestParseReportFlags(t *testing.T) {
+	reports, err := parseReportFlags([]string{"junit=out/report.xml", "json=report.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reports[reportJUnit] != "out/report.xml" || reports[reportJSON] != "report.json" {
+		t.Errorf("unexpected reports %v", reports)
+	}
+
+	for _, flag := range []string{"junit", "junit=", "tap=report.tap"} {
+		if _, err := parseReportFlags([]string{flag}); err == nil {
+			t.Errorf("expected an error for %q", flag)
+		}
+	}
+}
+
+func testReportTapes() []reportTape {
+	expect := parser.Command{
+		Type:   token.EXPECT,
+		Args:   "Screen Done",
+		Source: "demo.tape",
+		Start:  token.Token{Line: 4},
+	}
+	wait := parser.Command{Type: token.WAIT, Args: "Line", Start: token.Token{Line: 3}}
+
+	passed := testResult{
+		file:      "ok.tape",
+		golden:    "ok.txt",
+		duration:  time.Second,
+		artifacts: []string{"ok.txt"},
+		steps:     []testStep{{command: wait, duration: 500 * time.Millisecond}},
+	}
+	failed := testResult{
+		file:     "demo.tape",
+		golden:   "demo.txt",
+		duration: 2 * time.Second,
+		diff:     "--- demo.txt\n+++ demo.txt (actual)\n",
+		errs: []error{
+			ExpectationError{Errors: []parser.Error{{Token: expect.Start, Msg: "Screen doesn't match /Done/", File: "demo.tape"}}},
+			errors.New("timeout waiting for \"Line\" to match <>"),
+		},
+		artifacts: []string{"demo.txt", "demo.actual.png"},
+		steps: []testStep{
+			{command: wait, duration: time.Second},
+			{command: expect, failure: "Screen doesn't match /Done/", snapshot: "$ echo <ok>"},
+		},
+	}
+	return []reportTape{newReportTape(passed), newReportTape(failed)}
+}
+
+func TestNewReportTape(t *testing.T) {
+	tapes := testReportTapes()
+	if !tapes[0].Passed || tapes[0].Failure != "" || tapes[0].Details != "" {
+		t.Errorf("expected the first tape to pass, got %+v", tapes[0])
+	}
+
+	tape := tapes[1]
+	if tape.Passed || tape.Failure != "text output differs from demo.txt" {
+		t.Errorf("unexpected failure %q", tape.Failure)
+	}
+	for _, want := range []string{"+++ demo.txt (actual)", "demo.tape:4", "Screen doesn't match /Done/", "timeout waiting"} {
+		if !strings.Contains(tape.Details, want) {
+			t.Errorf("expected the details to contain %q, got:\n%s", want, tape.Details)
+		}
+	}
+	if len(tape.Steps) != 2 || tape.Steps[0].Name != "Wait Line" || tape.Steps[1].Name != "Expect Screen /Done/" {
+		t.Fatalf("unexpected steps %+v", tape.Steps)
+	}
+	if name := stepName(parser.Command{Type: token.WAIT}); name != "Wait" {
+		t.Errorf("expected a bare Wait to be named Wait, got %q", name)
+	}
+	if step := tape.Steps[1]; step.Passed || step.Line != 4 || step.Snapshot != "$ echo <ok>" {
+		t.Errorf("unexpected step %+v", step)
+	}
+}
+
+func TestWriteJUnitReport(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeJUnitReport(&buf, testReportTapes()); err != nil {
+		t.Fatal(err)
+	}
+
+	var report junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("invalid XML: %v\n%s", err, buf.String())
+	}
+	if report.Tests != 5 || report.Failures != 2 || report.Time != 3 {
+		t.Errorf("unexpected totals: %d tests, %d failures in %gs", report.Tests, report.Failures, report.Time)
+	}
+	if len(report.Suites) != 2 {
+		t.Fatalf("expected a suite per tape, got %d", len(report.Suites))
+	}
+
+	suite := report.Suites[1]
+	if suite.Name != "demo.tape" || suite.Tests != 3 || suite.Failures != 2 {
+		t.Errorf("unexpected suite %s: %d tests, %d failures", suite.Name, suite.Tests, suite.Failures)
+	}
+	tapeCase := suite.Cases[0]
+	if tapeCase.Failure == nil || !strings.Contains(tapeCase.Failure.Text, "Screen doesn't match /Done/") {
+		t.Errorf("unexpected tape failure %+v", tapeCase.Failure)
+	}
+	if !strings.Contains(tapeCase.SystemOut, "[[ATTACHMENT|demo.actual.png]]") {
+		t.Errorf("expected the artifacts to be attached, got %q", tapeCase.SystemOut)
+	}
+	stepCase := suite.Cases[2]
+	if stepCase.Name != "4: Expect Screen /Done/" || stepCase.Line != 4 {
+		t.Errorf("unexpected step case %s at line %d", stepCase.Name, stepCase.Line)
+	}
+	if stepCase.Failure == nil || stepCase.Failure.Text != "$ echo <ok>" {
+		t.Errorf("expected the snapshot in the step failure, got %+v", stepCase.Failure)
+	}
+	if suite.Cases[1].File != "demo.tape" || suite.Cases[1].Failure != nil {
+		t.Errorf("unexpected passed step case %+v", suite.Cases[1])
+	}
+}
+
+func TestWriteJSONReport(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeJSONReport(&buf, testReportTapes()); err != nil {
+		t.Fatal(err)
+	}
+
+	var report struct {
+		Tapes []reportTape `json:"tapes"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	if len(report.Tapes) != 2 || report.Tapes[1].Duration != 2 || len(report.Tapes[1].Steps) != 2 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if !strings.Contains(buf.String(), `"snapshot": "$ echo <ok>"`) {
+		t.Errorf("expected the snapshot in the report, got:\n%s", buf.String())
+	}
+}
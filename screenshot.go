@@ -1,9 +1,18 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"image"
+	"image/color"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
 )
 
 // ScreenshotOptions holds options related with screenshots.
@@ -99,3 +108,157 @@ func (opts *ScreenshotOptions) buildFFopts(targetFile, textStream, cursorStream
 
 	return args
 }
+
+// screenshotMode is what rendering does with the screenshots of a tape.
+type screenshotMode int
+
+// Modes of the screenshots.
+const (
+	// screenshotWrite writes the screenshots, the default.
+	screenshotWrite screenshotMode = iota
+	// screenshotSkip doesn't write the screenshots, when vhs test only
+	// compares the text output.
+	screenshotSkip
+	// screenshotCompare compares the screenshots against the reference
+	// images at their paths.
+	screenshotCompare
+	// screenshotUpdate writes the screenshots as the reference images.
+	screenshotUpdate
+)
+
+// pixelThreshold is the difference of a channel past which a pixel of a
+// screenshot differs from its reference, so that slight differences of
+// anti-aliasing don't count.
+const pixelThreshold = 16
+
+// composeScreenshot returns the screenshot of the frame with the given number,
+// its text and cursor layers drawn with the style, like MakeScreenshots does
+// with ffmpeg.
+func (opts *ScreenshotOptions) composeScreenshot(styler *frameStyler, frame int) (*image.RGBA, error) {
+	text, err := readFrame(filepath.Join(opts.input, fmt.Sprintf(textFrameFormat, frame)))
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := readFrame(filepath.Join(opts.input, fmt.Sprintf(cursorFrameFormat, frame)))
+	if err != nil {
+		return nil, err
+	}
+	return styler.Draw(newCapturedFrame(text, cursor).image), nil
+}
+
+// CompareScreenshots compares the screenshots against the reference images at
+// their paths, allowing the tolerated fraction of their pixels to differ, or
+// writes them as the reference images when updating. The screenshots which
+// differ are written next to their reference as <name>.actual.png, along with
+// an image of their differences as <name>.diff.png.
+func CompareScreenshots(opts ScreenshotOptions, tolerance float64, update bool) error {
+	styler, err := newFrameStyler(*opts.style)
+	if err != nil {
+		return err
+	}
+
+	paths := slices.Sorted(maps.Keys(opts.screenshots))
+	var mismatches []ScreenshotMismatch
+	for _, path := range paths {
+		img, err := opts.composeScreenshot(styler, opts.screenshots[path])
+		if err != nil {
+			return err
+		}
+
+		// The images of a previous mismatch are stale.
+		mismatch := ScreenshotMismatch{
+			Path:   path,
+			Actual: strings.TrimSuffix(path, ".png") + ".actual.png",
+			Diff:   strings.TrimSuffix(path, ".png") + ".diff.png",
+		}
+		_ = os.Remove(mismatch.Actual)
+		_ = os.Remove(mismatch.Diff)
+
+		if update {
+			log.Println(GrayStyle.Render("Updating " + path + "..."))
+			if err := writeImage(path, img); err != nil {
+				return err
+			}
+			continue
+		}
+
+		reference, err := readFrame(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			mismatch.Diff = ""
+			mismatch.Msg = "reference image does not exist, run vhs test --update to create it"
+		case err != nil:
+			return err
+		case reference.Bounds().Size() != img.Bounds().Size():
+			mismatch.Diff = ""
+			mismatch.Ratio = 1
+			mismatch.Msg = fmt.Sprintf("size is %v, the reference is %v", img.Bounds().Size(), reference.Bounds().Size())
+		default:
+			var diff *image.RGBA
+			mismatch.Ratio, diff = imageDiff(reference, img)
+			if mismatch.Ratio <= tolerance {
+				continue
+			}
+			mismatch.Msg = fmt.Sprintf("%.2f%% of the pixels differ", mismatch.Ratio*100) //nolint:mnd
+			if err := writeImage(mismatch.Diff, diff); err != nil {
+				return err
+			}
+		}
+		if err := writeImage(mismatch.Actual, img); err != nil {
+			return err
+		}
+		mismatches = append(mismatches, mismatch)
+	}
+
+	if len(mismatches) > 0 {
+		return VisualError{Mismatches: mismatches}
+	}
+	return nil
+}
+
+// imageDiff returns the fraction of the pixels of the images of the same size
+// which differ, and an image of their differences: the actual image faded,
+// with the pixels which differ in red.
+func imageDiff(want, got image.Image) (float64, *image.RGBA) {
+	bounds := got.Bounds()
+	diff := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	offset := want.Bounds().Min.Sub(bounds.Min)
+	differ := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			g := color.RGBAModel.Convert(got.At(x, y)).(color.RGBA)                    //nolint:forcetypeassert
+			w := color.RGBAModel.Convert(want.At(x+offset.X, y+offset.Y)).(color.RGBA) //nolint:forcetypeassert
+			p := image.Pt(x, y).Sub(bounds.Min)
+			if channelDiff(g.R, w.R) > pixelThreshold || channelDiff(g.G, w.G) > pixelThreshold ||
+				channelDiff(g.B, w.B) > pixelThreshold || channelDiff(g.A, w.A) > pixelThreshold {
+				differ++
+				diff.SetRGBA(p.X, p.Y, color.RGBA{0xff, 0, 0, 0xff})
+				continue
+			}
+			gray := uint8((uint32(g.R) + uint32(g.G) + uint32(g.B)) / 3 / 4) //nolint:mnd
+			diff.SetRGBA(p.X, p.Y, color.RGBA{gray, gray, gray, 0xff})
+		}
+	}
+	return float64(differ) / float64(max(bounds.Dx()*bounds.Dy(), 1)), diff
+}
+
+// channelDiff returns the absolute difference of the values of a channel.
+func channelDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// writeImage writes the image as a PNG, creating its directory.
+func writeImage(path string, img image.Image) error {
+	b, err := encodeFrame(img)
+	if err != nil {
+		return err
+	}
+	ensureDir(path)
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		return fmt.Errorf("could not write %s: %w", path, err)
+	}
+	return nil
+}
@@ -1,6 +1,14 @@
 package main
 
-import "testing"
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"testing"
+)
 
 func TestScreenshot(t *testing.T) {
 	t.Run("makeScreenshot should add screenshot to map and disable capture", func(t *testing.T) {
@@ -46,3 +54,53 @@ func TestScreenshot(t *testing.T) {
 		}
 	})
 }
+
+func TestCompareScreenshots(t *testing.T) {
+	dir := t.TempDir()
+	style := DefaultStyleOptions()
+	style.Width, style.Height, style.Padding = 40, 20, 0
+
+	// writeFrame writes the text and cursor layers of a frame, with a dot of
+	// the given color on the text layer.
+	writeFrame := func(n int, dot color.Color) {
+		t.Helper()
+		text := image.NewRGBA(image.Rect(0, 0, 40, 20))
+		draw.Draw(text, text.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+		text.Set(5, 5, dot)
+		requireNoErr(t, writeFrameFile(dir, textFrameFormat, n, text))
+		requireNoErr(t, writeFrameFile(dir, cursorFrameFormat, n, image.NewRGBA(text.Bounds())))
+	}
+	writeFrame(1, color.White)
+	writeFrame(2, color.Black)
+
+	path := filepath.Join(dir, "shots", "shot.png")
+	opts := NewScreenshotOptions(dir, style)
+	opts.screenshots[path] = 1
+	requireNoErr(t, CompareScreenshots(opts, 0, true))
+	requireNoErr(t, CompareScreenshots(opts, 0, false))
+
+	opts.screenshots[path] = 2
+	var verr VisualError
+	if err := CompareScreenshots(opts, 0, false); !errors.As(err, &verr) || len(verr.Mismatches) != 1 {
+		t.Fatalf("expected a mismatch, got %v", err)
+	}
+	m := verr.Mismatches[0]
+	if m.Ratio != 1.0/800 {
+		t.Errorf("expected a single pixel to differ, got a ratio of %v", m.Ratio)
+	}
+	diff, err := readFrame(m.Diff)
+	requireNoErr(t, err)
+	if r, _, _, _ := diff.At(5, 5).RGBA(); r != 0xffff {
+		t.Errorf("expected the pixel which differs to be red in %s", m.Diff)
+	}
+	if _, err := os.Stat(m.Actual); err != nil {
+		t.Errorf("expected the actual screenshot to be written: %v", err)
+	}
+
+	// The tolerance allows the pixel to differ, and the stale images are
+	// removed.
+	requireNoErr(t, CompareScreenshots(opts, 0.01, false))
+	if _, err := os.Stat(m.Diff); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed", m.Diff)
+	}
+}
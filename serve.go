@@ -93,7 +93,7 @@ var serveCmd = &cobra.Command{
 						rand := rand.Int63n(maxNumber)
 						tempFile := filepath.Join(os.TempDir(), fmt.Sprintf("vhs-%d", rand))
 						defer func() { _ = os.Remove(tempFile) }()
-						errs := Evaluate(s.Context(), b.String(), s.Stderr(), func(v *VHS) {
+						errs := Evaluate(s.Context(), b.String(), s.Stderr(), BeforeRecord(func(v *VHS) {
 							var gifOutput, mp4Output, webmOutput string
 							switch {
 							case v.Options.Video.Output.MP4 != "":
@@ -109,7 +109,7 @@ var serveCmd = &cobra.Command{
 							v.Options.Video.Output.GIF = gifOutput
 							v.Options.Video.Output.MP4 = mp4Output
 							v.Options.Video.Output.WebM = webmOutput
-						})
+						}))
 
 						if len(errs) > 0 {
 							printErrors(s.Stderr(), b.String(), errs)
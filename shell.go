@@ -14,9 +14,13 @@ const (
 )
 
 // Shell is a type that contains a prompt and the command to set up the shell.
+//
+// Prompt is the prompt as it is read from the terminal, without its trailing
+// space, e.g. to check that Wait can match it.
 type Shell struct {
 	Command []string
 	Env     []string
+	Prompt  string
 }
 
 // Shells contains a mapping from shell names to their Shell struct.
@@ -24,10 +28,12 @@ var Shells = map[string]Shell{
 	bash: {
 		Env:     []string{"PS1=\\[\\e[38;2;90;86;224m\\]> \\[\\e[0m\\]", "BASH_SILENCE_DEPRECATION_WARNING=1"},
 		Command: []string{"bash", "--noprofile", "--norc", "--login", "+o", "history"},
+		Prompt:  ">",
 	},
 	zsh: {
 		Env:     []string{`PROMPT=%F{#5B56E0}> %F{reset_color}`},
 		Command: []string{"zsh", "--histnostore", "--no-rcs"},
+		Prompt:  ">",
 	},
 	fish: {
 		Command: []string{
@@ -38,6 +44,7 @@ var Shells = map[string]Shell{
 			"-C", "function fish_greeting; end",
 			"-C", `function fish_prompt; set_color 5B56E0; echo -n "> "; set_color normal; end`,
 		},
+		Prompt: ">",
 	},
 	powershell: {
 		Command: []string{
@@ -48,6 +55,7 @@ var Shells = map[string]Shell{
 			"-Command",
 			`Set-PSReadLineOption -HistorySaveStyle SaveNothing; function prompt { Write-Host '>' -NoNewLine -ForegroundColor Blue; return ' ' }`,
 		},
+		Prompt: ">",
 	},
 	pwsh: {
 		Command: []string{
@@ -59,18 +67,23 @@ var Shells = map[string]Shell{
 			"-Command",
 			`Set-PSReadLineOption -HistorySaveStyle SaveNothing; Function prompt { Write-Host -ForegroundColor Blue -NoNewLine '>'; return ' ' }`,
 		},
+		Prompt: ">",
 	},
 	cmdexe: {
 		Command: []string{"cmd.exe", "/k", "prompt=^> "},
+		Prompt:  ">",
 	},
 	nushell: {
 		Command: []string{"nu", "--execute", "$env.PROMPT_COMMAND = {'\033[;38;2;91;86;224m>\033[m '}; $env.PROMPT_COMMAND_RIGHT = {''}"},
+		Prompt:  ">",
 	},
 	osh: {
 		Env:     []string{"PS1=\\[\\e[38;2;90;86;224m\\]> \\[\\e[0m\\]"},
 		Command: []string{"osh", "--norc"},
+		Prompt:  ">",
 	},
 	xonsh: {
 		Command: []string{"xonsh", "--no-rc", "-D", "PROMPT=\033[;38;2;91;86;224m>\033[m "},
+		Prompt:  ">",
 	},
 }
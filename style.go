@@ -87,3 +87,14 @@ func DefaultStyleOptions() *StyleOptions {
 		BackgroundColor: DefaultTheme.Background,
 	}
 }
+
+// MinDimensions returns the smallest width and height which fit the padding,
+// margins and window bar of the style.
+func (s *StyleOptions) MinDimensions() (int, int) {
+	minWidth := double(s.Padding) + double(s.Margin)
+	minHeight := double(s.Padding) + double(s.Margin)
+	if s.WindowBar != "" {
+		minHeight += s.WindowBarSize
+	}
+	return minWidth, minHeight
+}
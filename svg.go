@@ -0,0 +1,346 @@
+// Package vhs svg.go renders the recording into an animated SVG from the cells
+// of the terminal rather than from its frames, so that the text stays crisp
+// at any size and can be selected.
+//
+// Output demo.svg
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// encodeSVG writes the runs of screens into a self-contained SVG, which shows
+// each screen for the recorded frames of its run with a CSS animation, styled
+// like the videos.
+func encodeSVG(opts Options, runs []screenRun, path string) error {
+	if len(runs) == 0 {
+		return fmt.Errorf("could not create %s: no frames", path)
+	}
+	var sb strings.Builder
+	if err := writeSVG(&sb, opts, runs); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(sb.String()), 0o600); err != nil {
+		return fmt.Errorf("could not create %s: %w", path, err)
+	}
+	return nil
+}
+
+// screenLayout is the layout of the outputs drawn from the screens of the
+// terminal, styled like the frames of the videos.
+type screenLayout struct {
+	termWidth, termHeight int
+	// bar is whether the window has a bar, window the size of the window
+	// with its bar, size the size of the output and origin the position of
+	// the window in the output.
+	bar                  bool
+	window, size, origin image.Point
+	// grid is the size of the cells of the first screen in pixels, and scale
+	// and offset fit them into the padding of the terminal.
+	grid, offset image.Point
+	scale        float64
+}
+
+// newScreenLayout returns the layout of the screens of the recording for the
+// style. Like the frames of the videos, the screens are laid out like the
+// first one, scaled into the padding.
+func newScreenLayout(style StyleOptions, first screen) screenLayout {
+	l := screenLayout{scale: 1}
+	l.termWidth, l.termHeight = calcTermDimensions(style)
+	l.window = image.Pt(l.termWidth, l.termHeight)
+	l.bar = style.WindowBar != "" && windowBar(l.termWidth, l.termHeight, style) != nil
+	if l.bar {
+		l.window.Y += style.WindowBarSize
+	}
+	l.size = l.window
+	if style.MarginFill != "" {
+		l.size = image.Pt(style.Width, style.Height)
+	}
+	l.origin = l.size.Sub(l.window).Div(doublingFactor)
+
+	if len(first.cells) > 0 {
+		l.grid = image.Pt(len(first.cells[0])*first.size.X, len(first.cells)*first.size.Y)
+	}
+	inner := image.Rect(0, 0, l.termWidth, l.termHeight).Inset(style.Padding)
+	if l.grid.X > 0 && l.grid.Y > 0 {
+		l.scale = min(float64(inner.Dx())/float64(l.grid.X), float64(inner.Dy())/float64(l.grid.Y))
+	}
+	scaled := image.Pt(int(float64(l.grid.X)*l.scale), int(float64(l.grid.Y)*l.scale))
+	l.offset = image.Pt(l.termWidth, l.termHeight).Sub(scaled).Div(doublingFactor)
+	return l
+}
+
+// writeSVG writes the SVG of the runs of screens.
+//
+//nolint:mnd
+func writeSVG(sb *strings.Builder, opts Options, runs []screenRun) error {
+	style := *opts.Video.Style
+	layout := newScreenLayout(style, runs[0].screen)
+	termWidth, termHeight := layout.termWidth, layout.termHeight
+	window, size, origin := layout.window, layout.size, layout.origin
+	grid, scale, offset := layout.grid, layout.scale, layout.offset
+
+	frames, timeline := drawScreens(runs, func(s screen) string { return svgScreen(s, opts.Theme) })
+	starts, duration := runTimes(opts.Video, runs)
+
+	_, _ = fmt.Fprintf(sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
+		size.X, size.Y, size.X, size.Y)
+
+	sb.WriteString("<style>\n")
+	_, _ = fmt.Fprintf(sb, "text{font-family:%s;font-size:%dpx;white-space:pre;dominant-baseline:central}\n",
+		template.HTMLEscapeString(cssFontFamily(opts.FontFamily)), opts.FontSize)
+	sb.WriteString("@keyframes play{")
+	for i, start := range starts {
+		_, _ = fmt.Fprintf(sb, "%s%%{transform:translateY(%dpx)}",
+			strconv.FormatFloat(start/duration*100, 'f', 3, 64), -timeline[i]*grid.Y)
+	}
+	_, _ = fmt.Fprintf(sb, "100%%{transform:translateY(%dpx)}}\n", -timeline[len(timeline)-1]*grid.Y)
+	_, _ = fmt.Fprintf(sb, ".frames{animation:play %ss step-end infinite}\n", strconv.FormatFloat(duration, 'f', 3, 64))
+	sb.WriteString("</style>\n")
+
+	sb.WriteString("<defs>\n")
+	_, _ = fmt.Fprintf(sb, `<clipPath id="window"><rect width="%d" height="%d" rx="%d" ry="%d"/></clipPath>`+"\n",
+		window.X, window.Y, style.BorderRadius, style.BorderRadius)
+	_, _ = fmt.Fprintf(sb, `<clipPath id="screen"><rect width="%d" height="%d"/></clipPath>`+"\n", grid.X, grid.Y)
+	sb.WriteString("</defs>\n")
+
+	if err := writeSVGMargin(sb, style); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(sb, `<g transform="translate(%d %d)" clip-path="url(#window)">`+"\n", origin.X, origin.Y)
+	termY := 0
+	if layout.bar {
+		writeSVGWindowBar(sb, termWidth, style)
+		termY = style.WindowBarSize
+	}
+	_, _ = fmt.Fprintf(sb, `<g transform="translate(0 %d)">`+"\n", termY)
+	_, _ = fmt.Fprintf(sb, `<rect width="%d" height="%d" fill="%s"/>`+"\n", termWidth, termHeight, template.HTMLEscapeString(style.BackgroundColor))
+	_, _ = fmt.Fprintf(sb, `<g transform="translate(%d %d) scale(%s)" clip-path="url(#screen)">`+"\n",
+		offset.X, offset.Y, strconv.FormatFloat(scale, 'f', -1, 64))
+	_, _ = fmt.Fprintf(sb, `<rect width="%d" height="%d" fill="%s"/>`+"\n", grid.X, grid.Y, svgColor(themeColor(opts.Theme.Background)))
+	sb.WriteString(`<g class="frames">` + "\n")
+	for i, frame := range frames {
+		_, _ = fmt.Fprintf(sb, `<g transform="translate(0 %d)">`+"\n%s</g>\n", i*grid.Y, frame)
+	}
+	sb.WriteString("</g>\n</g>\n</g>\n</g>\n</svg>\n")
+	return nil
+}
+
+// drawScreens draws the screens of the runs, and returns the distinct drawings
+// with the index of the drawing of each run. Screens which come back, e.g.
+// when the cursor blinks, are only drawn once.
+func drawScreens(runs []screenRun, draw func(screen) string) ([]string, []int) {
+	var drawings []string
+	indices := make(map[string]int)
+	timeline := make([]int, 0, len(runs))
+	for _, run := range runs {
+		drawing := draw(run.screen)
+		i, ok := indices[drawing]
+		if !ok {
+			i = len(drawings)
+			indices[drawing] = i
+			drawings = append(drawings, drawing)
+		}
+		timeline = append(timeline, i)
+	}
+	return drawings, timeline
+}
+
+// runTimes returns the time in seconds at which each run starts and the
+// duration of the runs, played at the speed of the options.
+func runTimes(opts VideoOptions, runs []screenRun) ([]float64, float64) {
+	speed := opts.PlaybackSpeed
+	if speed <= 0 {
+		speed = defaultPlaybackSpeed
+	}
+	starts := make([]float64, 0, len(runs))
+	elapsed := 0
+	for _, run := range runs {
+		starts = append(starts, float64(elapsed)/float64(opts.Framerate)/speed)
+		elapsed += run.frames
+	}
+	return starts, float64(elapsed) / float64(opts.Framerate) / speed
+}
+
+// svgScreen returns the SVG elements drawing the cells of the screen: the
+// backgrounds, the cursor and the text, in runs of cells of the same
+// attributes.
+func svgScreen(s screen, theme Theme) string {
+	background := themeColor(theme.Background)
+	var sb strings.Builder
+	for y, row := range s.cells {
+		row = append([]cell(nil), row...)
+		if s.cursorVisible && y == s.cursor.Y && s.cursor.X >= 0 && s.cursor.X < len(row) {
+			row[s.cursor.X].FG = themeColor(theme.CursorAccent)
+			row[s.cursor.X].BG = themeColor(theme.Cursor)
+		}
+
+		top := y * s.size.Y
+		for x := 0; x < len(row); {
+			end := x + 1
+			for end < len(row) && sameColor(row[end].BG, row[x].BG) {
+				end++
+			}
+			if !sameColor(row[x].BG, background) {
+				_, _ = fmt.Fprintf(&sb, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`+"\n",
+					x*s.size.X, top, (end-x)*s.size.X, s.size.Y, svgColor(row[x].BG))
+			}
+			x = end
+		}
+
+		for x := 0; x < len(row); {
+			end := x + 1
+			for end < len(row) && sameText(row[end], row[x]) {
+				end++
+			}
+			var text strings.Builder
+			for _, c := range row[x:end] {
+				if c.Char < ' ' {
+					text.WriteRune(' ')
+				} else {
+					text.WriteRune(c.Char)
+				}
+			}
+			chars := text.String()
+			if !row[x].Underline {
+				chars = strings.TrimRight(chars, " ")
+			}
+			if chars != "" {
+				width := utf8.RuneCountInString(chars) * s.size.X
+				_, _ = fmt.Fprintf(&sb, `<text x="%d" y="%s" textLength="%d" fill="%s"%s>%s</text>`+"\n",
+					x*s.size.X, strconv.FormatFloat(float64(top)+float64(s.size.Y)/2, 'f', -1, 64), width,
+					svgColor(row[x].FG), svgTextAttributes(row[x]), template.HTMLEscapeString(chars))
+			}
+			x = end
+		}
+	}
+	return sb.String()
+}
+
+// sameText reports whether the cells are drawn with the same attributes.
+func sameText(a, b cell) bool {
+	return sameColor(a.FG, b.FG) && a.Bold == b.Bold && a.Underline == b.Underline
+}
+
+// sameColor reports whether the colors are the same.
+func sameColor(a, b color.Color) bool {
+	return svgColor(a) == svgColor(b)
+}
+
+// svgTextAttributes returns the attributes of the text of the cell.
+func svgTextAttributes(c cell) string {
+	var attrs string
+	if c.Bold {
+		attrs += ` font-weight="bold"`
+	}
+	if c.Underline {
+		attrs += ` text-decoration="underline"`
+	}
+	return attrs
+}
+
+// svgColor returns the hex string of the color, or none for no color.
+func svgColor(c color.Color) string {
+	if c == nil {
+		return "none"
+	}
+	return hexColor(c)
+}
+
+// cssFontFamily returns the font families as a CSS font-family, with the
+// names of the families quoted.
+func cssFontFamily(families string) string {
+	var quoted []string
+	for _, family := range strings.Split(families, fontsSeparator) {
+		family = strings.TrimSpace(family)
+		switch {
+		case family == "":
+		case family == "monospace" || family == "ui-monospace":
+			quoted = append(quoted, family)
+		default:
+			quoted = append(quoted, strconv.Quote(family))
+		}
+	}
+	if !slices.Contains(quoted, "monospace") {
+		quoted = append(quoted, "monospace")
+	}
+	return strings.Join(quoted, ",")
+}
+
+// writeSVGMargin writes the margin fill of the style, embedding an image.
+func writeSVGMargin(sb *strings.Builder, style StyleOptions) error {
+	switch {
+	case style.MarginFill == "":
+	case marginFillIsColor(style.MarginFill):
+		_, _ = fmt.Fprintf(sb, `<rect width="%d" height="%d" fill="%s"/>`+"\n",
+			style.Width, style.Height, template.HTMLEscapeString(style.MarginFill))
+	default:
+		uri, err := dataURI(style.MarginFill)
+		if err != nil {
+			return fmt.Errorf("unable to read margin file: %w", err)
+		}
+		_, _ = fmt.Fprintf(sb, `<image width="%d" height="%d" preserveAspectRatio="none" href="%s"/>`+"\n",
+			style.Width, style.Height, uri)
+	}
+	return nil
+}
+
+// dataURI returns the file as a data URI, to embed it.
+func dataURI(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err //nolint:wrapcheck
+	}
+	return "data:" + http.DetectContentType(b) + ";base64," + base64.StdEncoding.EncodeToString(b), nil
+}
+
+// writeSVGWindowBar writes the window bar of the style, the way MakeWindowBar
+// draws it.
+//
+//nolint:mnd
+func writeSVGWindowBar(sb *strings.Builder, termWidth int, style StyleOptions) {
+	_, _ = fmt.Fprintf(sb, `<rect width="%d" height="%d" fill="%s"/>`+"\n",
+		termWidth, style.WindowBarSize, template.HTMLEscapeString(style.WindowBarColor))
+
+	right := strings.HasSuffix(style.WindowBar, "Right")
+	center := func(gap, rad, space, i int) int {
+		if right {
+			return termWidth - (gap + rad + i*space)
+		}
+		return gap + rad + i*space
+	}
+
+	if strings.HasPrefix(style.WindowBar, "Rings") {
+		outerRad := style.WindowBarSize / barToDotBorderRatio
+		outerDia := double(outerRad)
+		innerRad := double(outerDia) / barToDotBorderRatio
+		ringGap := half(style.WindowBarSize - outerDia)
+		ringSpace := outerDia + style.WindowBarSize/barToDotRatio
+		for i := range 3 {
+			x, y := center(ringGap, outerRad, ringSpace, i), outerRad+ringGap
+			_, _ = fmt.Fprintf(sb, `<circle cx="%d" cy="%d" r="%d" fill="#333333"/>`+"\n", x, y, outerRad)
+			_, _ = fmt.Fprintf(sb, `<circle cx="%d" cy="%d" r="%d" fill="%s"/>`+"\n", x, y, innerRad, template.HTMLEscapeString(style.WindowBarColor))
+		}
+		return
+	}
+
+	dotRad := style.WindowBarSize / barToDotRatio
+	dotDia := double(dotRad)
+	dotGap := half(style.WindowBarSize - dotDia)
+	dotSpace := dotDia + style.WindowBarSize/barToDotRatio
+	for i, fill := range []string{"#ff4f4d", "#febb00", "#00cc1d"} {
+		_, _ = fmt.Fprintf(sb, `<circle cx="%d" cy="%d" r="%d" fill="%s"/>`+"\n",
+			center(dotGap, dotRad, dotSpace, i), dotRad+dotGap, dotRad, fill)
+	}
+}
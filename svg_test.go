@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/xml"
+	"image"
+	"io"
+	"strings"
+	"testing"
+)
+
+// textScreen returns a screen of the lines in the default colors of the theme.
+func textScreen(lines ...string) screen {
+	s := screen{size: image.Pt(10, 20), cursorVisible: true}
+	for _, line := range lines {
+		row := make([]cell, 4)
+		for x := range row {
+			row[x] = cell{Char: ' ', FG: themeColor(DefaultTheme.Foreground), BG: themeColor(DefaultTheme.Background)}
+		}
+		for x, r := range line {
+			row[x].Char = r
+		}
+		s.cells = append(s.cells, row)
+	}
+	return s
+}
+
+func TestScreenRecorder(t *testing.T) {
+	var r screenRecorder
+	for _, s := range []screen{textScreen("a"), textScreen("a"), textScreen("ab"), textScreen("a")} {
+		r.add(s)
+	}
+	if len(r.runs) != 3 || r.runs[0].frames != 2 || r.runs[1].frames != 1 || r.runs[2].frames != 1 {
+		t.Fatalf("expected runs of 2, 1 and 1 frames, got %+v", r.runs)
+	}
+
+	rotated := rotateRuns(r.runs, 1)
+	if len(rotated) != 4 || rotated[0].frames != 1 || rotated[3].frames != 1 || !rotated[3].screen.equal(textScreen("a")) {
+		t.Fatalf("expected the first frame to move to the end, got %+v", rotated)
+	}
+}
+
+func TestWriteSVG(t *testing.T) {
+	opts := DefaultVHSOptions()
+	opts.Video.Framerate = 10
+	opts.Video.Style.WindowBar = "Colorful"
+	opts.Video.Style.BorderRadius = 8
+
+	bold := textScreen("<b>")
+	bold.cells[0][1].Bold = true
+	runs := []screenRun{
+		{screen: textScreen("$"), frames: 5},
+		{screen: bold, frames: 10},
+		{screen: textScreen("$"), frames: 5},
+	}
+
+	var sb strings.Builder
+	if err := writeSVG(&sb, opts, runs); err != nil {
+		t.Fatal(err)
+	}
+	out := sb.String()
+
+	// The SVG must be well-formed XML.
+	dec := xml.NewDecoder(strings.NewReader(out))
+	for {
+		if _, err := dec.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("invalid SVG: %v\n%s", err, out)
+		}
+	}
+
+	for _, want := range []string{
+		// The screen which comes back is drawn once, and shown again.
+		"@keyframes play{0.000%{transform:translateY(0px)}25.000%{transform:translateY(-20px)}75.000%{transform:translateY(0px)}",
+		"animation:play 2.000s step-end infinite",
+		`rx="8"`,
+		`fill="#ff4f4d"`,
+		`font-weight="bold"`,
+		"&lt;",
+		// The cursor is drawn over the first cell.
+		`<rect x="0" y="0" width="10" height="20" fill="` + svgColor(themeColor(DefaultTheme.Cursor)) + `"/>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected SVG to contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `translate(0 40)`) {
+		t.Errorf("expected the screen which comes back to be drawn once:\n%s", out)
+	}
+}
@@ -1,16 +1,81 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
-	"sync"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/vhs/parser"
+	"github.com/spf13/cobra"
+)
+
+var (
+	testUpdate    bool
+	testVisual    bool
+	testTolerance float64
+
+	testCmd = &cobra.Command{
+		Use:   "test <file>...",
+		Short: "Run tapes and compare their text output against golden files",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := ensureDependencies(); err != nil {
+				return err
+			}
+			tapeVars, err := flagVars(cmd)
+			if err != nil {
+				return err
+			}
+			reports, err := flagReports(cmd)
+			if err != nil {
+				return err
+			}
+			opts := testRunOptions{vars: tapeVars, update: testUpdate, visual: testVisual, tolerance: testTolerance}
+
+			failed := 0
+			tapes := make([]reportTape, 0, len(args))
+			for _, file := range args {
+				result := runTest(cmd.Context(), file, opts)
+				printTestResult(result)
+				if !result.passed() {
+					failed++
+				}
+				tapes = append(tapes, newReportTape(result))
+			}
+			if err := writeReports(reports, tapes); err != nil {
+				return err
+			}
+
+			if failed > 0 {
+				return fmt.Errorf("%d of %d tape(s) failed", failed, len(args))
+			}
+			return nil
+		},
+	}
 )
 
 // TestOptions is the set of options for the testing functionality.
 type TestOptions struct {
+	// Output is the file the text output is written to.
 	Output string
+	// Golden is the file vhs test compares the text output against, instead
+	// of writing it. It defaults to the Output file.
 	Golden string
+	// Screenshots is what rendering does with the screenshots, and Tolerance
+	// the fraction of the pixels of a screenshot which may differ from its
+	// reference image when comparing them.
+	Screenshots screenshotMode
+	Tolerance   float64
 }
 
 // DefaultTestOptions returns the default set of options for the testing functionality.
@@ -20,73 +85,359 @@ func DefaultTestOptions() TestOptions {
 	}
 }
 
+// ansi is the extension of the styled text output, which keeps the colors
+// and attributes of the cells as ANSI escape sequences.
+const ansiExtension = ".ansi"
+
 // Alternatively, `var separator = strings.Repeat("─", 80)`.
 const separator = "────────────────────────────────────────────────────────────────────────────────"
 
-var (
-	once sync.Once
-	file *os.File
-)
-
-// SaveOutput saves the current buffer to the output file.
+// SaveOutput appends the current buffer to the text output, which is written
+// to the output file when rendering. The styled text output appends the
+// styled lines of the screen instead.
 func (v *VHS) SaveOutput() error {
-	var err error
-	// Create output file (once)
-	once.Do(func() {
-		err = os.MkdirAll(filepath.Dir(v.Options.Test.Output), 0o750)
+	var lines []string
+	switch {
+	case filepath.Ext(v.Options.Test.Output) == ansiExtension && !v.configured:
+		// The screen can't be read before the terminal is configured, when
+		// it is still empty.
+	case filepath.Ext(v.Options.Test.Output) == ansiExtension:
+		s, err := v.backend.Screen()
 		if err != nil {
-			file, err = os.CreateTemp(os.TempDir(), "vhs-*.txt")
-			return
+			return fmt.Errorf("failed to get screen: %w", err)
+		}
+		lines = styledLines(s, v.Options.Theme)
+	default:
+		var err error
+		lines, err = v.Buffer()
+		if err != nil {
+			return fmt.Errorf("failed to get buffer: %w", err)
 		}
-		file, err = os.Create(v.Options.Test.Output)
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
 	}
 
-	lines, err := v.Buffer()
-	if err != nil {
-		return fmt.Errorf("failed to get buffer: %w", err)
+	for _, line := range lines {
+		v.testOutput.WriteString(line + "\n")
 	}
+	v.testOutput.WriteString(separator + "\n")
+	return nil
+}
 
-	for _, line := range lines {
-		_, err = file.WriteString(line + "\n")
-		if err != nil {
-			return fmt.Errorf("failed to write buffer to file: %w", err)
+// styledLines returns the rows of the screen as text with ANSI SGR sequences
+// for the colors and attributes of their cells. The default colors of the
+// theme are left unstyled, the colors of its palette are written as palette
+// colors, and the blank cells at the end of the rows are trimmed.
+func styledLines(s screen, theme Theme) []string {
+	fg, bg := hexColor(themeColor(theme.Foreground)), hexColor(themeColor(theme.Background))
+	palette := make(map[string]int)
+	for i := 255; i >= 0; i-- {
+		palette[hexColor(themePalette(theme, i))] = i
+	}
+
+	// sgrColor returns the SGR parameters of a color, given the parameters of
+	// the first ANSI color, the first bright ANSI color, and extended colors.
+	sgrColor := func(c string, base, bright, extended int) string {
+		i, ok := palette[c]
+		switch {
+		case !ok:
+			var r, g, b int
+			_, _ = fmt.Sscanf(c, "#%02x%02x%02x", &r, &g, &b)
+			return fmt.Sprintf("%d;2;%d;%d;%d", extended, r, g, b)
+		case i < 8: //nolint:mnd
+			return strconv.Itoa(base + i)
+		case i < 16: //nolint:mnd
+			return strconv.Itoa(bright + i - 8)
+		default:
+			return fmt.Sprintf("%d;5;%d", extended, i)
 		}
 	}
 
-	_, err = file.WriteString(separator + "\n")
-	if err != nil {
-		return fmt.Errorf("failed to write separator to file: %w", err)
+	lines := make([]string, 0, len(s.cells))
+	for _, row := range s.cells {
+		type styledCell struct {
+			char rune
+			sgr  string
+		}
+		cells := make([]styledCell, len(row))
+		end := 0
+		for x, c := range row {
+			var params []string
+			if c.Bold {
+				params = append(params, "1")
+			}
+			if c.Underline {
+				params = append(params, "4")
+			}
+			if c.FG != nil && hexColor(c.FG) != fg {
+				params = append(params, sgrColor(hexColor(c.FG), 30, 90, 38)) //nolint:mnd
+			}
+			if c.BG != nil && hexColor(c.BG) != bg {
+				params = append(params, sgrColor(hexColor(c.BG), 40, 100, 48)) //nolint:mnd
+			}
+
+			cells[x] = styledCell{char: max(c.Char, ' ')}
+			if len(params) > 0 {
+				cells[x].sgr = "0;" + strings.Join(params, ";")
+			}
+			if c.Char > ' ' || c.BG != nil && hexColor(c.BG) != bg || c.Underline {
+				end = x + 1
+			}
+		}
+
+		var sb strings.Builder
+		sgr := ""
+		for _, c := range cells[:end] {
+			if c.sgr != sgr {
+				sgr = c.sgr
+				sb.WriteString("\x1b[" + cmp.Or(sgr, "0") + "m")
+			}
+			sb.WriteRune(c.char)
+		}
+		if sgr != "" {
+			sb.WriteString("\x1b[0m")
+		}
+		lines = append(lines, sb.String())
 	}
+	return lines
+}
 
+// TestOutput returns the text output saved so far.
+func (v *VHS) TestOutput() string {
+	return v.testOutput.String()
+}
+
+// writeTestOutput writes the text output to the output file, unless it is
+// compared against a golden file.
+func (v *VHS) writeTestOutput() error {
+	path := v.Options.Test.Output
+	if path == "" || v.Options.Test.Golden != "" {
+		return nil
+	}
+	log.Println(GrayStyle.Render("Creating " + path + "..."))
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(v.TestOutput()), 0o600); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
 	return nil
 }
 
 // Buffer returns the current buffer.
 func (v *VHS) Buffer() ([]string, error) {
-	// Get the current buffer.
-	buf, err := v.Page.Eval("() => Array(term.rows).fill(0).map((e, i) => term.buffer.active.getLine(i).translateToString().trimEnd())")
-	if err != nil {
-		return nil, fmt.Errorf("read buffer: %w", err)
+	return v.backend.Buffer() //nolint:wrapcheck
+}
+
+// CurrentLine returns the current line from the buffer.
+func (v *VHS) CurrentLine() (string, error) {
+	return v.backend.CurrentLine() //nolint:wrapcheck
+}
+
+// MatchScope returns whether the regular expression matches the current line
+// or the whole screen, depending on the scope, along with the value it was
+// matched against.
+func (v *VHS) MatchScope(scope string, rx *regexp.Regexp) (bool, string, error) {
+	switch scope {
+	case "Line":
+		line, err := v.CurrentLine()
+		if err != nil {
+			return false, "", fmt.Errorf("failed to get current line: %w", err)
+		}
+		return rx.MatchString(line), line, nil
+	case "Screen":
+		lines, err := v.Buffer()
+		if err != nil {
+			return false, "", fmt.Errorf("failed to get buffer: %w", err)
+		}
+		screen := strings.Join(lines, "\n")
+		return rx.MatchString(screen), screen, nil
+	default:
+		// Should be impossible due to parse validation, but we don't want to
+		// hang if it does happen due to a bug.
+		return false, "", fmt.Errorf("invalid scope %q", scope)
 	}
+}
 
-	arr := buf.Value.Arr()
-	lines := make([]string, 0, len(arr))
-	for _, line := range arr {
-		lines = append(lines, line.Str())
+// expectationErrors returns the error of the failed expectations, if any.
+func (v *VHS) expectationErrors() []error {
+	if len(v.failures) == 0 {
+		return nil
 	}
+	return []error{ExpectationError{Errors: v.failures}}
+}
+
+// Branch returns the commands of the branch of an If command which should be
+// executed given the current state of the terminal.
+func (v *VHS) Branch(c parser.Command) ([]parser.Command, error) {
+	scope, rxStr, _ := strings.Cut(c.Args, " ")
+
+	// This is validated on parse so using MustCompile reduces noise.
+	rx := regexp.MustCompile(rxStr)
 
-	return lines, nil
+	match, _, err := v.MatchScope(scope, rx)
+	if err != nil {
+		return nil, err
+	}
+	if match {
+		return c.Body, nil
+	}
+	return c.Else, nil
 }
 
-// CurrentLine returns the current line from the buffer.
-func (v *VHS) CurrentLine() (string, error) {
-	buf, err := v.Page.Eval("() => term.buffer.active.getLine(term.buffer.active.cursorY+term.buffer.active.viewportY).translateToString().trimEnd()")
+// testResult is the result of a tape run by vhs test.
+type testResult struct {
+	file     string
+	tape     string
+	golden   string
+	duration time.Duration
+	// diff is the diff from the golden file to the text output, if they
+	// differ.
+	diff string
+	// updated reports whether the golden file was written with the text
+	// output rather than compared against it.
+	updated bool
+	errs    []error
+	// steps are the Wait and Expect commands evaluated, and artifacts the
+	// golden file and the images compared.
+	steps     []testStep
+	artifacts []string
+}
+
+// passed reports whether the tape ran and its output matches its golden file.
+func (r testResult) passed() bool {
+	return len(r.errs) == 0 && r.diff == ""
+}
+
+// testRunOptions are the options of the tapes run by vhs test.
+type testRunOptions struct {
+	vars map[string]string
+	// update writes the golden files and the reference images instead of
+	// comparing against them.
+	update bool
+	// visual compares the screenshots against their reference images,
+	// allowing the tolerated fraction of their pixels to differ.
+	visual    bool
+	tolerance float64
+}
+
+// runTest runs a tape without its video outputs, and compares its text output
+// against its golden file, and its screenshots against their reference images
+// when visual, or writes them when updating.
+func runTest(ctx context.Context, file string, opts testRunOptions) (result testResult) {
+	result.file = file
+	start := time.Now()
+	steps := &stepRecorder{}
+	defer func() {
+		result.duration = time.Since(start)
+		result.steps = steps.steps
+		result.artifacts = append(result.artifacts, visualArtifacts(result.errs)...)
+	}()
+
+	b, err := os.ReadFile(file)
 	if err != nil {
-		return "", fmt.Errorf("read current line from buffer: %w", err)
+		result.errs = []error{fmt.Errorf("failed to read %s: %w", file, err)}
+		return result
 	}
+	result.tape = string(b)
 
-	return buf.Value.Str(), nil
+	var (
+		output string
+		vhs    *VHS
+	)
+	parseOpts := []parser.Option{parser.WithVars(opts.vars), parser.WithFile(file)}
+	errs := Evaluate(withStepRecorder(ctx, steps), result.tape, io.Discard, WithParseOptions(parseOpts...), BeforeRecord(func(v *VHS) {
+		v.Options.Video.Output = VideoOutputs{}
+	}), BeforeRender(func(v *VHS) {
+		v.Options.Video.Output = VideoOutputs{}
+		if v.Options.Test.Golden == "" {
+			v.Options.Test.Golden = v.Options.Test.Output
+		}
+		switch {
+		case !opts.visual:
+			v.Options.Test.Screenshots = screenshotSkip
+		case opts.update:
+			v.Options.Test.Screenshots = screenshotUpdate
+		default:
+			v.Options.Test.Screenshots = screenshotCompare
+		}
+		v.Options.Test.Tolerance = opts.tolerance
+		result.golden = v.Options.Test.Golden
+		if result.golden != "" {
+			result.artifacts = append(result.artifacts, result.golden)
+		}
+		if opts.visual {
+			result.artifacts = append(result.artifacts, screenshotArtifacts(v)...)
+		}
+		output = v.TestOutput()
+		vhs = v
+	}))
+	if len(errs) > 0 {
+		result.errs = errs
+		return result
+	}
+
+	// The screenshots are only known once the recording is done.
+	screenshots := opts.visual && len(vhs.Options.Screenshot.screenshots) > 0
+	switch {
+	case result.golden == "" && screenshots:
+		result.updated = opts.update
+		return result
+	case result.golden == "" && opts.visual:
+		result.errs = []error{errors.New("nothing to compare, add e.g. Output golden.txt or a Screenshot to the tape")}
+		return result
+	case result.golden == "":
+		result.errs = []error{errors.New("no text output to compare, add e.g. Output golden.txt to the tape")}
+		return result
+	}
+
+	if opts.update {
+		if err := os.MkdirAll(filepath.Dir(result.golden), 0o750); err != nil {
+			result.errs = []error{fmt.Errorf("failed to create golden directory: %w", err)}
+			return result
+		}
+		if err := os.WriteFile(result.golden, []byte(output), 0o600); err != nil {
+			result.errs = []error{fmt.Errorf("failed to write golden file: %w", err)}
+			return result
+		}
+		result.updated = true
+		return result
+	}
+
+	golden, err := os.ReadFile(result.golden)
+	if errors.Is(err, fs.ErrNotExist) {
+		result.errs = []error{fmt.Errorf("golden file %s does not exist, run vhs test --update to create it", result.golden)}
+		return result
+	}
+	if err != nil {
+		result.errs = []error{fmt.Errorf("failed to read golden file: %w", err)}
+		return result
+	}
+	if string(golden) != output {
+		// The escape sequences of the styled text output are shown as text.
+		escapes := strings.NewReplacer("\x1b", `\e`)
+		result.diff = unifiedDiff(result.golden, result.golden+" (actual)",
+			escapes.Replace(string(golden)), escapes.Replace(output))
+	}
+	return result
+}
+
+// printTestResult prints whether the tape passed, and the diff or the errors
+// of a failed tape.
+func printTestResult(r testResult) {
+	duration := GrayStyle.Render(fmt.Sprintf(" (%s)", r.duration.Round(time.Millisecond)))
+	switch {
+	case r.updated:
+		golden := ""
+		if r.golden != "" {
+			golden = GrayStyle.Render(" → " + r.golden)
+		}
+		log.Println(TimeStyle.Render("UPDATE") + " " + r.file + golden + duration)
+	case r.passed():
+		log.Println(StringStyle.Render("PASS") + "   " + r.file + duration)
+	default:
+		log.Println(ErrorStyle.Render("FAIL") + "   " + r.file + duration)
+		if r.diff != "" {
+			log.Println(colorDiff(r.diff))
+		}
+		printErrors(os.Stderr, r.tape, r.errs)
+	}
 }
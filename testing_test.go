@@ -0,0 +1,27 @@
+package main
+
+import (
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestStyledLines(t *testing.T) {
+	s := textScreen("ab", "cd", "", "e")
+	s.cells[0][0].Bold = true
+	s.cells[0][0].FG = themeColor(DefaultTheme.Red)
+	s.cells[0][1].FG = themePalette(DefaultTheme, 196)
+	s.cells[1][1].BG = color.RGBA{1, 2, 3, 0xff}
+	s.cells[1][1].Underline = true
+	s.cells[2][2].BG = themeColor(DefaultTheme.BrightBlue)
+
+	want := []string{
+		"\x1b[0;1;31ma\x1b[0;38;5;196mb\x1b[0m",
+		"c\x1b[0;4;48;2;1;2;3md\x1b[0m",
+		"  \x1b[0;104m \x1b[0m",
+		"e",
+	}
+	if got := styledLines(s, DefaultTheme); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected styled lines:\n%q\ngot:\n%q", want, got)
+	}
+}
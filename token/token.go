@@ -9,12 +9,14 @@ import (
 // Type represents a token's type.
 type Type string
 
-// Token represents a lexer token.
+// Token represents a lexer token. Raw is the text of the token as it is
+// written in the tape, e.g. a string with its quotes.
 type Token struct {
-	Type    Type
-	Literal string
-	Line    int
-	Column  int
+	Type    Type   `json:"type"`
+	Literal string `json:"literal"`
+	Raw     string `json:"raw,omitempty"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
 }
 
 // Tokens for the VHS language.
@@ -84,6 +86,12 @@ const (
 	PASTE           = "PASTE"
 	SHELL           = "SHELL"
 	ENV             = "ENV"
+	LET             = "LET"
+	DEFINE          = "DEFINE"
+	CALL            = "CALL"
+	REPEAT          = "REPEAT"
+	IF              = "IF"
+	ELSE            = "ELSE"
 	FONT_FAMILY     = "FONT_FAMILY"
 	FONT_SIZE       = "FONT_SIZE"
 	FRAMERATE       = "FRAMERATE"
@@ -102,9 +110,18 @@ const (
 	WINDOW_BAR_SIZE = "WINDOW_BAR_SIZE"
 	BORDER_RADIUS   = "CORNER_RADIUS"
 	WAIT            = "WAIT"
+	EXPECT          = "EXPECT"
+	EXPECT_NOT      = "EXPECT_NOT"
 	WAIT_TIMEOUT    = "WAIT_TIMEOUT"
 	WAIT_PATTERN    = "WAIT_PATTERN"
 	CURSOR_BLINK    = "CURSOR_BLINK"
+	CLOCK           = "CLOCK"
+	BACKEND         = "BACKEND"
+	GIF_ENCODER     = "GIF_ENCODER"
+	GIF_DITHER      = "GIF_DITHER"
+	MAX_COLORS      = "MAX_COLORS"
+	WEBP_QUALITY    = "WEBP_QUALITY"
+	WEBP_LOSSLESS   = "WEBP_LOSSLESS"
 )
 
 // Keywords maps keyword strings to tokens.
@@ -163,12 +180,27 @@ var Keywords = map[string]Type{
 	"Wait":          WAIT,
 	"Source":        SOURCE,
 	"CursorBlink":   CURSOR_BLINK,
+	"Clock":         CLOCK,
+	"Backend":       BACKEND,
+	"GIFEncoder":    GIF_ENCODER,
+	"GIFDither":     GIF_DITHER,
+	"MaxColors":     MAX_COLORS,
+	"WebPQuality":   WEBP_QUALITY,
+	"WebPLossless":  WEBP_LOSSLESS,
 	"true":          BOOLEAN,
 	"false":         BOOLEAN,
 	"Screenshot":    SCREENSHOT,
 	"Copy":          COPY,
 	"Paste":         PASTE,
 	"Env":           ENV,
+	"Let":           LET,
+	"Define":        DEFINE,
+	"Call":          CALL,
+	"Repeat":        REPEAT,
+	"If":            IF,
+	"Expect":        EXPECT,
+	"ExpectNot":     EXPECT_NOT,
+	"Else":          ELSE,
 }
 
 // IsSetting returns whether a token is a setting.
@@ -177,7 +209,8 @@ func IsSetting(t Type) bool {
 	case SHELL, FONT_FAMILY, FONT_SIZE, LETTER_SPACING, LINE_HEIGHT,
 		FRAMERATE, TYPING_SPEED, THEME, PLAYBACK_SPEED, HEIGHT, WIDTH,
 		PADDING, LOOP_OFFSET, MARGIN_FILL, MARGIN, WINDOW_BAR,
-		WINDOW_BAR_SIZE, BORDER_RADIUS, CURSOR_BLINK, WAIT_TIMEOUT, WAIT_PATTERN:
+		WINDOW_BAR_SIZE, BORDER_RADIUS, CURSOR_BLINK, WAIT_TIMEOUT, WAIT_PATTERN,
+		CLOCK, BACKEND, GIF_ENCODER, GIF_DITHER, MAX_COLORS, WEBP_QUALITY, WEBP_LOSSLESS:
 		return true
 	default:
 		return false
@@ -190,7 +223,8 @@ func IsCommand(t Type) bool {
 	case TYPE, SLEEP,
 		UP, DOWN, RIGHT, LEFT, PAGE_UP, PAGE_DOWN, SCROLL_UP, SCROLL_DOWN,
 		ENTER, BACKSPACE, DELETE, TAB,
-		ESCAPE, HOME, INSERT, END, CTRL, SOURCE, SCREENSHOT, COPY, PASTE, WAIT:
+		ESCAPE, HOME, INSERT, END, CTRL, SOURCE, SCREENSHOT, COPY, PASTE, WAIT,
+		EXPECT, EXPECT_NOT:
 		return true
 	default:
 		return false
@@ -222,8 +256,9 @@ func ToCamel(s string) string {
 }
 
 // LookupIdentifier returns whether the identifier is a keyword.
-// In `vhs`, there are no _actual_ identifiers, i.e. there are no variables.
-// Instead, identifiers are simply strings (i.e. bare words).
+// In `vhs`, there are no _actual_ identifiers. Variables (see Let) are only
+// referenced from within strings, so identifiers are simply strings (i.e.
+// bare words).
 func LookupIdentifier(ident string) Type {
 	if t, ok := Keywords[ident]; ok {
 		return t
@@ -4,35 +4,68 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"image"
+	"image/color"
 	"log"
 	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/go-rod/rod"
-	"github.com/go-rod/rod/lib/launcher"
-	"github.com/go-rod/rod/lib/proto"
+	"github.com/charmbracelet/vhs/parser"
 )
 
 // VHS is the object that controls the setup.
 type VHS struct {
-	Options      *Options
-	Errors       []error
-	Page         *rod.Page
-	browser      *rod.Browser
-	TextCanvas   *rod.Element
-	CursorCanvas *rod.Element
-	mutex        *sync.Mutex
-	started      bool
-	recording    bool
-	tty          *exec.Cmd
-	totalFrames  int
-	close        func() error
+	Options     *Options
+	Errors      []error
+	backend     Backend
+	mutex       *sync.Mutex
+	started     bool
+	recording   bool
+	totalFrames int
+	// configured is set once the terminal is set up, after which settings
+	// take effect while recording.
+	configured bool
+	// viewport is the current size of the page, and layout the layout of the
+	// frames, set when the first frame is captured.
+	viewport image.Point
+	layout   *frameLayout
+	// clock is the clock of the recording with a virtual clock.
+	clock *virtualClock
+	// encoder encodes the frames into the videos, set when the first frame
+	// is written.
+	encoder *videoEncoder
+	// screens records the screens of the terminal for the SVG and HTML
+	// outputs, set when the first frame is written.
+	screens *screenRecorder
+	// chapters are the chapters of the HTML player, and pendingChapters the
+	// titles of the chapters starting at the next frame.
+	chapters        []chapter
+	pendingChapters []string
+	// cast records the output of the shell for the asciicast output, set
+	// when the first frame is captured.
+	cast *castRecorder
+	// castRepaint repaints the screen in the asciicast at the next frame,
+	// instead of the output of the shell which wasn't recorded, e.g. while
+	// the recording was hidden.
+	castRepaint bool
+	// testOutput is the text output, the buffer saved after each command.
+	testOutput strings.Builder
+	// failures are the failed Expect and ExpectNot commands.
+	failures []parser.Error
+	// parseOptions are the options the tape is parsed with, beforeRecord the
+	// functions called before the recording starts, and beforeRender the
+	// functions called once its commands are executed.
+	parseOptions []parser.Option
+	beforeRecord []func(*VHS)
+	beforeRender []func(*VHS)
+	// steps records the Wait and Expect commands for the test reports, if
+	// they are written.
+	steps *stepRecorder
 }
 
 // Options is the set of options for the setup.
@@ -50,6 +83,8 @@ type Options struct {
 	WaitTimeout   time.Duration
 	WaitPattern   *regexp.Regexp
 	CursorBlink   bool
+	Clock         string
+	Backend       string
 	Screenshot    ScreenshotOptions
 	Style         StyleOptions
 }
@@ -107,6 +142,8 @@ func DefaultVHSOptions() Options {
 		Screenshot:    screenshot,
 		WaitTimeout:   defaultWaitTimeout,
 		WaitPattern:   defaultWaitPattern,
+		Clock:         ClockReal,
+		Backend:       BackendBrowser,
 	}
 }
 
@@ -121,7 +158,7 @@ func New() VHS {
 	}
 }
 
-// Start starts ttyd, browser and everything else needed to create the gif.
+// Start starts the backend and everything else needed to create the gif.
 func (vhs *VHS) Start() error {
 	vhs.mutex.Lock()
 	defer vhs.mutex.Unlock()
@@ -130,70 +167,100 @@ func (vhs *VHS) Start() error {
 		return fmt.Errorf("vhs is already started")
 	}
 
-	port := randomPort()
-	vhs.tty = buildTtyCmd(port, vhs.Options.Shell)
-	if err := vhs.tty.Start(); err != nil {
-		return fmt.Errorf("could not start tty: %w", err)
-	}
-
-	path, _ := launcher.LookPath()
-	enableNoSandbox := os.Getenv("VHS_NO_SANDBOX") != ""
-	u, err := launcher.New().Leakless(false).Bin(path).NoSandbox(enableNoSandbox).Launch()
+	backend, err := newBackend(vhs.Options.Backend)
 	if err != nil {
-		return fmt.Errorf("could not launch browser: %w", err)
+		return err
 	}
-	browser := rod.New().ControlURL(u).MustConnect()
-	page, err := browser.Page(proto.TargetCreateTarget{URL: fmt.Sprintf("http://localhost:%d", port)})
-	if err != nil {
-		return fmt.Errorf("could not open ttyd: %w", err)
+	if err := backend.Start(vhs.Options.Shell); err != nil {
+		_ = backend.Close()
+		return err //nolint:wrapcheck
 	}
 
-	vhs.browser = browser
-	vhs.Page = page
-	vhs.close = vhs.browser.Close
+	vhs.backend = backend
 	vhs.started = true
 	return nil
 }
 
 // Setup sets up the VHS instance and performs the necessary actions to reflect
 // the options that are default and set by the user.
-func (vhs *VHS) Setup() {
+func (vhs *VHS) Setup() error {
 	// Set Viewport to the correct size, accounting for the padding that will be
 	// added during the render.
-	padding := vhs.Options.Video.Style.Padding
+	vhs.viewport = vhs.viewportSize(vhs.Options.Video.Style.Padding)
+
+	// Apply options to the terminal
+	// By this point the setting commands have been executed, so the `opts` struct is up to date.
+	if err := vhs.backend.Configure(vhs.terminalOptions(), vhs.viewport); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	_ = os.RemoveAll(vhs.Options.Video.Input)
+	_ = os.MkdirAll(vhs.Options.Video.Input, 0o750)
+	vhs.configured = true
+	return nil
+}
+
+// terminalOptions returns the options of the terminal of the backend.
+func (vhs *VHS) terminalOptions() TerminalOptions {
+	return TerminalOptions{
+		FontFamily:    vhs.Options.FontFamily,
+		FontSize:      vhs.Options.FontSize,
+		LetterSpacing: vhs.Options.LetterSpacing,
+		LineHeight:    vhs.Options.LineHeight,
+		Theme:         vhs.Options.Theme,
+		// The cursor doesn't blink with a virtual clock, since blinking
+		// follows the wall clock.
+		CursorBlink: vhs.Options.CursorBlink && vhs.Options.Clock != ClockVirtual,
+		Output:      vhs.Options.Video.Output.Cast != "",
+	}
+}
+
+// viewportSize returns the size of the page for the given padding, which is
+// the size of the video without its padding, margins and window bar.
+func (vhs *VHS) viewportSize(padding int) image.Point {
+	style := vhs.Options.Video.Style
 	margin := 0
-	if vhs.Options.Video.Style.MarginFill != "" {
-		margin = vhs.Options.Video.Style.Margin
+	if style.MarginFill != "" {
+		margin = style.Margin
 	}
 	bar := 0
-	if vhs.Options.Video.Style.WindowBar != "" {
-		bar = vhs.Options.Video.Style.WindowBarSize
+	if style.WindowBar != "" {
+		bar = style.WindowBarSize
 	}
-	width := vhs.Options.Video.Style.Width - double(padding) - double(margin)
-	height := vhs.Options.Video.Style.Height - double(padding) - double(margin) - bar
-	vhs.Page = vhs.Page.MustSetViewport(width, height, 0, false)
-
-	// Find xterm.js canvases for the text and cursor layer for recording.
-	vhs.TextCanvas, _ = vhs.Page.Element("canvas.xterm-text-layer")
-	vhs.CursorCanvas, _ = vhs.Page.Element("canvas.xterm-cursor-layer")
+	return image.Pt(
+		style.Width-double(padding)-double(margin),
+		style.Height-double(padding)-double(margin)-bar,
+	)
+}
 
-	// Apply options to the terminal
-	// By this point the setting commands have been executed, so the `opts` struct is up to date.
-	vhs.Page.MustEval(fmt.Sprintf("() => { term.options = { fontSize: %d, fontFamily: '%s', letterSpacing: %f, lineHeight: %f, theme: %s, cursorBlink: %t } }",
-		vhs.Options.FontSize, vhs.Options.FontFamily, vhs.Options.LetterSpacing,
-		vhs.Options.LineHeight, vhs.Options.Theme.String(), vhs.Options.CursorBlink))
+// Resize resizes the page for the given padding while recording, and fits the
+// terminal into it. The frames are scaled back to the size of the first frame
+// when they are captured.
+func (vhs *VHS) Resize(padding int) error {
+	viewport := vhs.viewportSize(padding)
+	if viewport.X <= 0 || viewport.Y <= 0 {
+		return fmt.Errorf("padding %d leaves no room for the terminal", padding)
+	}
 
-	// Fit the terminal into the window
-	vhs.Page.MustEval("term.fit")
+	vhs.mutex.Lock()
+	vhs.viewport = viewport
+	vhs.mutex.Unlock()
+	return vhs.Configure()
+}
 
-	_ = os.RemoveAll(vhs.Options.Video.Input)
-	_ = os.MkdirAll(vhs.Options.Video.Input, 0o750)
+// Configure applies the options to the terminal once it is set up, and fits
+// it into the page, which changes the dimensions of its frames when settings
+// such as the font size change. Before, the options are applied by Setup.
+func (vhs *VHS) Configure() error {
+	if !vhs.configured {
+		return nil
+	}
+	return vhs.backend.Configure(vhs.terminalOptions(), vhs.viewport) //nolint:wrapcheck
 }
 
 const cleanupWaitTime = 100 * time.Millisecond
 
-// Terminate cleans up a VHS instance and terminates the go-rod browser and ttyd
-// processes.
+// Terminate cleans up a VHS instance and terminates the backend.
 //
 //nolint:wrapcheck
 func (vhs *VHS) terminate() error {
@@ -204,14 +271,16 @@ func (vhs *VHS) terminate() error {
 	time.Sleep(cleanupWaitTime)
 
 	// Tear down the processes we started.
-	vhs.browser.MustClose()
-	return vhs.tty.Process.Kill()
+	return vhs.backend.Close()
 }
 
 // Cleanup individual frames.
 //
 //nolint:wrapcheck
 func (vhs *VHS) Cleanup() error {
+	if vhs.encoder != nil {
+		vhs.encoder.Abort()
+	}
 	err := os.RemoveAll(vhs.Options.Video.Input)
 	if err != nil {
 		return err
@@ -219,46 +288,120 @@ func (vhs *VHS) Cleanup() error {
 	return os.RemoveAll(vhs.Options.Screenshot.input)
 }
 
-// Render starts rendering the individual frames into a video.
+// Render writes the text output, finishes encoding the frames into the
+// videos, the SVG, the HTML player and the asciicast, and renders the
+// screenshots, or compares them against their reference images.
 func (vhs *VHS) Render() error {
-	// Apply Loop Offset by modifying frame sequence
-	if err := vhs.ApplyLoopOffset(); err != nil {
+	// The text output doesn't depend on the frames, e.g. of a hidden tape.
+	if err := vhs.writeTestOutput(); err != nil {
 		return err
 	}
 
-	// Generate the video(s) with the frames.
-	var cmds []*exec.Cmd //nolint:prealloc
-	cmds = append(cmds, MakeGIF(vhs.Options.Video))
-	cmds = append(cmds, MakeMP4(vhs.Options.Video))
-	cmds = append(cmds, MakeWebM(vhs.Options.Video))
-	cmds = append(cmds, MakeScreenshots(vhs.Options.Screenshot)...)
+	if vhs.totalFrames <= 0 {
+		return errors.New("no frames")
+	}
 
-	for _, cmd := range cmds {
-		if cmd == nil {
-			continue
+	// Apply Loop Offset by modifying frame sequence
+	if vhs.Options.Video.Output.Frames != "" {
+		if err := vhs.ApplyLoopOffset(); err != nil {
+			return err
+		}
+	}
+
+	if vhs.encoder != nil {
+		outputs := vhs.Options.Video.Output.videos()
+		for _, ext := range []string{gif, mp4, webm, webp, apng} {
+			if outputs[ext] == "" {
+				continue
+			}
+			// The frames are encoded while recording, into the outputs set
+			// before it started, e.g. not in an If block.
+			if !vhs.encoder.encodes(ext) {
+				fmt.Println(ErrorStyle.Render(fmt.Sprintf("WARN: 'Output %s' has been ignored, since it was set after the recording started. Move the directive to the top of the file.", outputs[ext])))
+				continue
+			}
+			log.Println(GrayStyle.Render("Creating " + outputs[ext] + "..."))
+		}
+		files, err := vhs.encoder.Finish(vhs.loopOffsetFrames())
+		vhs.encoder = nil
+		// The videos are encoded into the input folder, then moved to their
+		// outputs.
+		for ext, file := range files {
+			if outputs[ext] == "" {
+				continue
+			}
+			if err := moveFile(file, outputs[ext]); err != nil {
+				return err
+			}
 		}
-		out, err := cmd.CombinedOutput()
 		if err != nil {
-			log.Println(string(out))
+			return err
+		}
+	}
+
+	if vhs.screens != nil && vhs.Options.Video.Output.SVG != "" {
+		log.Println(GrayStyle.Render("Creating " + vhs.Options.Video.Output.SVG + "..."))
+		runs := rotateRuns(vhs.screens.runs, vhs.loopOffsetFrames())
+		if err := encodeSVG(*vhs.Options, runs, vhs.Options.Video.Output.SVG); err != nil {
+			return err
+		}
+	}
+	if vhs.screens != nil && vhs.Options.Video.Output.HTML != "" {
+		log.Println(GrayStyle.Render("Creating " + vhs.Options.Video.Output.HTML + "..."))
+		offset := vhs.loopOffsetFrames()
+		runs := rotateRuns(vhs.screens.runs, offset)
+		chapters := rotateChapters(vhs.chapters, offset, vhs.totalFrames)
+		if err := encodeHTML(*vhs.Options, runs, chapters, vhs.Options.Video.Output.HTML); err != nil {
+			return err
+		}
+	}
+
+	if vhs.cast != nil && vhs.Options.Video.Output.Cast != "" {
+		log.Println(GrayStyle.Render("Creating " + vhs.Options.Video.Output.Cast + "..."))
+		if err := encodeCast(*vhs.Options, vhs.cast, vhs.Options.Video.Output.Cast); err != nil {
+			return err
+		}
+	}
+
+	switch test := vhs.Options.Test; test.Screenshots {
+	case screenshotSkip:
+	case screenshotCompare, screenshotUpdate:
+		if err := CompareScreenshots(vhs.Options.Screenshot, test.Tolerance, test.Screenshots == screenshotUpdate); err != nil {
+			return err
+		}
+	default:
+		for _, cmd := range MakeScreenshots(vhs.Options.Screenshot) {
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				log.Println(string(out))
+			}
 		}
 	}
 
 	return nil
 }
 
-// ApplyLoopOffset by modifying frame sequence.
-func (vhs *VHS) ApplyLoopOffset() error {
+// loopOffsetFrames returns the number of first frames which the loop offset
+// moves to the end of the recording.
+func (vhs *VHS) loopOffsetFrames() int {
 	if vhs.totalFrames <= 0 {
-		return errors.New("no frames")
+		return 0
 	}
 
-	loopOffsetPercentage := vhs.Options.LoopOffset
-
 	// Calculate # of frames to offset from LoopOffset percentage
-	loopOffsetFrames := int(math.Ceil(loopOffsetPercentage / 100.0 * float64(vhs.totalFrames)))
+	loopOffsetFrames := int(math.Ceil(vhs.Options.LoopOffset / 100.0 * float64(vhs.totalFrames)))
 
 	// Take care of overflow and keep track of exact offsetPercentage
-	loopOffsetFrames = loopOffsetFrames % vhs.totalFrames
+	return loopOffsetFrames % vhs.totalFrames
+}
+
+// ApplyLoopOffset by modifying the sequence of the frames written to disk.
+func (vhs *VHS) ApplyLoopOffset() error {
+	if vhs.totalFrames <= 0 {
+		return errors.New("no frames")
+	}
+
+	loopOffsetFrames := vhs.loopOffsetFrames()
 
 	// No operation if nothing to offset
 	if loopOffsetFrames <= 0 {
@@ -320,8 +463,27 @@ func (vhs *VHS) ApplyLoopOffset() error {
 const quality = 1.0
 
 // Record begins the goroutine which captures images from the xterm.js canvases.
+//
+// With a virtual clock, frames are instead captured when commands advance the
+// clock, see Sleep.
 func (vhs *VHS) Record(ctx context.Context) <-chan error {
 	ch := make(chan error)
+	if vhs.Options.Clock == ClockVirtual {
+		vhs.clock = &virtualClock{}
+		go func() {
+			<-ctx.Done()
+
+			// Capture the final state of the terminal.
+			if err := vhs.Sleep(time.Second / time.Duration(vhs.Options.Video.Framerate)); err != nil {
+				ch <- err
+			}
+			_ = vhs.terminate()
+			vhs.totalFrames = vhs.clock.frames
+			close(ch)
+		}()
+		return ch
+	}
+
 	interval := time.Second / time.Duration(vhs.Options.Video.Framerate)
 
 	//nolint: mnd
@@ -345,41 +507,24 @@ func (vhs *VHS) Record(ctx context.Context) <-chan error {
 				start = time.Now()
 
 				if !vhs.recording {
+					vhs.discardOutput()
 					continue
 				}
-				if vhs.Page == nil {
+				if vhs.backend == nil {
 					continue
 				}
 
-				cursor, cursorErr := vhs.CursorCanvas.CanvasToImage("image/png", quality)
-				text, textErr := vhs.TextCanvas.CanvasToImage("image/png", quality)
-				if textErr != nil || cursorErr != nil {
-					ch <- fmt.Errorf("error: %v, %v", textErr, cursorErr)
+				frame, err := vhs.captureFrame()
+				if err != nil {
+					ch <- err
 					continue
 				}
 
 				counter++
-				if err := os.WriteFile(
-					filepath.Join(vhs.Options.Video.Input, fmt.Sprintf(cursorFrameFormat, counter)),
-					cursor,
-					0o600,
-				); err != nil {
-					ch <- fmt.Errorf("error writing cursor frame: %w", err)
+				if err := vhs.writeFrame(counter, frame); err != nil {
+					ch <- err
 					continue
 				}
-				if err := os.WriteFile(
-					filepath.Join(vhs.Options.Video.Input, fmt.Sprintf(textFrameFormat, counter)),
-					text,
-					0o600,
-				); err != nil {
-					ch <- fmt.Errorf("error writing text frame: %w", err)
-					continue
-				}
-
-				// Capture current frame and disable frame capturing
-				if vhs.Options.Screenshot.frameCapture {
-					vhs.Options.Screenshot.makeScreenshot(counter)
-				}
 			}
 		}
 	}()
@@ -387,6 +532,171 @@ func (vhs *VHS) Record(ctx context.Context) <-chan error {
 	return ch
 }
 
+// captureFrame captures the cursor and text canvases as a normalized frame,
+// and the screen and output of the terminal when the SVG or the asciicast are
+// outputs.
+func (vhs *VHS) captureFrame() (capturedFrame, error) {
+	cursor, text, err := vhs.backend.CaptureFrame()
+	if err != nil {
+		return capturedFrame{}, err //nolint:wrapcheck
+	}
+	frame, err := vhs.normalizeFrames(cursor, text)
+	if err != nil {
+		return frame, err
+	}
+
+	output := vhs.Options.Video.Output
+	if output.screens() || (output.Cast != "" && vhs.cast == nil) {
+		s, err := vhs.backend.Screen()
+		if err != nil {
+			return capturedFrame{}, err //nolint:wrapcheck
+		}
+		frame.screen = &s
+	}
+	if output.Cast != "" {
+		vhs.mutex.Lock()
+		repaint := vhs.castRepaint || vhs.cast == nil
+		vhs.castRepaint = false
+		vhs.mutex.Unlock()
+
+		if vhs.cast == nil {
+			width, height := 0, len(frame.screen.cells)
+			if height > 0 {
+				width = len(frame.screen.cells[0])
+			}
+			vhs.cast = newCastRecorder(width, height)
+		}
+		frame.output, err = vhs.backend.Output()
+		if err != nil {
+			return capturedFrame{}, err //nolint:wrapcheck
+		}
+		if repaint {
+			// The output before the recording started, or while it was
+			// hidden, is replaced with the screen it left.
+			s := frame.screen
+			if s == nil {
+				sc, err := vhs.backend.Screen()
+				if err != nil {
+					return capturedFrame{}, err //nolint:wrapcheck
+				}
+				s = &sc
+			}
+			frame.output = castRepaintOutput(*s, vhs.Options.Theme)
+		}
+	}
+	return frame, nil
+}
+
+// discardOutput drains the output of the shell while the recording is hidden,
+// so that it isn't shown in the asciicast.
+func (vhs *VHS) discardOutput() {
+	if vhs.Options.Video.Output.Cast == "" || vhs.backend == nil {
+		return
+	}
+	_, _ = vhs.backend.Output()
+}
+
+// writeFrame encodes the frame with the given number into the videos, and
+// writes its cursor and text layers to disk when the frames are an output or
+// it is a screenshot.
+func (vhs *VHS) writeFrame(counter int, frame capturedFrame) error {
+	if vhs.encoder == nil {
+		var err error
+		vhs.encoder, err = newVideoEncoder(vhs.Options.Video, vhs.Options.Theme, frame.image.Rect.Size(), vhs.Options.LoopOffset > 0)
+		if err != nil {
+			return err
+		}
+	}
+	if err := vhs.encoder.Encode(frame.image); err != nil {
+		return err
+	}
+	if vhs.cast != nil && len(frame.output) > 0 {
+		vhs.cast.add(counter, frame.output)
+	}
+	if frame.screen != nil && vhs.Options.Video.Output.screens() {
+		if vhs.screens == nil {
+			vhs.screens = &screenRecorder{}
+		}
+		vhs.screens.add(*frame.screen)
+	}
+	vhs.mutex.Lock()
+	for _, title := range vhs.pendingChapters {
+		vhs.chapters = append(vhs.chapters, chapter{title: title, frame: counter})
+	}
+	vhs.pendingChapters = nil
+	vhs.mutex.Unlock()
+
+	screenshot := vhs.Options.Screenshot.frameCapture
+	if vhs.Options.Video.Output.Frames == "" && !screenshot {
+		return nil
+	}
+	if err := writeFrameFile(vhs.Options.Video.Input, cursorFrameFormat, counter, frame.cursor); err != nil {
+		return fmt.Errorf("error writing cursor frame: %w", err)
+	}
+	if err := writeFrameFile(vhs.Options.Video.Input, textFrameFormat, counter, frame.text); err != nil {
+		return fmt.Errorf("error writing text frame: %w", err)
+	}
+
+	// Capture current frame and disable frame capturing
+	if screenshot {
+		vhs.Options.Screenshot.makeScreenshot(counter)
+	}
+	return nil
+}
+
+// writeFrameFile writes a layer of the frame with the given number as a PNG
+// in the directory.
+func writeFrameFile(dir, format string, counter int, img image.Image) error {
+	b, err := encodeFrame(img)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, fmt.Sprintf(format, counter)), b, 0o600) //nolint:wrapcheck
+}
+
+// normalizeFrames decodes the cursor and text frames and normalizes them to
+// the layout of the first captured frame, so that settings changed while
+// recording don't change the dimensions of the frames.
+func (vhs *VHS) normalizeFrames(cursor, text []byte) (capturedFrame, error) {
+	cursorImg, err := decodeFrame(cursor)
+	if err != nil {
+		return capturedFrame{}, err
+	}
+	textImg, err := decodeFrame(text)
+	if err != nil {
+		return capturedFrame{}, err
+	}
+
+	vhs.mutex.Lock()
+	viewport, theme := vhs.viewport, vhs.Options.Theme
+	if vhs.layout == nil {
+		size := textImg.Bounds().Size()
+		vhs.layout = &frameLayout{Width: size.X, Height: size.Y, Viewport: viewport}
+	}
+	layout := *vhs.layout
+	vhs.mutex.Unlock()
+
+	background, err := parseHexColor(theme.Background)
+	if err != nil {
+		background = color.RGBA{black, black, black, white}
+	}
+	return newCapturedFrame(
+		normalizeFrame(textImg, layout, viewport, background),
+		normalizeFrame(cursorImg, layout, viewport, color.Transparent),
+	), nil
+}
+
+// markChapter starts a chapter of the HTML player with the title at the next
+// recorded frame, unless the recording is paused.
+func (vhs *VHS) markChapter(title string) {
+	vhs.mutex.Lock()
+	defer vhs.mutex.Unlock()
+
+	if vhs.recording {
+		vhs.pendingChapters = append(vhs.pendingChapters, title)
+	}
+}
+
 // ResumeRecording indicates to VHS that the recording should be resumed.
 func (vhs *VHS) ResumeRecording() {
 	vhs.mutex.Lock()
@@ -401,6 +711,7 @@ func (vhs *VHS) PauseRecording() {
 	defer vhs.mutex.Unlock()
 
 	vhs.recording = false
+	vhs.castRepaint = true
 }
 
 // ScreenshotNextFrame indicates to VHS that screenshot of next frame must be taken.
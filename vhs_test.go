@@ -0,0 +1,49 @@
+package main
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenderLateOutput(t *testing.T) {
+	dir := t.TempDir()
+	v := New()
+	v.Options.Video.Input = dir
+	e, err := newVideoEncoder(v.Options.Video, v.Options.Theme, image.Pt(8, 8), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.encoder = e
+	v.totalFrames = 1
+
+	// Output demo.mp4 in an If block, once the recording has started.
+	out := filepath.Join(dir, "demo.mp4")
+	v.Options.Video.Output.MP4 = out
+	if err := v.Render(); err != nil {
+		t.Fatalf("expected the late output to be ignored, got %v", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("expected no %s, got %v", out, err)
+	}
+}
+
+func TestRenderNoFrames(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "demo.txt")
+	v := New()
+	v.Options.Test.Output = out
+	v.testOutput.WriteString("> echo hidden\n")
+
+	// Hide without Show records no frames.
+	if err := v.Render(); err == nil {
+		t.Fatal("expected an error without frames")
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("expected the text output to be written, got %v", err)
+	}
+	if string(b) != "> echo hidden\n" {
+		t.Errorf("unexpected text output %q", b)
+	}
+}
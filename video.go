@@ -1,20 +1,32 @@
-// Package vhs video.go spawns the ffmpeg process to convert the frames,
-// collected by go-rod's  screenshots into the input folder, to a GIF, WebM,
-// MP4.
+// Package vhs video.go spawns the ffmpeg processes which encode the frames, as
+// they are recorded, into a GIF, WebM or MP4. The frames are streamed to each
+// process over a pipe, and only written to the input folder when the frames
+// are an output. Identical consecutive frames are merged as they are recorded,
+// and only the frames which change are streamed, each with its duration.
 //
-// MakeGIF takes several options to modify the behaviour of the ffmpeg process,
-// which can be configured through the Set command.
+// The encoding takes several options to modify the behaviour of the ffmpeg
+// processes, which can be configured through the Set command.
 //
 // Set MaxColors 256
 package main
 
 import (
+	"bytes"
+	"compress/flate"
+	"errors"
 	"fmt"
+	"image"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
+
+	version "github.com/hashicorp/go-version"
 )
 
 const (
@@ -26,6 +38,11 @@ const (
 	mp4  = ".mp4"
 	webm = ".webm"
 	gif  = ".gif"
+	webp = ".webp"
+	apng = ".apng"
+	svg  = ".svg"
+	cast = ".cast"
+	html = ".html"
 )
 
 // randomDir returns a random temporary directory to be used for storing frames
@@ -44,6 +61,11 @@ type VideoOutputs struct {
 	GIF    string
 	WebM   string
 	MP4    string
+	WebP   string
+	APNG   string
+	SVG    string
+	Cast   string
+	HTML   string
 	Frames string
 }
 
@@ -53,6 +75,10 @@ type VideoOptions struct {
 	PlaybackSpeed float64
 	Input         string
 	MaxColors     int
+	GIFEncoder    string
+	GIFDither     string
+	WebPQuality   int
+	WebPLossless  bool
 	Output        VideoOutputs
 	StartingFrame int
 	Style         *StyleOptions
@@ -61,6 +87,7 @@ type VideoOptions struct {
 const (
 	defaultFramerate     = 50
 	defaultStartingFrame = 1
+	defaultWebPQuality   = 75
 )
 
 // DefaultVideoOptions is the set of default options for converting frames
@@ -70,7 +97,9 @@ func DefaultVideoOptions() VideoOptions {
 		Framerate:     defaultFramerate,
 		Input:         randomDir(),
 		MaxColors:     defaultMaxColors,
-		Output:        VideoOutputs{GIF: "", WebM: "", MP4: "", Frames: ""},
+		GIFEncoder:    GIFEncoderFFmpeg,
+		WebPQuality:   defaultWebPQuality,
+		Output:        VideoOutputs{GIF: "", WebM: "", MP4: "", WebP: "", APNG: "", SVG: "", Cast: "", HTML: "", Frames: ""},
 		PlaybackSpeed: defaultPlaybackSpeed,
 		StartingFrame: defaultStartingFrame,
 	}
@@ -80,20 +109,357 @@ func marginFillIsColor(marginFill string) bool {
 	return strings.HasPrefix(marginFill, "#")
 }
 
-// makeMedia takes a list of images (as frames) and converts them to a GIF/WebM/MP4.
-func makeMedia(opts VideoOptions, targetFile string) *exec.Cmd {
-	if targetFile == "" {
+// videoEncoder encodes the recorded frames into the video outputs, by
+// streaming them to one ffmpeg process per output while recording. Identical
+// consecutive frames are merged into a single frame lasting as long as all of
+// them, so that only the frames which change are streamed: most of a
+// recording is static, e.g. during a Sleep.
+type videoEncoder struct {
+	opts      VideoOptions
+	theme     Theme
+	processes map[string]*ffmpegProcess
+	// native reports whether the GIF is encoded by the native encoder rather
+	// than by ffmpeg.
+	native bool
+
+	// last is the last recorded frame, which lasts for the given number of
+	// recorded frames until a different one is recorded, and recorded the
+	// number of frames recorded before it.
+	last     *image.RGBA
+	frames   int
+	recorded int
+
+	// runs holds the frames when they can only be encoded once the recording
+	// is over: when a loop offset moves the first frames to the end of the
+	// videos, which is only known then, or for the palette of the native GIF
+	// encoder.
+	runs       []frameRun
+	loopOffset bool
+}
+
+// frameRun is a frame held by the encoder, compressed, which lasts for a
+// number of recorded frames.
+type frameRun struct {
+	pix    []byte
+	size   image.Point
+	frames int
+}
+
+// length returns the number of recorded frames of the run.
+func (r frameRun) length() int { return r.frames }
+
+// withLength returns the run lasting for the number of recorded frames.
+func (r frameRun) withLength(frames int) frameRun {
+	return frameRun{pix: r.pix, size: r.size, frames: frames}
+}
+
+// run is a frame which lasts for a number of recorded frames.
+type run[R any] interface {
+	length() int
+	withLength(frames int) R
+}
+
+// newFrameRun returns the run of a frame lasting for the number of recorded
+// frames.
+func newFrameRun(frame *image.RGBA, frames int) (frameRun, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return frameRun{}, fmt.Errorf("could not compress frame: %w", err)
+	}
+	if _, err := w.Write(frame.Pix); err != nil {
+		return frameRun{}, fmt.Errorf("could not compress frame: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return frameRun{}, fmt.Errorf("could not compress frame: %w", err)
+	}
+	return frameRun{pix: buf.Bytes(), size: frame.Rect.Size(), frames: frames}, nil
+}
+
+// image returns the frame of the run.
+func (r frameRun) image() (*image.RGBA, error) {
+	img := image.NewRGBA(image.Rectangle{Max: r.size})
+	if _, err := io.ReadFull(flate.NewReader(bytes.NewReader(r.pix)), img.Pix); err != nil {
+		return nil, fmt.Errorf("could not decompress frame: %w", err)
+	}
+	return img, nil
+}
+
+// newVideoEncoder starts encoding frames of the given size into the video
+// outputs of the options, into files of the input folder until Finish. With a
+// loop offset, the frames are only streamed once the recording is over. The
+// theme is used for the palette of the native GIF encoder.
+func newVideoEncoder(opts VideoOptions, theme Theme, size image.Point, loopOffset bool) (*videoEncoder, error) {
+	e := &videoEncoder{opts: opts, theme: theme, processes: make(map[string]*ffmpegProcess), loopOffset: loopOffset}
+	for ext, output := range opts.Output.videos() {
+		if output == "" {
+			continue
+		}
+		if ext == gif && opts.GIFEncoder == GIFEncoderNative {
+			e.native = true
+			continue
+		}
+		p, err := startFFmpeg(opts, size, filepath.Join(opts.Input, "video"+ext))
+		if err != nil {
+			e.Abort()
+			return nil, err
+		}
+		e.processes[ext] = p
+	}
+	return e, nil
+}
+
+// encodes reports whether the encoder encodes the video with the given
+// extension, i.e. whether it was an output when the encoding started.
+func (e *videoEncoder) encodes(ext string) bool {
+	_, ok := e.processes[ext]
+	return ok || ext == gif && e.native
+}
+
+// Encode encodes the next recorded frame, which must be of the size of the
+// encoder. A frame identical to the previous one extends its duration instead
+// of being encoded.
+func (e *videoEncoder) Encode(frame *image.RGBA) error {
+	if len(e.processes) == 0 && !e.native {
 		return nil
 	}
+	if e.last != nil && bytes.Equal(e.last.Pix, frame.Pix) {
+		e.frames++
+		return nil
+	}
+	err := e.flush()
+	// Captured frames are never modified, so the last one can be kept as is.
+	e.last, e.frames = frame, 1
+	return err
+}
+
+// flush encodes the last frame, lasting for its number of recorded frames.
+func (e *videoEncoder) flush() error {
+	if e.last == nil {
+		return nil
+	}
+	if e.loopOffset || e.native {
+		run, err := newFrameRun(e.last, e.frames)
+		if err != nil {
+			return err
+		}
+		e.runs = append(e.runs, run)
+	}
+	if !e.loopOffset {
+		e.write(e.last.Pix, e.recorded, e.frames)
+	}
+	e.recorded += e.frames
+	e.last = nil
+	return nil
+}
+
+// write streams the pixels of a frame starting at the given recorded frame
+// and lasting for the given number of recorded frames to the ffmpeg
+// processes.
+func (e *videoEncoder) write(pix []byte, start, frames int) {
+	from, to := e.timestamp(start), e.timestamp(start+frames)
+	for _, p := range e.processes {
+		p.write(pix, from, to-from)
+	}
+}
+
+// timestamp returns the time at which the recorded frame with the given
+// number is shown.
+func (e *videoEncoder) timestamp(frame int) time.Duration {
+	return time.Duration(frame) * time.Second / time.Duration(e.opts.Framerate)
+}
+
+// Finish finishes encoding the videos and returns the files they were encoded
+// into by extension, along with the errors of the videos which couldn't be
+// encoded. The given number of first recorded frames are moved to the end of
+// the videos.
+func (e *videoEncoder) Finish(offset int) (map[string]string, error) {
+	if err := e.flush(); err != nil {
+		e.Abort()
+		return nil, err
+	}
+	runs := rotateRuns(e.runs, offset)
+	if e.loopOffset {
+		if err := e.replay(runs); err != nil {
+			e.Abort()
+			return nil, err
+		}
+	}
 
-	log.Println(GrayStyle.Render("Creating " + targetFile + "..."))
-	ensureDir(targetFile)
+	var errs []error
+	files := make(map[string]string)
+	for _, p := range e.processes {
+		p.close()
+	}
+	if e.native {
+		file := filepath.Join(e.opts.Input, "video"+gif)
+		if err := encodeGIF(e.opts, e.theme, runs, file); err != nil {
+			errs = append(errs, err)
+		} else {
+			files[gif] = file
+		}
+	}
+	for ext, p := range e.processes {
+		if err := p.wait(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		files[ext] = p.path
+	}
+	return files, errors.Join(errs...)
+}
+
+// replay streams the runs held until the end of the recording to the ffmpeg
+// processes.
+func (e *videoEncoder) replay(runs []frameRun) error {
+	if len(e.processes) == 0 {
+		return nil
+	}
+	start := 0
+	for _, run := range runs {
+		img, err := run.image()
+		if err != nil {
+			return err
+		}
+		e.write(img.Pix, start, run.frames)
+		start += run.frames
+	}
+	return nil
+}
 
+// rotateRuns moves the given number of first recorded frames of the runs to
+// their end, splitting the run they end in.
+func rotateRuns[R run[R]](runs []R, offset int) []R {
+	if offset <= 0 {
+		return runs
+	}
+
+	var head, tail []R
+	for _, r := range runs {
+		switch {
+		case offset <= 0:
+			head = append(head, r)
+		case r.length() <= offset:
+			tail = append(tail, r)
+			offset -= r.length()
+		default:
+			tail = append(tail, r.withLength(offset))
+			head = append(head, r.withLength(r.length()-offset))
+			offset = 0
+		}
+	}
+	return append(head, tail...)
+}
+
+// Abort stops encoding the videos.
+func (e *videoEncoder) Abort() {
+	for _, p := range e.processes {
+		p.kill()
+	}
+}
+
+// ffmpegProcess is an ffmpeg process encoding the frames written on its
+// standard input into a file.
+type ffmpegProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	output bytes.Buffer
+	path   string
+	// err is the first error writing to the process, after which frames are
+	// dropped.
+	err error
+}
+
+// startFFmpeg starts an ffmpeg process encoding frames of the given size into
+// the file.
+func startFFmpeg(opts VideoOptions, size image.Point, path string) (*ffmpegProcess, error) {
+	p := &ffmpegProcess{path: path}
 	//nolint:gosec
-	return exec.Command(
-		"ffmpeg",
-		buildFFopts(opts, targetFile)...,
-	)
+	p.cmd = exec.Command("ffmpeg", buildFFopts(opts, path)...)
+	p.cmd.Stdout = &p.output
+	p.cmd.Stderr = &p.output
+
+	var err error
+	p.stdin, err = p.cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("could not open ffmpeg input: %w", err)
+	}
+	if err := p.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not start ffmpeg: %w", err)
+	}
+	p.err = writeMatroskaHeader(p.stdin, size)
+	return p, nil
+}
+
+// write writes a frame shown at the given time for the given duration to
+// the process.
+func (p *ffmpegProcess) write(pix []byte, start, duration time.Duration) {
+	if p.err != nil {
+		return
+	}
+	p.err = writeMatroskaFrame(p.stdin, pix, start, duration)
+}
+
+// close closes the input of the process, which finishes encoding.
+func (p *ffmpegProcess) close() {
+	_ = p.stdin.Close()
+}
+
+// wait closes the input of the process and waits for it to finish encoding.
+func (p *ffmpegProcess) wait() error {
+	p.close()
+	if err := p.cmd.Wait(); err != nil || p.err != nil {
+		return fmt.Errorf("could not encode %s: %w\n%s", p.path, errors.Join(p.err, err), p.output.String())
+	}
+	return nil
+}
+
+// kill kills the process.
+func (p *ffmpegProcess) kill() {
+	p.close()
+	if p.cmd.Process != nil {
+		_ = p.cmd.Process.Kill()
+		_ = p.cmd.Wait()
+	}
+}
+
+// videos returns the paths of the video outputs by extension.
+func (o VideoOutputs) videos() map[string]string {
+	return map[string]string{gif: o.GIF, webm: o.WebM, mp4: o.MP4, webp: o.WebP, apng: o.APNG}
+}
+
+// screens reports whether an output is drawn from the screens of the terminal
+// rather than from its frames.
+func (o VideoOutputs) screens() bool {
+	return o.SVG != "" || o.HTML != ""
+}
+
+// moveFile moves a file, copying it when it can't be renamed, e.g. across
+// file systems.
+func moveFile(src, dst string) error {
+	ensureDir(dst)
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("could not open %s: %w", src, err)
+	}
+	defer in.Close() //nolint:errcheck
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("could not create %s: %w", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("could not write %s: %w", dst, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("could not write %s: %w", dst, err)
+	}
+	return os.Remove(src) //nolint:wrapcheck
 }
 
 // ensureDir ensures that the file path of the output can be created by
@@ -105,24 +471,21 @@ func ensureDir(output string) {
 	}
 }
 
-// buildFFopts assembles an ffmpeg command from some VideoOptions.
+// buildFFopts assembles an ffmpeg command from some VideoOptions, which
+// encodes the frames of the Matroska stream read from its standard input,
+// keeping their durations.
 func buildFFopts(opts VideoOptions, targetFile string) []string {
 	var args []string //nolint:prealloc
-	streamCounter := 2
+	streamCounter := 1
 
 	streamBuilder := NewStreamBuilder(streamCounter, opts.Input, opts.Style)
 
 	// Input frame options, used no matter what
-	// Stream 0: text frames
-	// Stream 1: cursor frames
+	// Stream 0: frames
 	streamBuilder.args = append(streamBuilder.args,
 		"-y",
-		"-r", fmt.Sprint(opts.Framerate),
-		"-start_number", fmt.Sprint(opts.StartingFrame),
-		"-i", filepath.Join(opts.Input, textFrameFormat),
-		"-r", fmt.Sprint(opts.Framerate),
-		"-start_number", fmt.Sprint(opts.StartingFrame),
-		"-i", filepath.Join(opts.Input, cursorFrameFormat),
+		"-f", "matroska",
+		"-i", "pipe:0",
 	)
 
 	streamBuilder = streamBuilder.
@@ -138,31 +501,54 @@ func buildFFopts(opts VideoOptions, targetFile string) []string {
 	// Format-specific options
 	switch filepath.Ext(targetFile) {
 	case gif:
-		filterBuilder = filterBuilder.WithGIF()
+		filterBuilder = filterBuilder.WithGIF(opts.MaxColors, opts.GIFDither)
 	case webm:
 		streamBuilder = streamBuilder.WithWebm()
 	case mp4:
 		streamBuilder = streamBuilder.WithMP4()
+	case webp:
+		streamBuilder = streamBuilder.WithWebP(opts.WebPQuality, opts.WebPLossless)
+	case apng:
+		streamBuilder = streamBuilder.WithAPNG()
 	}
 
 	args = append(args, streamBuilder.Build()...)
 	args = append(args, filterBuilder.Build()...)
+	args = append(args, vfrOptions(ffmpegVersion())...)
 	args = append(args, targetFile)
 
 	return args
 }
 
-// MakeGIF takes a list of images (as frames) and converts them to a GIF.
-func MakeGIF(opts VideoOptions) *exec.Cmd {
-	return makeMedia(opts, opts.Output.GIF)
-}
+// fpsModeVersion is the version of ffmpeg which replaced the -vsync option
+// with -fps_mode.
+var fpsModeVersion = version.Must(version.NewVersion("5.1"))
 
-// MakeWebM takes a list of images (as frames) and converts them to a WebM.
-func MakeWebM(opts VideoOptions) *exec.Cmd {
-	return makeMedia(opts, opts.Output.WebM)
-}
+// ffmpegVersionRegex matches the version of ffmpeg, e.g. "ffmpeg version
+// 6.1.1" or "ffmpeg version n5.1", but not the date of a development build.
+var ffmpegVersionRegex = regexp.MustCompile(`ffmpeg version n?(\d+\.\d+)`)
+
+// ffmpegVersion returns the version of ffmpeg, or nil if it is unknown, e.g.
+// for development builds.
+var ffmpegVersion = sync.OnceValue(func() *version.Version {
+	out, err := exec.Command("ffmpeg", "-version").Output()
+	if err != nil {
+		return nil
+	}
+	m := ffmpegVersionRegex.FindSubmatch(out)
+	if m == nil {
+		return nil
+	}
+	v, _ := version.NewVersion(string(m[1]))
+	return v
+})
 
-// MakeMP4 takes a list of images (as frames) and converts them to an MP4.
-func MakeMP4(opts VideoOptions) *exec.Cmd {
-	return makeMedia(opts, opts.Output.MP4)
+// vfrOptions returns the options of the given version of ffmpeg which keep
+// the durations of the frames rather than duplicating them. Unknown versions
+// are assumed to be recent.
+func vfrOptions(v *version.Version) []string {
+	if v != nil && v.LessThan(fpsModeVersion) {
+		return []string{"-vsync", "vfr"}
+	}
+	return []string{"-fps_mode", "vfr"}
 }
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	version "github.com/hashicorp/go-version"
+)
+
+func TestVideoEncoder(t *testing.T) {
+	opts := DefaultVideoOptions()
+	_ = os.RemoveAll(opts.Input)
+	opts.Input = t.TempDir()
+	opts.GIFEncoder = GIFEncoderNative
+	opts.Output.GIF = filepath.Join(opts.Input, "out.gif")
+
+	e, err := newVideoEncoder(opts, DefaultTheme, image.Pt(2, 1), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []uint8{1, 1, 2, 2, 2, 3} {
+		if err := e.Encode(solidFrame(2, 1, color.RGBA{c, 0, 0, 0xFF})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	runs := func(runs []frameRun) [][2]int {
+		var got [][2]int
+		for _, run := range runs {
+			img, err := run.image()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, [2]int{int(img.Pix[0]), run.frames})
+		}
+		return got
+	}
+	if got, want := runs(e.runs), [][2]int{{1, 2}, {2, 3}, {3, 1}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected runs %v, got %v", want, got)
+	}
+
+	tests := []struct {
+		name   string
+		offset int
+		want   [][2]int
+	}{
+		{"no offset", 0, [][2]int{{1, 2}, {2, 3}, {3, 1}}},
+		{"offset within run", 3, [][2]int{{2, 2}, {3, 1}, {1, 2}, {2, 1}}},
+		{"offset at end of run", 2, [][2]int{{2, 3}, {3, 1}, {1, 2}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := runs(rotateRuns(e.runs, tc.offset)); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected runs %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestVideoEncoderStream(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ffmpeg is a shell script")
+	}
+	// The fake ffmpeg keeps the stream it reads next to the video.
+	bin := t.TempDir()
+	script := "#!/bin/sh\n[ \"$1\" = -version ] && exit 1\nfor last; do :; done\ncat > \"$last.mkv\"\necho x > \"$last\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "ffmpeg"), []byte(script), 0o755); err != nil { //nolint:gosec
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	opts := DefaultVideoOptions()
+	_ = os.RemoveAll(opts.Input)
+	opts.Input = t.TempDir()
+	opts.Framerate = 10
+	opts.Style = DefaultStyleOptions()
+	opts.Output.GIF = "out.gif"
+	opts.Output.MP4 = "out.mp4"
+	opts.Output.WebM = "out.webm"
+
+	e, err := newVideoEncoder(opts, DefaultTheme, image.Pt(2, 1), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []uint8{1, 1, 2, 2, 2, 3} {
+		if err := e.Encode(solidFrame(2, 1, color.RGBA{c, 0, 0, 0xFF})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := e.Finish(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Errorf("expected 3 videos, got %v", files)
+	}
+
+	var pngs []string
+	err = filepath.WalkDir(opts.Input, func(path string, _ fs.DirEntry, err error) error {
+		if ok, _ := filepath.Match("frame-*.png", filepath.Base(path)); ok {
+			pngs = append(pngs, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pngs) != 0 {
+		t.Errorf("expected no frames to be written, got %v", pngs)
+	}
+
+	stream, err := os.ReadFile(files[mp4] + ".mkv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, frames := readMatroska(t, stream)
+	if size != image.Pt(2, 1) {
+		t.Errorf("expected frames of 2x1, got %v", size)
+	}
+	var got [][3]uint64
+	for _, f := range frames {
+		got = append(got, [3]uint64{uint64(f.pix[0]), f.start, f.duration})
+	}
+	want := [][3]uint64{{1, 0, 200_000}, {2, 200_000, 300_000}, {3, 500_000, 100_000}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected frames (color, start, duration) %v, got %v", want, got)
+	}
+}
+
+func TestVideoEncoderWithoutVideos(t *testing.T) {
+	opts := DefaultVideoOptions()
+	_ = os.RemoveAll(opts.Input)
+	opts.Input = t.TempDir()
+
+	e, err := newVideoEncoder(opts, DefaultTheme, image.Pt(2, 1), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Encode(solidFrame(2, 1, color.RGBA{1, 0, 0, 0xFF})); err != nil {
+		t.Fatal(err)
+	}
+	files, err := e.Finish(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 || len(e.runs) != 0 {
+		t.Errorf("expected nothing to be encoded, got %v and %d runs", files, len(e.runs))
+	}
+}
+
+func TestVFROptions(t *testing.T) {
+	tests := []struct {
+		version string
+		want    []string
+	}{
+		{"", []string{"-fps_mode", "vfr"}},
+		{"4.4", []string{"-vsync", "vfr"}},
+		{"5.0.1", []string{"-vsync", "vfr"}},
+		{"5.1", []string{"-fps_mode", "vfr"}},
+		{"7.1", []string{"-fps_mode", "vfr"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.version, func(t *testing.T) {
+			var v *version.Version
+			if tc.version != "" {
+				v = version.Must(version.NewVersion(tc.version))
+			}
+			if got := vfrOptions(v); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestBuildFFoptsFormats(t *testing.T) {
+	opts := DefaultVideoOptions()
+	_ = os.RemoveAll(opts.Input)
+	opts.Input = t.TempDir()
+	opts.Style = DefaultStyleOptions()
+	opts.WebPQuality = 90
+
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"out.webp", []string{"-vcodec", "libwebp", "-lossless", "0", "-quality", "90", "-loop", "0"}},
+		{"out.apng", []string{"-f", "apng", "-plays", "0"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.target, func(t *testing.T) {
+			args := strings.Join(buildFFopts(opts, tc.target), " ")
+			if !strings.Contains(args, strings.Join(tc.want, " ")) {
+				t.Errorf("expected %q in %q", tc.want, args)
+			}
+			if !strings.HasSuffix(args, tc.target) {
+				t.Errorf("expected %s as output, got %q", tc.target, args)
+			}
+		})
+	}
+}